@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
 
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
@@ -13,8 +15,14 @@ import (
 )
 
 func main() {
-	// Чтение флагов
-	configuration := config.GenerateConfig()
+	// Чтение конфигурации
+	configuration, err := config.GenerateConfig()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Ошибка конфигурации: %v", err)
+	}
 
 	// Инициализация логгера
 	middleware.InitLogger()
@@ -23,7 +31,7 @@ func main() {
 	ginEngine := gin.Default()
 
 	// Создание репозитория в зависимости от конфигурации
-	repo := repository.CreateRepository(configuration.AddressDB, configuration.FilePath)
+	repo := repository.CreateRepository(configuration)
 
 	// Создание сервиса
 	shortService := service.NewURLShortnerService(repo, configuration)
@@ -32,7 +40,7 @@ func main() {
 	handler.NewHandler(ginEngine, shortService, configuration)
 
 	// Создание и запуск сервера с graceful shutdown
-	srv := server.NewServer(configuration.Port, ginEngine, shortService)
+	srv := server.NewServer(configuration, ginEngine, shortService)
 	if err := srv.Start(); err != nil {
 		log.Fatalf("Ошибка работы сервера: %v", err)
 	}
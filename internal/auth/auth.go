@@ -13,13 +13,16 @@ import (
 
 // AuthService предоставляет функциональность для аутентификации пользователей
 type AuthService struct {
-	secretKey []byte
+	secretKey    []byte
+	secureCookie bool
 }
 
 // NewAuthService создает новый экземпляр AuthService
-func NewAuthService(secretKey string) *AuthService {
+// secureCookie включает флаг Secure у выдаваемых кук (при работе по HTTPS)
+func NewAuthService(secretKey string, secureCookie bool) *AuthService {
 	return &AuthService{
-		secretKey: []byte(secretKey),
+		secretKey:    []byte(secretKey),
+		secureCookie: secureCookie,
 	}
 }
 
@@ -35,17 +38,19 @@ func (a *AuthService) SignValue(value string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// SignedToken возвращает ID пользователя с подписью в формате значения куки
+func (a *AuthService) SignedToken(userID string) string {
+	return fmt.Sprintf("%s:%s", userID, a.SignValue(userID))
+}
+
 // CreateSignedCookie создает подписанную куку с ID пользователя
 func (a *AuthService) CreateSignedCookie(userID string) *http.Cookie {
-	signature := a.SignValue(userID)
-	cookieValue := fmt.Sprintf("%s:%s", userID, signature)
-	
 	return &http.Cookie{
 		Name:     "user_id",
-		Value:    cookieValue,
+		Value:    a.SignedToken(userID),
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   a.secureCookie,
 		SameSite: http.SameSiteLaxMode,
 		MaxAge:   int(30 * 24 * time.Hour.Seconds()),
 	}
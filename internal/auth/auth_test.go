@@ -9,7 +9,7 @@ import (
 // TestAuthService проверяет основную функциональность сервиса аутентификации
 func TestAuthService(t *testing.T) {
 	// Создаем сервис аутентификации
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", false)
 
 	// Тест 1: Генерация пользователя
 	userID := authService.GenerateUserID()
@@ -72,7 +72,7 @@ func TestAuthService(t *testing.T) {
 
 // TestSignValue проверяет подписание значений
 func TestSignValue(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", false)
 
 	value := "test-value"
 	signature1 := authService.SignValue(value)
@@ -95,7 +95,7 @@ func TestSignValue(t *testing.T) {
 
 // TestCookieFormat проверяет формат куки
 func TestCookieFormat(t *testing.T) {
-	authService := NewAuthService("test-secret-key")
+	authService := NewAuthService("test-secret-key", false)
 
 	userID := "test-user-id"
 	cookie := authService.CreateSignedCookie(userID)
@@ -116,3 +116,13 @@ func TestCookieFormat(t *testing.T) {
 		t.Errorf("Second part should be signature %s, got %s", expectedSignature, parts[1])
 	}
 }
+
+// TestSecureCookie проверяет флаг Secure при работе по HTTPS
+func TestSecureCookie(t *testing.T) {
+	if cookie := NewAuthService("test-secret-key", false).CreateSignedCookie("user"); cookie.Secure {
+		t.Error("Cookie should not be secure without HTTPS")
+	}
+	if cookie := NewAuthService("test-secret-key", true).CreateSignedCookie("user"); !cookie.Secure {
+		t.Error("Cookie should be secure with HTTPS")
+	}
+}
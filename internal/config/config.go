@@ -1,26 +1,258 @@
 package config
 
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
+)
+
+// Пути для кэширования самоподписанного сертификата по умолчанию
+const (
+	DefaultTLSCertFile = "data/tls/cert.pem"
+	DefaultTLSKeyFile  = "data/tls/key.pem"
+)
+
+// Параметры пользовательских алиасов по умолчанию
+const (
+	DefaultAliasCharset   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+	DefaultAliasMinLength = 3
+	DefaultAliasMaxLength = 64
+)
+
+// DefaultReservedAliases алиасы, совпадающие с маршрутами сервиса
+var DefaultReservedAliases = []string{"ping", "api", "metrics", "healthz", "readyz"}
+
+// DefaultURLAllowedSchemes схемы ссылок, которые можно сокращать по умолчанию
+var DefaultURLAllowedSchemes = []string{"http", "https"}
+
+// Параметры перенаправления по умолчанию
+// Временное перенаправление не кешируется, поэтому каждый переход учитывается в статистике
+const (
+	DefaultRedirectCode   = http.StatusTemporaryRedirect
+	DefaultRedirectMaxAge = 24 * time.Hour
+)
+
+// Параметры очистки просроченных ссылок по умолчанию
+const (
+	DefaultExpiredSweepInterval = time.Minute
+	DefaultExpiredRetention     = 24 * time.Hour
+)
+
+// Параметры буфера событий переходов по умолчанию
+const (
+	DefaultClickBufferSize    = 10000
+	DefaultClickFlushInterval = time.Second
+)
+
+// DefaultStreamChunkSize количество строк потокового импорта, сохраняемых одной записью, по умолчанию
+const DefaultStreamChunkSize = 500
+
+// DefaultIdempotencyTTL срок хранения ответов на запросы с ключом идемпотентности по умолчанию
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// Таймауты операций с базой данных по умолчанию
+const (
+	DefaultDBReadTimeout  = 5 * time.Second
+	DefaultDBWriteTimeout = 10 * time.Second
+)
+
+// Стратегии генерации коротких ключей
+const (
+	KeyStrategyRandom   = "random"
+	KeyStrategySequence = "sequence"
+)
+
+// Параметры генерации коротких ключей по умолчанию
+// Алфавит base62 без похожих символов 0/O/o и 1/l/I
+const (
+	DefaultKeyStrategy = KeyStrategyRandom
+	DefaultKeyLength   = 6
+	DefaultKeyAlphabet = "23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// Параметры кеша ссылок по умолчанию
+const (
+	DefaultCacheSize        = 10000
+	DefaultCacheTTL         = 5 * time.Minute
+	DefaultCacheNegativeTTL = 30 * time.Second
+)
+
+// Параметры журнала файлового хранилища по умолчанию
+const (
+	DefaultFileSyncPolicy       = string(persistence.SyncInterval)
+	DefaultFileSyncInterval     = time.Second
+	DefaultFileCompactThreshold = 1000
+)
+
+// Параметры переключения на локальное хранилище при сбое базы данных по умолчанию
+const (
+	DefaultFailoverSpoolPath     = "data/failover.jsonl"
+	DefaultFailoverProbeInterval = 5 * time.Second
+)
+
+// Хранилища состояния ограничения частоты запросов
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStorePostgres = "postgres"
+)
+
+// DefaultRateLimitStore хранилище состояния ограничения частоты запросов по умолчанию
+// Сами лимиты по умолчанию выключены, они включаются флагами -rate-limit-create, -rate-limit-batch
+// и -rate-limit-redirect или переменными RATE_LIMIT_CREATE, RATE_LIMIT_BATCH и RATE_LIMIT_REDIRECT, например 100/1m
+const DefaultRateLimitStore = RateLimitStoreMemory
+
+// DefaultShutdownDrainDelay время между снятием готовности и остановкой приёма запросов по умолчанию
+// За это время балансировщик успевает заметить отказ проверки готовности
+const DefaultShutdownDrainDelay = 5 * time.Second
+
 // Структура для конфига
+// Теги json задают имена полей в файле конфигурации
 type ConfigStruct struct {
-	Protocol      string
-	Port          string
-	ShortAddress  string
-	FilePath      string
-	AddressDB     string
-	AuthSecretKey string
-}
+	Protocol      string `json:"-"`
+	ServerAddress string `json:"server_address"`
+	Port          string `json:"-"`
+	ShortAddress  string `json:"base_url"`
+	FilePath      string `json:"file_storage_path"`
+	AddressDB     string `json:"database_dsn"`
+	AuthSecretKey string `json:"auth_secret_key"`
+	TrustedSubnet string `json:"trusted_subnet"`
+	EnableHTTPS   bool   `json:"enable_https"`
+	TLSCertFile   string `json:"tls_cert_file"`
+	TLSKeyFile    string `json:"tls_key_file"`
+	GRPCAddress   string `json:"grpc_address"`
+
+	// TrustedProxies адреса и подсети прокси, которым доверяется X-Forwarded-For, по умолчанию никому
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	AliasCharset    string   `json:"alias_charset"`
+	AliasMinLength  int      `json:"alias_min_length"`
+	AliasMaxLength  int      `json:"alias_max_length"`
+	ReservedAliases []string `json:"reserved_aliases"`
+
+	URLAllowedSchemes []string `json:"url_allowed_schemes"`
+	URLResolveHosts   bool     `json:"url_resolve_hosts"`
+	URLBlocklistPath  string   `json:"url_blocklist_path"`
+	URLSortQuery      bool     `json:"url_sort_query"`
+
+	RedirectCode   int      `json:"redirect_code"`
+	RedirectMaxAge Duration `json:"redirect_max_age"`
+
+	ExpiredSweepInterval Duration `json:"expired_sweep_interval"`
+	ExpiredRetention     Duration `json:"expired_retention"`
+
+	ClickBufferSize    int      `json:"click_buffer_size"`
+	ClickFlushInterval Duration `json:"click_flush_interval"`
+
+	StreamChunkSize int `json:"stream_chunk_size"`
+
+	IdempotencyTTL Duration `json:"idempotency_ttl"`
+
+	DBReadTimeout  Duration `json:"db_read_timeout"`
+	DBWriteTimeout Duration `json:"db_write_timeout"`
 
-// Генерация конфигурации
-func GenerateConfig() *ConfigStruct {
-	// Получение данных из флагов
-	reqAddr, resAddr, filePath, dbAddress := parseFlags()
+	KeyStrategy string `json:"key_strategy"`
+	KeyLength   int    `json:"key_length"`
+	KeyAlphabet string `json:"key_alphabet"`
+	KeyPoolSize int    `json:"key_pool_size"`
 
+	CacheSize        int      `json:"cache_size"`
+	CacheTTL         Duration `json:"cache_ttl"`
+	CacheNegativeTTL Duration `json:"cache_negative_ttl"`
+
+	FileSyncPolicy       string   `json:"file_sync_policy"`
+	FileSyncInterval     Duration `json:"file_sync_interval"`
+	FileCompactThreshold int      `json:"file_compact_threshold"`
+
+	FailoverSpoolPath     string   `json:"failover_spool_path"`
+	FailoverProbeInterval Duration `json:"failover_probe_interval"`
+
+	ShutdownDrainDelay Duration `json:"shutdown_drain_delay"`
+
+	RateLimitStore    string    `json:"rate_limit_store"`
+	RateLimitCreate   RateLimit `json:"rate_limit_create"`
+	RateLimitBatch    RateLimit `json:"rate_limit_batch"`
+	RateLimitRedirect RateLimit `json:"rate_limit_redirect"`
+}
+
+// defaultConfig возвращает конфигурацию со значениями по умолчанию
+func defaultConfig() *ConfigStruct {
 	return &ConfigStruct{
 		Protocol:      "http://",
-		Port:          reqAddr,
-		ShortAddress:  resAddr,
-		FilePath:      filePath,
-		AddressDB:     dbAddress,
-		AuthSecretKey: "your-secret-key-change-in-production", // В продакшене должен быть из переменной окружения
+		ServerAddress: "localhost:8080",
+		ShortAddress:  "http://localhost:8080",
+		GRPCAddress:   ":3200",
+		FilePath:      "data/urls.json",
+		AuthSecretKey: "your-secret-key-change-in-production", // Переопределяется через файл, флаг или AUTH_SECRET_KEY
+
+		AliasCharset:    DefaultAliasCharset,
+		AliasMinLength:  DefaultAliasMinLength,
+		AliasMaxLength:  DefaultAliasMaxLength,
+		ReservedAliases: append([]string(nil), DefaultReservedAliases...),
+
+		URLAllowedSchemes: append([]string(nil), DefaultURLAllowedSchemes...),
+
+		RedirectCode:   DefaultRedirectCode,
+		RedirectMaxAge: Duration(DefaultRedirectMaxAge),
+
+		ExpiredSweepInterval: Duration(DefaultExpiredSweepInterval),
+		ExpiredRetention:     Duration(DefaultExpiredRetention),
+
+		ClickBufferSize:    DefaultClickBufferSize,
+		ClickFlushInterval: Duration(DefaultClickFlushInterval),
+
+		StreamChunkSize: DefaultStreamChunkSize,
+
+		IdempotencyTTL: Duration(DefaultIdempotencyTTL),
+
+		DBReadTimeout:  Duration(DefaultDBReadTimeout),
+		DBWriteTimeout: Duration(DefaultDBWriteTimeout),
+
+		KeyStrategy: DefaultKeyStrategy,
+		KeyLength:   DefaultKeyLength,
+		KeyAlphabet: DefaultKeyAlphabet,
+
+		CacheSize:        DefaultCacheSize,
+		CacheTTL:         Duration(DefaultCacheTTL),
+		CacheNegativeTTL: Duration(DefaultCacheNegativeTTL),
+
+		FileSyncPolicy:       DefaultFileSyncPolicy,
+		FileSyncInterval:     Duration(DefaultFileSyncInterval),
+		FileCompactThreshold: DefaultFileCompactThreshold,
+
+		FailoverSpoolPath:     DefaultFailoverSpoolPath,
+		FailoverProbeInterval: Duration(DefaultFailoverProbeInterval),
+
+		ShutdownDrainDelay: Duration(DefaultShutdownDrainDelay),
+
+		RateLimitStore: DefaultRateLimitStore,
+	}
+}
+
+// Генерация конфигурации из аргументов командной строки, файла и окружения
+func GenerateConfig() (*ConfigStruct, error) {
+	return ParseConfig(os.Args[1:])
+}
+
+// ParseConfig собирает конфигурацию с приоритетом: умолчание < файл < флаг < окружение
+// Все ошибки в значениях возвращаются одной агрегированной ошибкой
+func ParseConfig(args []string) (*ConfigStruct, error) {
+	cfg := defaultConfig()
+
+	// Получение данных из файла, флагов и окружения
+	if err := parseFlags(cfg, args); err != nil {
+		return nil, err
 	}
+
+	// В режиме HTTPS переключаем протокол и базовый адрес коротких ссылок
+	if cfg.EnableHTTPS {
+		cfg.Protocol = "https://"
+		if strings.HasPrefix(cfg.ShortAddress, "http://") {
+			cfg.ShortAddress = "https://" + strings.TrimPrefix(cfg.ShortAddress, "http://")
+		}
+	}
+
+	return cfg, nil
 }
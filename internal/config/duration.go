@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration длительность, которая в файле конфигурации и флагах задаётся строкой вида "1h30m"
+type Duration time.Duration
+
+// String возвращает строковое представление длительности
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set разбирает длительность из строки, реализует flag.Value
+func (d *Duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON сохраняет длительность строкой
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON читает длительность из строки
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.Set(value)
+}
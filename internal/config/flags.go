@@ -1,85 +1,517 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/keygen"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
 )
 
-// parseFlags обрабатывает аргументы командной строки
-func parseFlags() (string, string, string, string) {
+// parseFlags заполняет конфигурацию из файла, аргументов командной строки и переменных окружения
+func parseFlags(cfg *ConfigStruct, args []string) error {
+	var errs []error
+
+	// Первый проход нужен только для пути к файлу конфигурации,
+	// остальные флаги применяются после файла, чтобы иметь над ним приоритет
+	scratch := *cfg
+	var configPath string
+	preFS := newFlagSet(&scratch, &configPath)
+	preFS.SetOutput(io.Discard)
+	if err := preFS.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return fmt.Errorf("invalid flags: %w", err)
+	}
+	if envConfig := os.Getenv("CONFIG"); envConfig != "" {
+		configPath = envConfig
+	}
+
+	// Файл конфигурации
+	if configPath != "" {
+		if err := loadConfigFile(cfg, configPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Флаги командной строки, значения по умолчанию уже учитывают файл
+	fs := newFlagSet(cfg, &configPath)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Переменные окружения (наивысший приоритет)
+	errs = append(errs, applyEnv(cfg)...)
+
+	// Проверка и нормализация значений
+	errs = append(errs, validate(cfg)...)
+
+	return errors.Join(errs...)
+}
+
+// newFlagSet описывает флаги командной строки, привязанные к полям конфигурации
+func newFlagSet(cfg *ConfigStruct, configPath *string) *flag.FlagSet {
 	// Создаем новый FlagSet для избежания конфликтов в тестах
 	fs := flag.NewFlagSet("config", flag.ContinueOnError)
-	
+
+	// путь к JSON файлу конфигурации
+	fs.StringVar(configPath, "c", *configPath, "path to JSON config file")
+
 	// адрес запуска HTTP-сервера значением localhost:8080 по умолчанию
-	portFlag := fs.String("a", "localhost:8080", "address and port to run server")
+	fs.StringVar(&cfg.ServerAddress, "a", cfg.ServerAddress, "address and port to run server")
+
+	// адрес gRPC-сервера, пустое значение отключает gRPC API
+	fs.StringVar(&cfg.GRPCAddress, "grpc-address", cfg.GRPCAddress, "address and port to run gRPC server, empty disables")
 
 	// базовый адрес результирующего сокращённого URL значением
-	resAddressFlag := fs.String("b", "http://localhost:8080", "address and port for short url")
+	fs.StringVar(&cfg.ShortAddress, "b", cfg.ShortAddress, "address and port for short url")
 
 	// путь к файлу с урлами, значение data/urls.json по умолчанию
-	filePathFlag := fs.String("f", "data/urls.json", "path to the file for storing data")
+	fs.StringVar(&cfg.FilePath, "f", cfg.FilePath, "path to the file for storing data")
 
 	// адрес для базы данных
-	addressFlagDB := fs.String("d", "", "database address")
+	fs.StringVar(&cfg.AddressDB, "d", cfg.AddressDB, "database address")
+
+	// секретный ключ для подписи кук
+	fs.StringVar(&cfg.AuthSecretKey, "auth-secret", cfg.AuthSecretKey, "secret key for signing auth cookies")
+
+	// доверенная подсеть в формате CIDR для внутренних эндпоинтов
+	fs.StringVar(&cfg.TrustedSubnet, "t", cfg.TrustedSubnet, "trusted subnet in CIDR notation")
+	fs.Func("trusted-proxies", "comma-separated proxy addresses or CIDRs allowed to set X-Forwarded-For", func(value string) error {
+		cfg.TrustedProxies = splitList(value)
+		return nil
+	})
+
+	// включение HTTPS
+	fs.BoolVar(&cfg.EnableHTTPS, "s", cfg.EnableHTTPS, "enable HTTPS")
+
+	// пути к сертификату и ключу TLS, при отсутствии генерируется самоподписанный
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "path to TLS certificate file")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "path to TLS private key file")
+
+	// ограничения для пользовательских алиасов
+	fs.StringVar(&cfg.AliasCharset, "alias-charset", cfg.AliasCharset, "allowed characters for custom aliases")
+	fs.IntVar(&cfg.AliasMinLength, "alias-min", cfg.AliasMinLength, "minimal custom alias length")
+	fs.IntVar(&cfg.AliasMaxLength, "alias-max", cfg.AliasMaxLength, "maximal custom alias length")
+	fs.Func("alias-reserved", "comma-separated list of reserved aliases", func(value string) error {
+		cfg.ReservedAliases = splitList(value)
+		return nil
+	})
+
+	// ограничения сокращаемых ссылок: допустимые схемы, проверка адресов хостов через DNS и файл заблокированных доменов
+	fs.Func("url-schemes", "comma-separated list of allowed URL schemes", func(value string) error {
+		cfg.URLAllowedSchemes = splitList(value)
+		return nil
+	})
+	fs.BoolVar(&cfg.URLResolveHosts, "url-resolve", cfg.URLResolveHosts, "resolve link hosts via DNS and reject those pointing at non-public addresses (literal IPs are always checked)")
+	fs.StringVar(&cfg.URLBlocklistPath, "url-blocklist", cfg.URLBlocklistPath, "path to the file of blocked domains, reloaded on change")
+	fs.BoolVar(&cfg.URLSortQuery, "url-sort-query", cfg.URLSortQuery, "sort query parameters when comparing links for deduplication")
+
+	// перенаправление по ссылкам: код для ссылок без собственного кода и время кеширования постоянных перенаправлений
+	fs.IntVar(&cfg.RedirectCode, "redirect-code", cfg.RedirectCode, "default redirect status code: 301, 302, 307 or 308")
+	fs.Var(&cfg.RedirectMaxAge, "redirect-max-age", "how long browsers and CDNs may cache permanent redirects")
+
+	// очистка просроченных ссылок: период запуска (0 отключает) и срок хранения после истечения
+	fs.Var(&cfg.ExpiredSweepInterval, "expired-sweep-interval", "interval between expired links sweeps, 0 disables")
+	fs.Var(&cfg.ExpiredRetention, "expired-retention", "how long expired links are kept before purge")
+
+	// буфер событий переходов: ёмкость и период сброса в хранилище
+	fs.IntVar(&cfg.ClickBufferSize, "click-buffer", cfg.ClickBufferSize, "capacity of the click events buffer")
+	fs.IntVar(&cfg.StreamChunkSize, "stream-chunk", cfg.StreamChunkSize, "lines of a streaming import saved in one write")
+	fs.Var(&cfg.ClickFlushInterval, "click-flush-interval", "interval between click events flushes")
+
+	// срок хранения ответов на запросы с ключом идемпотентности, 0 отключает
+	fs.Var(&cfg.IdempotencyTTL, "idempotency-ttl", "how long responses to requests with an Idempotency-Key are kept, 0 disables")
+
+	// таймауты операций с базой данных, 0 отключает ограничение
+	fs.Var(&cfg.DBReadTimeout, "db-read-timeout", "timeout for database reads, 0 disables")
+	fs.Var(&cfg.DBWriteTimeout, "db-write-timeout", "timeout for database writes, 0 disables")
+
+	// генерация коротких ключей: стратегия, длина и алфавит случайных ключей, размер пула (0 отключает)
+	fs.StringVar(&cfg.KeyStrategy, "key-strategy", cfg.KeyStrategy, "short key generation strategy: random or sequence")
+	fs.IntVar(&cfg.KeyLength, "key-length", cfg.KeyLength, "length of random short keys")
+	fs.StringVar(&cfg.KeyAlphabet, "key-alphabet", cfg.KeyAlphabet, "characters used in short keys")
+	fs.IntVar(&cfg.KeyPoolSize, "key-pool", cfg.KeyPoolSize, "number of pre-generated short keys, 0 disables pool")
+
+	// кеш ссылок для редиректов: ёмкость (0 отключает), время жизни найденных и ненайденных ссылок
+	fs.IntVar(&cfg.CacheSize, "cache-size", cfg.CacheSize, "maximal number of cached links, 0 disables cache")
+	fs.Var(&cfg.CacheTTL, "cache-ttl", "how long found links are cached")
+	fs.Var(&cfg.CacheNegativeTTL, "cache-negative-ttl", "how long unknown, deleted and expired links are cached")
+
+	// журнал файлового хранилища: политика fsync, её период и размер журнала до сворачивания в снимок
+	fs.StringVar(&cfg.FileSyncPolicy, "file-sync", cfg.FileSyncPolicy, "file storage journal fsync policy: always, interval or never")
+	fs.Var(&cfg.FileSyncInterval, "file-sync-interval", "interval between journal fsyncs for the interval policy")
+	fs.IntVar(&cfg.FileCompactThreshold, "file-compact-threshold", cfg.FileCompactThreshold, "number of journal entries before compaction into a snapshot")
+
+	// работа при сбое базы данных: журнал записей до её восстановления (пустой путь отключает) и период проверки
+	fs.StringVar(&cfg.FailoverSpoolPath, "failover-spool", cfg.FailoverSpoolPath, "path to the spool of writes made while the database is down, empty disables failover; "+
+		"spooled links that conflict with the database on replay are dropped from service and kept in <path>.rejected")
+	fs.Var(&cfg.FailoverProbeInterval, "failover-probe-interval", "interval between database health probes during failover")
+
+	// остановка: сколько отдавать отказ готовности, прежде чем перестать принимать запросы
+	fs.Var(&cfg.ShutdownDrainDelay, "shutdown-drain-delay", "how long readiness reports not ready before the server stops accepting requests")
+
+	// ограничение частоты запросов: хранилище корзин и лимиты вида 100/1m, off отключает лимит
+	fs.StringVar(&cfg.RateLimitStore, "rate-limit-store", cfg.RateLimitStore, "rate limiter state store: memory or postgres")
+	fs.Var(&cfg.RateLimitCreate, "rate-limit-create", "rate limit for link creation per user and per IP, e.g. 100/1m, off by default")
+	fs.Var(&cfg.RateLimitBatch, "rate-limit-batch", "rate limit for batch link creation per user and per IP, e.g. 20/1m, off by default")
+	fs.Var(&cfg.RateLimitRedirect, "rate-limit-redirect", "rate limit for redirects per user and per IP, e.g. 1000/1m, off by default")
+
+	return fs
+}
+
+// loadConfigFile накладывает значения из JSON файла поверх текущей конфигурации
+// Отсутствующие в файле поля сохраняют прежние значения
+func loadConfigFile(cfg *ConfigStruct, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv переопределяет конфигурацию переменными окружения
+func applyEnv(cfg *ConfigStruct) []error {
+	var errs []error
+
+	envString(&cfg.ServerAddress, "SERVER_ADDRESS")
+	envString(&cfg.GRPCAddress, "GRPC_ADDRESS")
+	envString(&cfg.ShortAddress, "BASE_URL")
+	envString(&cfg.FilePath, "FILE_STORAGE_PATH")
+	envString(&cfg.AddressDB, "DATABASE_DSN")
+	envString(&cfg.AuthSecretKey, "AUTH_SECRET_KEY")
+	envString(&cfg.TrustedSubnet, "TRUSTED_SUBNET")
+	envString(&cfg.TLSCertFile, "TLS_CERT_FILE")
+	envString(&cfg.TLSKeyFile, "TLS_KEY_FILE")
+
+	envString(&cfg.AliasCharset, "ALIAS_CHARSET")
+	envString(&cfg.KeyStrategy, "KEY_STRATEGY")
+	envString(&cfg.KeyAlphabet, "KEY_ALPHABET")
+	envString(&cfg.FileSyncPolicy, "FILE_SYNC_POLICY")
+	envString(&cfg.FailoverSpoolPath, "FAILOVER_SPOOL_PATH")
+	envString(&cfg.RateLimitStore, "RATE_LIMIT_STORE")
+	envString(&cfg.URLBlocklistPath, "URL_BLOCKLIST_PATH")
+	if value := os.Getenv("RESERVED_ALIASES"); value != "" {
+		cfg.ReservedAliases = splitList(value)
+	}
+	if value := os.Getenv("URL_ALLOWED_SCHEMES"); value != "" {
+		cfg.URLAllowedSchemes = splitList(value)
+	}
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		cfg.TrustedProxies = splitList(value)
+	}
+
+	if err := envBool(&cfg.EnableHTTPS, "ENABLE_HTTPS"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envBool(&cfg.URLResolveHosts, "URL_RESOLVE_HOSTS"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envBool(&cfg.URLSortQuery, "URL_SORT_QUERY"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.RedirectCode, "REDIRECT_CODE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.RedirectMaxAge, "REDIRECT_MAX_AGE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.ExpiredSweepInterval, "EXPIRED_SWEEP_INTERVAL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.ExpiredRetention, "EXPIRED_RETENTION"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.ClickBufferSize, "CLICK_BUFFER_SIZE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.StreamChunkSize, "STREAM_CHUNK_SIZE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.ClickFlushInterval, "CLICK_FLUSH_INTERVAL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.IdempotencyTTL, "IDEMPOTENCY_TTL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.DBReadTimeout, "DB_READ_TIMEOUT"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.DBWriteTimeout, "DB_WRITE_TIMEOUT"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.KeyLength, "KEY_LENGTH"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.KeyPoolSize, "KEY_POOL_SIZE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.CacheSize, "CACHE_SIZE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.CacheTTL, "CACHE_TTL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.CacheNegativeTTL, "CACHE_NEGATIVE_TTL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.FileSyncInterval, "FILE_SYNC_INTERVAL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.FileCompactThreshold, "FILE_COMPACT_THRESHOLD"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.FailoverProbeInterval, "FAILOVER_PROBE_INTERVAL"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envDuration(&cfg.ShutdownDrainDelay, "SHUTDOWN_DRAIN_DELAY"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envRateLimit(&cfg.RateLimitCreate, "RATE_LIMIT_CREATE"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envRateLimit(&cfg.RateLimitBatch, "RATE_LIMIT_BATCH"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envRateLimit(&cfg.RateLimitRedirect, "RATE_LIMIT_REDIRECT"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.AliasMinLength, "ALIAS_MIN_LENGTH"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := envInt(&cfg.AliasMaxLength, "ALIAS_MAX_LENGTH"); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// envString записывает значение переменной окружения, если она задана
+func envString(target *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*target = value
+	}
+}
+
+// envBool записывает булево значение переменной окружения, если она задана
+func envBool(target *bool, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s value: %s, expected boolean", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+// envInt записывает целое значение переменной окружения, если она задана
+func envInt(target *int, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s value: %s, expected integer", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+// envDuration записывает длительность из переменной окружения, если она задана
+func envDuration(target *Duration, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	if err := target.Set(value); err != nil {
+		return fmt.Errorf("invalid %s value: %s, expected duration", name, value)
+	}
+	return nil
+}
+
+// envRateLimit записывает лимит частоты запросов из переменной окружения, если она задана
+func envRateLimit(target *RateLimit, name string) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	if err := target.Set(value); err != nil {
+		return fmt.Errorf("invalid %s value: %s, expected requests/period", name, value)
+	}
+	return nil
+}
 
-	// В тестах os.Args может быть пустым или содержать аргументы теста
-	if len(os.Args) > 1 {
-		fs.Parse(os.Args[1:])
+// splitList разбирает список значений, разделённых запятыми
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
+
+// validate проверяет значения конфигурации и вычисляет производные поля
+func validate(cfg *ConfigStruct) []error {
+	var errs []error
 
 	// Проверка и обработка адреса сервера
-	portParts := strings.Split(*portFlag, ":")
-	if len(portParts) < 2 {
-		log.Printf("invalid address format: %s, expected format: host:port\n", *portFlag)
-		flag.Usage()
-		os.Exit(2)
+	colonIndex := strings.LastIndex(cfg.ServerAddress, ":")
+	if colonIndex == -1 {
+		errs = append(errs, fmt.Errorf("invalid address format: %s, expected format: host:port", cfg.ServerAddress))
+	} else {
+		cfg.Port = cfg.ServerAddress[colonIndex:]
 	}
 
-	port := ":" + portParts[1]
+	// Адрес gRPC-сервера проверяется, только если он задан
+	if cfg.GRPCAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.GRPCAddress); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gRPC address: %s, expected format: host:port", cfg.GRPCAddress))
+		}
+	}
 
 	// Проверка базового адреса
-	resAddress := *resAddressFlag
-	if !strings.HasPrefix(resAddress, "http://") && !strings.HasPrefix(resAddress, "https://") {
-		log.Printf("invalid base address: %s, must start with http:// or https://\n", resAddress)
-		flag.Usage()
-		os.Exit(2)
-	}
-
-	// Путь до файла с urls
-	filePath := *filePathFlag
-
-	// Адрес для базы данных
-	addressDB := *addressFlagDB
-
-	// Приоритет параметров согласно заданию:
-	// 1. Переменная окружения (наивысший приоритет)
-	// 2. Флаг командной строки
-	// 3. Значение по умолчанию (уже установлено)
-
-	// Если параметры заданы через переменные окружения, используем их
-	if envServerAddr := os.Getenv("SERVER_ADDRESS"); envServerAddr != "" {
-		// Обрабатываем переменную окружения SERVER_ADDRESS
-		envPortParts := strings.Split(envServerAddr, ":")
-		if len(envPortParts) >= 2 {
-			port = ":" + envPortParts[1]
-		} else {
-			port = envServerAddr
+	if !strings.HasPrefix(cfg.ShortAddress, "http://") && !strings.HasPrefix(cfg.ShortAddress, "https://") {
+		errs = append(errs, fmt.Errorf("invalid base address: %s, must start with http:// or https://", cfg.ShortAddress))
+	}
+
+	// Проверка доверенной подсети
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trusted subnet: %s", cfg.TrustedSubnet))
 		}
 	}
 
-	if envBaseURL := os.Getenv("BASE_URL"); envBaseURL != "" {
-		resAddress = envBaseURL
+	// Доверенные прокси задаются адресами или подсетями
+	for _, proxy := range cfg.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		if cidrErr != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("invalid trusted proxy: %s", proxy))
+		}
+	}
+
+	// Сертификат и ключ задаются только парой
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls certificate and key must be set together"))
+	}
+
+	if cfg.AuthSecretKey == "" {
+		errs = append(errs, errors.New("auth secret key must not be empty"))
+	}
+
+	// Ограничения алиасов
+	if cfg.AliasCharset == "" {
+		errs = append(errs, errors.New("alias charset must not be empty"))
+	}
+	if cfg.AliasMinLength < 1 || cfg.AliasMaxLength < cfg.AliasMinLength {
+		errs = append(errs, fmt.Errorf("invalid alias length bounds: min %d, max %d", cfg.AliasMinLength, cfg.AliasMaxLength))
+	}
+
+	// Допустимые схемы ссылок
+	if len(cfg.URLAllowedSchemes) == 0 {
+		errs = append(errs, errors.New("allowed URL schemes must not be empty"))
+	}
+
+	// Параметры перенаправления
+	if !model.ValidRedirectCode(cfg.RedirectCode) {
+		errs = append(errs, fmt.Errorf("redirect code must be 301, 302, 307 or 308, got %d", cfg.RedirectCode))
+	}
+	if cfg.RedirectMaxAge <= 0 {
+		errs = append(errs, errors.New("redirect max age must be positive"))
+	}
+
+	// Параметры очистки просроченных ссылок
+	if cfg.ExpiredSweepInterval < 0 || cfg.ExpiredRetention < 0 {
+		errs = append(errs, errors.New("expired sweep interval and retention must not be negative"))
+	}
+
+	// Параметры буфера событий переходов
+	if cfg.ClickBufferSize < 1 || cfg.ClickFlushInterval <= 0 {
+		errs = append(errs, errors.New("click buffer size and flush interval must be positive"))
+	}
+
+	// Размер части потокового импорта
+	if cfg.StreamChunkSize < 1 {
+		errs = append(errs, errors.New("stream chunk size must be positive"))
+	}
+
+	// Срок хранения ключей идемпотентности
+	if cfg.IdempotencyTTL < 0 {
+		errs = append(errs, errors.New("idempotency ttl must not be negative"))
+	}
+
+	// Таймауты операций с базой данных
+	if cfg.DBReadTimeout < 0 || cfg.DBWriteTimeout < 0 {
+		errs = append(errs, errors.New("database timeouts must not be negative"))
+	}
+
+	// Параметры генерации коротких ключей
+	if cfg.KeyStrategy != KeyStrategyRandom && cfg.KeyStrategy != KeyStrategySequence {
+		errs = append(errs, fmt.Errorf("invalid key strategy: %s, expected %s or %s", cfg.KeyStrategy, KeyStrategyRandom, KeyStrategySequence))
+	}
+	if cfg.KeyLength < 1 {
+		errs = append(errs, fmt.Errorf("invalid key length: %d, must be positive", cfg.KeyLength))
+	}
+	if err := keygen.ValidateAlphabet(cfg.KeyAlphabet); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.KeyPoolSize < 0 {
+		errs = append(errs, errors.New("key pool size must not be negative"))
 	}
 
-	if envFilePath := os.Getenv("FILE_STORAGE_PATH"); envFilePath != "" {
-		filePath = envFilePath
+	// Параметры кеша ссылок
+	if cfg.CacheSize < 0 || cfg.CacheTTL < 0 || cfg.CacheNegativeTTL < 0 {
+		errs = append(errs, errors.New("cache size and TTLs must not be negative"))
 	}
 
-	if envAddressDB := os.Getenv("DATABASE_DSN"); envAddressDB != "" {
-		addressDB = envAddressDB
+	// Параметры журнала файлового хранилища
+	if err := persistence.ValidateSyncPolicy(cfg.FileSyncPolicy); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.FileSyncInterval <= 0 || cfg.FileCompactThreshold < 1 {
+		errs = append(errs, errors.New("file sync interval and compact threshold must be positive"))
+	}
+
+	// Параметры работы при сбое базы данных
+	if cfg.FailoverProbeInterval <= 0 {
+		errs = append(errs, errors.New("failover probe interval must be positive"))
+	}
+
+	// Параметры остановки сервера
+	if cfg.ShutdownDrainDelay < 0 {
+		errs = append(errs, errors.New("shutdown drain delay must not be negative"))
+	}
+
+	// Хранилище ограничения частоты запросов, общее хранилище требует базу данных
+	switch cfg.RateLimitStore {
+	case RateLimitStoreMemory:
+	case RateLimitStorePostgres:
+		if cfg.AddressDB == "" {
+			errs = append(errs, errors.New("postgres rate limit store requires database dsn"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid rate limit store: %s, expected %s or %s", cfg.RateLimitStore, RateLimitStoreMemory, RateLimitStorePostgres))
 	}
 
-	return port, resAddress, filePath, addressDB
+	return errs
 }
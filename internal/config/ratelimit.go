@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit лимит запросов, который задаётся строкой вида "100/1m": не больше 100 запросов в минуту
+// Пустая строка, "0" и "off" отключают ограничение
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Enabled сообщает, задано ли ограничение
+func (r RateLimit) Enabled() bool {
+	return r.Requests > 0 && r.Period > 0
+}
+
+// String возвращает строковое представление лимита
+func (r RateLimit) String() string {
+	if !r.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, r.Period)
+}
+
+// Set разбирает лимит из строки, реализует flag.Value
+func (r *RateLimit) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" || value == "off" {
+		*r = RateLimit{}
+		return nil
+	}
+
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return fmt.Errorf("invalid rate limit %q, expected requests/period", value)
+	}
+
+	count, err := strconv.Atoi(requests)
+	if err != nil || count < 1 {
+		return fmt.Errorf("invalid rate limit %q: requests must be a positive integer", value)
+	}
+	duration, err := time.ParseDuration(period)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid rate limit %q: period must be a positive duration", value)
+	}
+
+	*r = RateLimit{Requests: count, Period: duration}
+	return nil
+}
+
+// MarshalJSON сохраняет лимит строкой
+func (r RateLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON читает лимит из строки
+func (r *RateLimit) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return r.Set(value)
+}
@@ -1,11 +1,17 @@
 package entity
 
+import "time"
+
 // URL представляет доменную модель URL
 type URL struct {
 	ID          int
 	ShortURL    string
 	OriginalURL string
 	UserID      string
+	CreatedAt   time.Time
+	DeletedAt   *time.Time // nil, если ссылка не удалена
+	ExpiresAt   *time.Time // nil, если срок действия не задан
+	ClickCount  int
 }
 
 // NewURL создаёт новую доменную сущность URL
@@ -92,3 +92,14 @@ func TestURL_AllMethods(t *testing.T) {
 		assert.Equal(t, userID, url.GetUserID())
 	})
 }
+
+func TestURL_OptionalFields(t *testing.T) {
+	t.Run("New URL has no optional fields", func(t *testing.T) {
+		url := NewURL(1, "abc123", "https://example.com", "user-123")
+
+		assert.True(t, url.CreatedAt.IsZero())
+		assert.Nil(t, url.DeletedAt)
+		assert.Nil(t, url.ExpiresAt)
+		assert.Equal(t, 0, url.ClickCount)
+	})
+}
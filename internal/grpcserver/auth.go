@@ -0,0 +1,44 @@
+package grpcserver
+
+import (
+	"context"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UserIDMetadataKey ключ метаданных с подписанным токеном пользователя, аналог куки user_id
+const UserIDMetadataKey = "user_id"
+
+// userIDKey ключ контекста с ID пользователя
+type userIDKey struct{}
+
+// AuthInterceptor определяет пользователя по токену из метаданных
+// Если токен не передан или неверен, создаётся новый пользователь и токен отправляется в заголовке ответа
+func AuthInterceptor(authService *auth.AuthService) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		userID, valid := "", false
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(UserIDMetadataKey); len(values) > 0 {
+				userID, valid = authService.ValidateCookie(values[0])
+			}
+		}
+
+		if !valid {
+			userID = authService.GenerateUserID()
+			header := metadata.Pairs(UserIDMetadataKey, authService.SignedToken(userID))
+			if err := grpc.SetHeader(ctx, header); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(context.WithValue(ctx, userIDKey{}, userID), req)
+	}
+}
+
+// userIDFromContext возвращает ID пользователя, установленный AuthInterceptor
+func userIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
@@ -0,0 +1,149 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	pb "github.com/Ilya-c4talyst/go-advanced-shortner/api/shortener"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/middleware"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Ключи метаданных идемпотентных вызовов, аналоги заголовков HTTP API
+const (
+	IdempotencyKeyMetadataKey     = "idempotency-key"
+	IdempotentReplayedMetadataKey = "idempotent-replayed"
+)
+
+// protobufContentType тип сохранённого ответа gRPC вызова
+const protobufContentType = "application/protobuf"
+
+// idempotencyStoreTimeout ограничение времени сохранения ответа после обработки вызова
+const idempotencyStoreTimeout = 5 * time.Second
+
+// idempotentMethods методы, повторы которых получают сохранённый ответ, и конструкторы их ответов
+var idempotentMethods = map[string]func() proto.Message{
+	pb.Shortener_ShortenURL_FullMethodName:   func() proto.Message { return &pb.ShortenURLResponse{} },
+	pb.Shortener_ShortenBatch_FullMethodName: func() proto.Message { return &pb.ShortenBatchResponse{} },
+}
+
+// IdempotencyInterceptor повторяет сохранённый ответ на вызов с теми же метаданными idempotency-key
+// Ключ действует для одного пользователя в течение ttl и общий с HTTP API. Тот же ключ с другим запросом
+// отклоняется с InvalidArgument, а пока первый вызов обрабатывается, повтор получает Aborted
+// Вызов без токена получает нового пользователя, поэтому повторять его нужно с токеном из заголовка первого ответа
+// Сохраняется только успешный ответ: ошибки не создают ссылок, и повтор выполняет вызов заново
+// Должен стоять после AuthInterceptor. При ошибке хранилища вызов выполняется без идемпотентности
+func IdempotencyInterceptor(store middleware.IdempotencyStore, ttl time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		newResponse, ok := idempotentMethods[info.FullMethod]
+		if !ok || ttl <= 0 {
+			return handler(ctx, req)
+		}
+
+		key := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(IdempotencyKeyMetadataKey); len(values) > 0 {
+				key = values[0]
+			}
+		}
+		if key == "" {
+			return handler(ctx, req)
+		}
+		if !middleware.ValidIdempotencyKey(key) {
+			return nil, status.Error(codes.InvalidArgument, "invalid idempotency key")
+		}
+
+		hash, err := callHash(info.FullMethod, req)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		record := model.IdempotencyRecord{
+			Key:         key,
+			UserID:      userIDFromContext(ctx),
+			RequestHash: hash,
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+
+		existing, err := store.ReserveIdempotencyKey(ctx, record)
+		switch {
+		case errors.Is(err, repository.ErrIdempotencyKeyExists):
+			return replayCall(ctx, record, existing, newResponse())
+		case err != nil:
+			log.Printf("Ошибка резервирования ключа идемпотентности: %v", err)
+			return handler(ctx, req)
+		}
+
+		resp, callErr := handler(ctx, req)
+
+		// Ответ сохраняем и при отменённом вызове: обработчик мог уже создать ссылки
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyStoreTimeout)
+		defer cancel()
+
+		var body []byte
+		if callErr == nil {
+			body, err = proto.Marshal(resp.(proto.Message))
+		}
+		if callErr != nil || err != nil {
+			if err := store.ReleaseIdempotencyKey(storeCtx, record.UserID, record.Key); err != nil {
+				log.Printf("Ошибка освобождения ключа идемпотентности: %v", err)
+			}
+			return resp, callErr
+		}
+
+		record.StatusCode = http.StatusOK
+		record.ContentType = protobufContentType
+		record.Body = body
+		if err := store.CompleteIdempotencyKey(storeCtx, record); err != nil {
+			log.Printf("Ошибка сохранения ответа для ключа идемпотентности: %v", err)
+		}
+		return resp, nil
+	}
+}
+
+// replayCall отвечает на повтор вызова с уже использованным ключом
+func replayCall(ctx context.Context, record, existing model.IdempotencyRecord, resp proto.Message) (any, error) {
+	switch {
+	case existing.RequestHash != record.RequestHash:
+		return nil, status.Error(codes.InvalidArgument, "idempotency key was used with a different request")
+	case !existing.Completed():
+		return nil, status.Error(codes.Aborted, "request with this idempotency key is in progress")
+	}
+
+	if err := proto.Unmarshal(existing.Body, resp); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayedMetadataKey, "true")); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// callHash считает хеш метода и тела вызова
+func callHash(method string, req any) (string, error) {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return "", errors.New("request is not a protobuf message")
+	}
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,65 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	pb "github.com/Ilya-c4talyst/go-advanced-shortner/api/shortener"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterMetadataKey ключ метаданных ответа с числом секунд до следующей попытки, аналог Retry-After
+const RetryAfterMetadataKey = "retry-after"
+
+// rateLimitedMethods области ограничения частоты методов, как у соответствующих HTTP маршрутов
+var rateLimitedMethods = map[string]string{
+	pb.Shortener_ShortenURL_FullMethodName:   ratelimit.ScopeCreate,
+	pb.Shortener_ShortenBatch_FullMethodName: ratelimit.ScopeBatch,
+	pb.Shortener_Expand_FullMethodName:       ratelimit.ScopeRedirect,
+}
+
+// RateLimitInterceptor ограничивает частоту вызовов отдельно для пользователя и для IP-адреса
+// Должен стоять после AuthInterceptor, иначе учитывается только IP-адрес
+// При ошибке хранилища корзин вызов пропускается, чтобы сбой лимитов не останавливал сервис
+func RateLimitInterceptor(limiter *ratelimit.Limiter, trustedProxies []*net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		scope, ok := rateLimitedMethods[info.FullMethod]
+		if !ok || !limiter.Limited(scope) {
+			return handler(ctx, req)
+		}
+
+		keys := []string{"ip:" + clientIP(ctx, trustedProxies)}
+		if userID := userIDFromContext(ctx); userID != "" {
+			keys = append(keys, "user:"+userID)
+		}
+
+		res, err := limiter.Allow(ctx, scope, keys...)
+		if err != nil {
+			log.Printf("Ошибка ограничения частоты запросов %s: %v", scope, err)
+			return handler(ctx, req)
+		}
+
+		if !res.Allowed {
+			header := metadata.Pairs(RetryAfterMetadataKey, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			if err := grpc.SetHeader(ctx, header); err != nil {
+				return nil, err
+			}
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// ceilSeconds округляет длительность вверх до целых секунд
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,331 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	pb "github.com/Ilya-c4talyst/go-advanced-shortner/api/shortener"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/auth"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/ratelimit"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
+	"github.com/go-playground/validator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RealIPMetadataKey ключ метаданных с IP клиента для проверки доверенной подсети, аналог X-Real-IP
+const RealIPMetadataKey = "x-real-ip"
+
+// ShortenerServer реализация gRPC API поверх сервиса сокращения ссылок
+type ShortenerServer struct {
+	pb.UnimplementedShortenerServer
+
+	service       *service.URLShortnerService
+	configuration *config.ConfigStruct
+	trustedSubnet *net.IPNet
+	// trustedProxies подсети прокси, которым доверяется IP клиента из метаданных
+	trustedProxies []*net.IPNet
+	validate       *validator.Validate
+}
+
+// NewShortenerServer создаёт реализацию gRPC API
+func NewShortenerServer(service *service.URLShortnerService, configuration *config.ConfigStruct) *ShortenerServer {
+	server := &ShortenerServer{
+		service:        service,
+		configuration:  configuration,
+		trustedProxies: parseTrustedProxies(configuration.TrustedProxies),
+		validate:       validator.New(),
+	}
+
+	// Пустая или некорректная подсеть запрещает доступ к статистике всем
+	if configuration.TrustedSubnet != "" {
+		if _, subnet, err := net.ParseCIDR(configuration.TrustedSubnet); err == nil {
+			server.trustedSubnet = subnet
+		} else {
+			log.Printf("invalid trusted subnet %s: %v", configuration.TrustedSubnet, err)
+		}
+	}
+
+	return server
+}
+
+// New создаёт gRPC сервер с зарегистрированным API, аутентификацией по метаданным,
+// ограничением частоты и идемпотентностью вызовов по тем же настройкам, что и у HTTP API
+func New(service *service.URLShortnerService, configuration *config.ConfigStruct, opts ...grpc.ServerOption) *grpc.Server {
+	shortener := NewShortenerServer(service, configuration)
+	authService := auth.NewAuthService(configuration.AuthSecretKey, configuration.EnableHTTPS)
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		AuthInterceptor(authService),
+		RateLimitInterceptor(ratelimit.NewLimiterFromConfig(configuration), shortener.trustedProxies),
+		IdempotencyInterceptor(service.Repository, time.Duration(configuration.IdempotencyTTL)),
+	))
+
+	server := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(server, shortener)
+	return server
+}
+
+// ShortenURL сокращает один URL
+func (s *ShortenerServer) ShortenURL(ctx context.Context, req *pb.ShortenURLRequest) (*pb.ShortenURLResponse, error) {
+	request := model.Request{
+		URL:       req.GetUrl(),
+		Alias:     req.GetAlias(),
+		ExpiresIn: req.GetExpiresIn(),
+		ExpiresAt: timeFromProto(req.GetExpiresAt()),
+	}
+	if err := s.validate.Struct(request); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	shortURL, err := s.service.CreateShortURLWithOptions(ctx, request.URL, userIDFromContext(ctx), request.Options())
+	if errors.Is(err, repository.ErrRowExists) {
+		return &pb.ShortenURLResponse{Result: s.fullURL(shortURL), AlreadyExists: true}, nil
+	}
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &pb.ShortenURLResponse{Result: s.fullURL(shortURL)}, nil
+}
+
+// ShortenBatch сокращает пакет URL
+func (s *ShortenerServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	if len(req.GetItems()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty batch not allowed")
+	}
+
+	items := make([]model.ShortenItem, len(req.GetItems()))
+	for i, item := range req.GetItems() {
+		request := model.BatchRequest{
+			CorrelationID: item.GetCorrelationId(),
+			OriginalURL:   item.GetOriginalUrl(),
+			Alias:         item.GetAlias(),
+			ExpiresIn:     item.GetExpiresIn(),
+			ExpiresAt:     timeFromProto(item.GetExpiresAt()),
+		}
+		if err := s.validate.Struct(request); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		items[i] = model.ShortenItem{OriginalURL: request.OriginalURL, Options: request.Options()}
+	}
+
+	// Пакет сохраняется целиком, уже сокращённые ссылки возвращаются с существующим ключом
+	results, err := s.service.CreateShortURLsBatch(ctx, items, userIDFromContext(ctx), model.BatchModeAtomic)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	response := &pb.ShortenBatchResponse{}
+	for i, result := range results {
+		response.Items = append(response.Items, &pb.BatchResult{
+			CorrelationId: req.GetItems()[i].GetCorrelationId(),
+			ShortUrl:      s.fullURL(result.ShortURL),
+		})
+	}
+	return response, nil
+}
+
+// Expand возвращает оригинальный URL и регистрирует переход
+func (s *ShortenerServer) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	fullURL, err := s.service.GetFullURL(ctx, req.GetId())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	userAgent := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			userAgent = values[0]
+		}
+	}
+	s.service.RecordClick(req.GetId(), "", userAgent, clientIP(ctx, s.trustedProxies))
+
+	return &pb.ExpandResponse{OriginalUrl: fullURL}, nil
+}
+
+// ListUserURLs возвращает страницу URL пользователя
+func (s *ShortenerServer) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = repository.DefaultUserURLsLimit
+	}
+	if limit < 0 || limit > repository.MaxUserURLsLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", repository.MaxUserURLsLimit)
+	}
+
+	page, err := s.service.GetUserURLs(ctx, userIDFromContext(ctx), repository.UserURLsQuery{
+		Limit:     limit,
+		Cursor:    req.GetCursor(),
+		Ascending: req.GetAscending(),
+		Contains:  req.GetContains(),
+		Domain:    req.GetDomain(),
+	})
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	response := &pb.ListUserURLsResponse{NextCursor: page.NextCursor}
+	for _, url := range page.URLs {
+		userURL := &pb.UserURL{
+			ShortUrl:    s.fullURL(url.ShortURL),
+			OriginalUrl: url.OriginalURL,
+			ClickCount:  int64(url.ClickCount),
+		}
+		if !url.CreatedAt.IsZero() {
+			userURL.CreatedAt = timestamppb.New(url.CreatedAt)
+		}
+		if url.ExpiresAt != nil {
+			userURL.ExpiresAt = timestamppb.New(*url.ExpiresAt)
+		}
+		response.Urls = append(response.Urls, userURL)
+	}
+	return response, nil
+}
+
+// DeleteUserURLs ставит URL пользователя в очередь на удаление
+func (s *ShortenerServer) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	if err := s.service.DeleteUserURLs(userIDFromContext(ctx), req.GetIds()); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+// Stats возвращает статистику сервиса клиентам из доверенной подсети
+func (s *ShortenerServer) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.StatsResponse, error) {
+	if !s.trusted(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	}
+
+	stats, err := s.service.GetStats(ctx)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &pb.StatsResponse{Urls: int64(stats.URLs), Users: int64(stats.Users)}, nil
+}
+
+// trusted проверяет, что IP из метаданных входит в доверенную подсеть
+func (s *ShortenerServer) trusted(ctx context.Context) bool {
+	if s.trustedSubnet == nil {
+		return false
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	values := md.Get(RealIPMetadataKey)
+	if len(values) == 0 {
+		return false
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(values[0]))
+	return ip != nil && s.trustedSubnet.Contains(ip)
+}
+
+// fullURL добавляет к идентификатору базовый адрес сервиса
+func (s *ShortenerServer) fullURL(shortURL string) string {
+	return s.configuration.ShortAddress + "/" + shortURL
+}
+
+// statusFromError преобразует ошибки сервиса в коды gRPC
+func statusFromError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidAlias), errors.Is(err, service.ErrInvalidExpiry),
+		errors.Is(err, service.ErrInvalidRedirectCode), errors.Is(err, service.ErrInvalidQueryMerge),
+		errors.Is(err, service.ErrURLRejected),
+		errors.Is(err, repository.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrAliasTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrBatchConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, repository.ErrURLDeleted):
+		return status.Error(codes.NotFound, "URL deleted")
+	case errors.Is(err, repository.ErrURLExpired):
+		return status.Error(codes.NotFound, "URL expired")
+	case errors.Is(err, repository.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, service.ErrNotFound):
+		return status.Error(codes.NotFound, "URL not found")
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// clientIP возвращает IP клиента из адреса соединения
+// Метаданным x-real-ip верим, только если соединение пришло от доверенного прокси
+func clientIP(ctx context.Context, trustedProxies []*net.IPNet) string {
+	remoteIP := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(remoteIP); err == nil {
+			remoteIP = host
+		}
+	}
+
+	if ip := net.ParseIP(remoteIP); ip != nil && containsIP(trustedProxies, ip) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(RealIPMetadataKey); len(values) > 0 {
+				return strings.TrimSpace(values[0])
+			}
+		}
+	}
+	return remoteIP
+}
+
+// parseTrustedProxies разбирает адреса и подсети доверенных прокси, адрес считается подсетью из одного узла
+func parseTrustedProxies(values []string) []*net.IPNet {
+	var subnets []*net.IPNet
+	for _, value := range values {
+		if _, subnet, err := net.ParseCIDR(value); err == nil {
+			subnets = append(subnets, subnet)
+			continue
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			log.Printf("invalid trusted proxy %s", value)
+			continue
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return subnets
+}
+
+// containsIP проверяет, входит ли адрес в одну из подсетей
+func containsIP(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeFromProto преобразует необязательную метку времени
+func timeFromProto(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
@@ -0,0 +1,283 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/Ilya-c4talyst/go-advanced-shortner/api/shortener"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// setupTest запускает gRPC сервер в памяти и возвращает клиента
+func setupTest(t *testing.T) (pb.ShortenerClient, *service.URLShortnerService) {
+	return setupTestWithConfig(t, &config.ConfigStruct{
+		ShortAddress:  "http://localhost:8080",
+		AuthSecretKey: "test-secret",
+		TrustedSubnet: "10.0.0.0/8",
+	})
+}
+
+// setupTestWithConfig запускает gRPC сервер в памяти с указанной конфигурацией
+func setupTestWithConfig(t *testing.T, configuration *config.ConfigStruct) (pb.ShortenerClient, *service.URLShortnerService) {
+	svc := service.NewURLShortnerService(repository.NewMemoryRepository(), configuration)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := New(svc, configuration)
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		svc.Close()
+	})
+
+	return pb.NewShortenerClient(conn), svc
+}
+
+// withToken добавляет токен пользователя в исходящие метаданные
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), UserIDMetadataKey, token)
+}
+
+func TestShortenerServer(t *testing.T) {
+	client, svc := setupTest(t)
+
+	// Первый вызов без токена создаёт пользователя
+	var header metadata.MD
+	created, err := client.ShortenURL(context.Background(), &pb.ShortenURLRequest{Url: "https://grpc.example.com"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Contains(t, created.GetResult(), "http://localhost:8080/")
+	assert.False(t, created.GetAlreadyExists())
+
+	tokens := header.Get(UserIDMetadataKey)
+	require.Len(t, tokens, 1)
+	ctx := withToken(tokens[0])
+	shortID := created.GetResult()[len("http://localhost:8080/"):]
+
+	t.Run("token is reused", func(t *testing.T) {
+		var header metadata.MD
+		_, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{}, grpc.Header(&header))
+		assert.NoError(t, err)
+		assert.Empty(t, header.Get(UserIDMetadataKey))
+	})
+
+	t.Run("alias taken", func(t *testing.T) {
+		_, err := client.ShortenURL(ctx, &pb.ShortenURLRequest{Url: "https://alias.example.com", Alias: shortID})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+
+	t.Run("invalid URL", func(t *testing.T) {
+		_, err := client.ShortenURL(ctx, &pb.ShortenURLRequest{Url: "not a url"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("rejected URL", func(t *testing.T) {
+		_, err := client.ShortenURL(ctx, &pb.ShortenURLRequest{Url: "http://127.0.0.1/admin"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("expand", func(t *testing.T) {
+		resp, err := client.Expand(ctx, &pb.ExpandRequest{Id: shortID})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://grpc.example.com", resp.GetOriginalUrl())
+
+		_, err = client.Expand(ctx, &pb.ExpandRequest{Id: "missing"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		resp, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+			{CorrelationId: "1", OriginalUrl: "https://batch1.example.com"},
+			{CorrelationId: "2", OriginalUrl: "https://batch2.example.com"},
+		}})
+		assert.NoError(t, err)
+		assert.Len(t, resp.GetItems(), 2)
+
+		// Повторы и уже сокращённые ссылки сохраняют свои correlation_id
+		resp, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+			{CorrelationId: "a", OriginalUrl: "https://batch1.example.com"},
+			{CorrelationId: "b", OriginalUrl: "https://batch2.example.com"},
+			{CorrelationId: "c", OriginalUrl: "https://batch2.example.com"},
+		}})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.GetItems(), 3) {
+			assert.Equal(t, "a", resp.GetItems()[0].GetCorrelationId())
+			assert.Equal(t, "c", resp.GetItems()[2].GetCorrelationId())
+			assert.Equal(t, resp.GetItems()[1].GetShortUrl(), resp.GetItems()[2].GetShortUrl())
+		}
+
+		_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("list user URLs", func(t *testing.T) {
+		resp, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Limit: 2, Ascending: true})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.GetUrls(), 2) {
+			assert.Equal(t, created.GetResult(), resp.GetUrls()[0].GetShortUrl())
+			assert.NotNil(t, resp.GetUrls()[0].GetCreatedAt())
+		}
+		assert.NotEmpty(t, resp.GetNextCursor())
+
+		resp, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Limit: 2, Ascending: true, Cursor: resp.GetNextCursor()})
+		assert.NoError(t, err)
+		assert.Len(t, resp.GetUrls(), 1)
+
+		_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{Limit: -1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("delete user URLs", func(t *testing.T) {
+		_, err := client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{Ids: []string{shortID}})
+		assert.NoError(t, err)
+
+		// Дожидаемся применения отложенного удаления
+		svc.FlushDeletions()
+
+		_, err = client.Expand(ctx, &pb.ExpandRequest{Id: shortID})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, "URL deleted", status.Convert(err).Message())
+	})
+}
+
+func TestShortenerServerStats(t *testing.T) {
+	client, _ := setupTest(t)
+
+	t.Run("trusted subnet", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), RealIPMetadataKey, "10.1.2.3")
+		resp, err := client.Stats(ctx, &pb.StatsRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), resp.GetUrls())
+	})
+
+	t.Run("untrusted address", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), RealIPMetadataKey, "192.168.1.1")
+		_, err := client.Stats(ctx, &pb.StatsRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("missing address", func(t *testing.T) {
+		_, err := client.Stats(context.Background(), &pb.StatsRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	client, _ := setupTestWithConfig(t, &config.ConfigStruct{
+		ShortAddress:    "http://localhost:8080",
+		AuthSecretKey:   "test-secret",
+		RateLimitCreate: config.RateLimit{Requests: 1, Period: time.Minute},
+	})
+
+	t.Run("create is limited", func(t *testing.T) {
+		_, err := client.ShortenURL(context.Background(), &pb.ShortenURLRequest{Url: "https://limited1.example.com"})
+		assert.NoError(t, err)
+
+		var header metadata.MD
+		_, err = client.ShortenURL(context.Background(), &pb.ShortenURLRequest{Url: "https://limited2.example.com"}, grpc.Header(&header))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.NotEmpty(t, header.Get(RetryAfterMetadataKey))
+	})
+
+	t.Run("real IP metadata does not bypass the limit", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), RealIPMetadataKey, "203.0.113.7")
+		_, err := client.ShortenURL(ctx, &pb.ShortenURLRequest{Url: "https://limited3.example.com"})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("methods without limit are not limited", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := client.ListUserURLs(context.Background(), &pb.ListUserURLsRequest{})
+			assert.NoError(t, err)
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	incoming := func(remote string) context.Context {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RealIPMetadataKey, "203.0.113.7"))
+		return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 5000}})
+	}
+
+	t.Run("metadata from trusted proxy", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.7", clientIP(incoming("10.1.2.3"), proxies))
+		assert.Equal(t, "203.0.113.7", clientIP(incoming("192.0.2.1"), proxies))
+	})
+
+	t.Run("metadata from untrusted peer is ignored", func(t *testing.T) {
+		assert.Equal(t, "192.0.2.2", clientIP(incoming("192.0.2.2"), proxies))
+		assert.Equal(t, "10.1.2.3", clientIP(incoming("10.1.2.3"), nil))
+	})
+}
+
+func TestIdempotencyInterceptor(t *testing.T) {
+	client, _ := setupTestWithConfig(t, &config.ConfigStruct{
+		ShortAddress:   "http://localhost:8080",
+		AuthSecretKey:  "test-secret",
+		IdempotencyTTL: config.Duration(time.Hour),
+	})
+
+	var header metadata.MD
+	_, err := client.ListUserURLs(context.Background(), &pb.ListUserURLsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	token := header.Get(UserIDMetadataKey)[0]
+
+	withKey := func(token, key string) context.Context {
+		return metadata.AppendToOutgoingContext(withToken(token), IdempotencyKeyMetadataKey, key)
+	}
+
+	t.Run("retry gets the saved response", func(t *testing.T) {
+		ctx := withKey(token, "create-1")
+		first, err := client.ShortenURL(ctx, &pb.ShortenURLRequest{Url: "https://idempotent.example.com"})
+		require.NoError(t, err)
+		assert.False(t, first.GetAlreadyExists())
+
+		var header metadata.MD
+		retry, err := client.ShortenURL(ctx, &pb.ShortenURLRequest{Url: "https://idempotent.example.com"}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, first.GetResult(), retry.GetResult())
+		assert.False(t, retry.GetAlreadyExists())
+		assert.Equal(t, []string{"true"}, header.Get(IdempotentReplayedMetadataKey))
+	})
+
+	t.Run("same key with different request", func(t *testing.T) {
+		_, err := client.ShortenURL(withKey(token, "create-1"), &pb.ShortenURLRequest{Url: "https://other.example.com"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("failed call is not saved", func(t *testing.T) {
+		ctx := withKey(token, "create-2")
+		_, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, "empty batch not allowed", status.Convert(err).Message())
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := client.ShortenURL(withKey(token, "bad key"), &pb.ShortenURLRequest{Url: "https://bad-key.example.com"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
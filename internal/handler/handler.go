@@ -1,21 +1,39 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/auth"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/middleware"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/ratelimit"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
 )
 
+// nextCursorHeader заголовок с курсором следующей страницы URL пользователя
+const nextCursorHeader = "X-Next-Cursor"
+
+// readinessTimeout ограничение времени проверки готовности хранилища
+const readinessTimeout = 2 * time.Second
+
+// streamMaxLineSize ограничение длины строки потокового импорта
+const streamMaxLineSize = 64 * 1024
+
 // Handler — структура хендлера
 type Handler struct {
 	Service       *service.URLShortnerService
@@ -30,7 +48,7 @@ func NewHandler(
 	configuration *config.ConfigStruct,
 ) {
 	// Создаем сервис аутентификации
-	authService := auth.NewAuthService(configuration.AuthSecretKey)
+	authService := auth.NewAuthService(configuration.AuthSecretKey, configuration.EnableHTTPS)
 
 	handler := &Handler{
 		Service:       service,
@@ -38,25 +56,65 @@ func NewHandler(
 		AuthService:   authService,
 	}
 
+	// Адрес клиента из X-Forwarded-For принимаем только от настроенных прокси, иначе клиент,
+	// подменяя заголовок, получает новую корзину лимитов и завышает число уникальных посетителей
+	if err := ginEngine.SetTrustedProxies(configuration.TrustedProxies); err != nil {
+		log.Printf("Ошибка настройки доверенных прокси, заголовки прокси не учитываются: %v", err)
+		_ = ginEngine.SetTrustedProxies(nil)
+	}
+
+	// Метрики регистрируем до аутентификации, чтобы сбор метрик не выдавал куки
+	ginEngine.Use(middleware.MetricsMiddleware())
+	ginEngine.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Проверки для оркестратора и балансировщика тоже не требуют аутентификации
+	ginEngine.GET("/healthz", handler.Healthz)
+	ginEngine.GET("/readyz", handler.Readyz)
+
 	// Добавляем middleware перед регистрацией маршрутов
 	ginEngine.Use(middleware.GzipMiddleware())
 	ginEngine.Use(middleware.LoggingMiddleware())
 	ginEngine.Use(middleware.AuthMiddleware(authService))
 
+	// Ограничение частоты запросов стоит после аутентификации, чтобы учитывать пользователя
+	limiter := ratelimit.NewLimiterFromConfig(configuration)
+	limit := func(scope string, handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+		if limiter.Limited(scope) {
+			return append([]gin.HandlerFunc{middleware.RateLimitMiddleware(limiter, scope)}, handlers...)
+		}
+		return handlers
+	}
+
+	// Повторы запросов с ключом идемпотентности получают сохранённый ответ, потоковый импорт не повторяется
+	idempotent := func(h gin.HandlerFunc) []gin.HandlerFunc {
+		if ttl := time.Duration(configuration.IdempotencyTTL); ttl > 0 {
+			return []gin.HandlerFunc{middleware.IdempotencyMiddleware(service.Repository, ttl), h}
+		}
+		return []gin.HandlerFunc{h}
+	}
+
 	// Регистрируем маршруты
-	ginEngine.POST("/api/shorten", handler.SendJSONURL)
-	ginEngine.POST("/api/shorten/batch", handler.SendJSONURLBatch)
-	ginEngine.POST("/", handler.SendURL)
-	ginEngine.GET("/:id", handler.GetURL)
+	ginEngine.POST("/api/shorten", limit(ratelimit.ScopeCreate, idempotent(handler.SendJSONURL)...)...)
+	ginEngine.POST("/api/shorten/batch", limit(ratelimit.ScopeBatch, idempotent(handler.SendJSONURLBatch)...)...)
+	ginEngine.POST("/api/shorten/stream", limit(ratelimit.ScopeBatch, handler.SendNDJSONStream)...)
+	ginEngine.POST("/", limit(ratelimit.ScopeCreate, idempotent(handler.SendURL)...)...)
+	ginEngine.GET("/:id", limit(ratelimit.ScopeRedirect, handler.GetURL)...)
+	ginEngine.GET("/:id/*path", limit(ratelimit.ScopeRedirect, handler.GetURL)...)
 	ginEngine.GET("/ping", handler.Ping)
 
 	ginEngine.GET("/api/user/urls", handler.GetUserURLs)
+	ginEngine.DELETE("/api/user/urls", handler.DeleteUserURLs)
+	ginEngine.GET("/api/user/urls/:id/stats", handler.GetURLStats)
+
+	ginEngine.GET("/api/internal/stats", middleware.TrustedSubnetMiddleware(configuration.TrustedSubnet), handler.GetStats)
 }
 
 // handleServiceError обрабатывает ошибки сервиса и отправляет соответствующий текстовый ответ
 func (h *Handler) handleServiceError(c *gin.Context, err error, shortURL string) {
 	if errors.Is(err, repository.ErrRowExists) {
 		c.String(http.StatusConflict, h.Configuration.ShortAddress+"/"+shortURL)
+	} else if errors.Is(err, service.ErrURLRejected) {
+		c.String(http.StatusUnprocessableEntity, err.Error())
 	} else {
 		c.String(http.StatusInternalServerError, err.Error())
 	}
@@ -69,12 +127,24 @@ func (h *Handler) handleServiceErrorJSON(c *gin.Context, err error, shortURL str
 		var response model.Response
 		response.Result = h.Configuration.ShortAddress + "/" + shortURL
 		c.JSON(http.StatusConflict, response)
+	} else if errors.Is(err, service.ErrAliasTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	} else if errors.Is(err, service.ErrURLRejected) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	} else if isValidationError(err) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
 	c.Abort()
 }
 
+// isValidationError проверяет, вызвана ли ошибка сервиса некорректными параметрами ссылки
+func isValidationError(err error) bool {
+	return errors.Is(err, service.ErrInvalidAlias) || errors.Is(err, service.ErrInvalidExpiry) ||
+		errors.Is(err, service.ErrInvalidRedirectCode) || errors.Is(err, service.ErrInvalidQueryMerge)
+}
+
 // handleGenericErrorJSON обрабатывает общие ошибки и отправляет JSON ответ
 func (h *Handler) handleGenericErrorJSON(c *gin.Context, statusCode int, message string) {
 	c.JSON(statusCode, gin.H{"error": message})
@@ -109,7 +179,7 @@ func (h *Handler) SendURL(c *gin.Context) {
 	userIDStr := userID.(string)
 
 	// Создание короткой ссылки
-	shortURL, err := h.Service.CreateShortURL(string(body), userIDStr)
+	shortURL, err := h.Service.CreateShortURL(c.Request.Context(), string(body), userIDStr)
 	if err != nil {
 		h.handleServiceError(c, err, shortURL)
 		return
@@ -156,7 +226,7 @@ func (h *Handler) SendJSONURL(c *gin.Context) {
 	userIDStr := userID.(string)
 
 	// Создание короткой ссылки
-	shortURL, err := h.Service.CreateShortURL(request.URL, userIDStr)
+	shortURL, err := h.Service.CreateShortURLWithOptions(c.Request.Context(), request.URL, userIDStr, request.Options())
 	if err != nil {
 		h.handleServiceErrorJSON(c, err, shortURL)
 		return
@@ -172,6 +242,9 @@ func (h *Handler) SendJSONURL(c *gin.Context) {
 }
 
 // Обработка POST запроса: пакетное сокращение URL (JSON)
+// Режим задаётся параметром mode: atomic (по умолчанию) сохраняет пакет целиком или ничего,
+// best_effort сохраняет корректные элементы. Для каждого элемента возвращается свой результат,
+// ответ 201 означает, что созданы все элементы, иначе возвращается 207
 func (h *Handler) SendJSONURLBatch(c *gin.Context) {
 
 	// Проверка Content-Type
@@ -181,6 +254,12 @@ func (h *Handler) SendJSONURLBatch(c *gin.Context) {
 		return
 	}
 
+	mode := model.BatchMode(c.DefaultQuery("mode", string(model.BatchModeAtomic)))
+	if !mode.Valid() {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, "Unknown batch mode, atomic or best_effort only")
+		return
+	}
+
 	// Получаем данные из body
 	var requests []model.BatchRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&requests); err != nil {
@@ -194,21 +273,29 @@ func (h *Handler) SendJSONURLBatch(c *gin.Context) {
 		return
 	}
 
-	// Валидация всех реквестов
+	// Валидация всех реквестов, ответ сопоставляется с запросом по позиции элемента
 	validate := validator.New()
-	for _, request := range requests {
+	responses := make([]model.BatchResponse, len(requests))
+	items := make([]model.ShortenItem, 0, len(requests))
+	positions := make([]int, 0, len(requests))
+	for i, request := range requests {
+		responses[i].CorrelationID = request.CorrelationID
 		if err := validate.Struct(request); err != nil {
-			h.handleGenericErrorJSON(c, http.StatusBadRequest, err.Error())
-			return
+			// Элемент без correlation_id не с чем сопоставить, поэтому он отменяет пакет в любом режиме
+			if mode != model.BatchModeBestEffort || request.CorrelationID == "" {
+				h.handleGenericErrorJSON(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			responses[i].Status = model.BatchStatusInvalid
+			responses[i].Error = err.Error()
+			continue
 		}
-	}
 
-	// Извлекаем URL из реквестов
-	urls := make([]string, len(requests))
-	correlationMap := make(map[string]string) // originalURL -> correlationID
-	for i, request := range requests {
-		urls[i] = request.OriginalURL
-		correlationMap[request.OriginalURL] = request.CorrelationID
+		items = append(items, model.ShortenItem{
+			OriginalURL: request.OriginalURL,
+			Options:     request.Options(),
+		})
+		positions = append(positions, i)
 	}
 
 	// Получаем userID из контекста
@@ -216,26 +303,179 @@ func (h *Handler) SendJSONURLBatch(c *gin.Context) {
 	userIDStr := userID.(string)
 
 	// Создание коротких ссылок пакетом
-	shortURLsMap, err := h.Service.CreateShortURLsBatch(urls, userIDStr)
+	results, err := h.Service.CreateShortURLsBatch(c.Request.Context(), items, userIDStr, mode)
+	if errors.Is(err, service.ErrAliasTaken) || errors.Is(err, service.ErrBatchConflict) {
+		h.handleGenericErrorJSON(c, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrURLRejected) {
+		h.handleGenericErrorJSON(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if isValidationError(err) {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		h.handleGenericErrorJSON(c, http.StatusInternalServerError, "Error creating short URL")
 		return
 	}
 
 	// Формируем ответ
-	responses := make([]model.BatchResponse, 0, len(requests))
-	for originalURL, shortURL := range shortURLsMap {
-		if correlationID, exists := correlationMap[originalURL]; exists {
-			responses = append(responses, model.BatchResponse{
-				CorrelationID: correlationID,
-				ShortURL:      h.Configuration.ShortAddress + "/" + shortURL,
-			})
+	for i, result := range results {
+		response := &responses[positions[i]]
+		response.Status = result.Status
+		if result.ShortURL != "" {
+			response.ShortURL = h.Configuration.ShortAddress + "/" + result.ShortURL
+		}
+		if result.Err != nil {
+			response.Error = result.Err.Error()
+		}
+	}
+
+	statusCode := http.StatusCreated
+	for _, response := range responses {
+		if response.Status != model.BatchStatusCreated {
+			statusCode = http.StatusMultiStatus
+			break
 		}
 	}
 
 	// Пишем ответ
 	c.Header("Content-Type", "application/json")
-	c.JSON(http.StatusCreated, responses)
+	c.JSON(statusCode, responses)
+}
+
+// Обработка POST запроса: потоковое сокращение URL (NDJSON)
+// Строки читаются частями по StreamChunkSize, каждая часть сохраняется отдельной записью в режиме best_effort,
+// и её результаты сразу отправляются клиенту. Ошибка после начала ответа передаётся последней строкой
+func (h *Handler) SendNDJSONStream(c *gin.Context) {
+
+	// Проверка Content-Type
+	contentType := c.GetHeader("Content-Type")
+	if !strings.HasPrefix(strings.ToLower(contentType), middleware.NDJSONContentType) {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, "Invalid ContentType, application/x-ndjson only")
+		return
+	}
+
+	chunkSize := h.Configuration.StreamChunkSize
+	if chunkSize <= 0 {
+		chunkSize = config.DefaultStreamChunkSize
+	}
+
+	// Результаты отправляются до конца чтения тела запроса
+	if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+		log.Printf("Потоковый импорт без одновременного чтения и записи: %v", err)
+	}
+
+	// Получаем userID из контекста
+	userID, _ := c.Get(middleware.UserIDKey)
+	userIDStr := userID.(string)
+
+	validate := validator.New()
+	encoder := json.NewEncoder(c.Writer)
+	responses := make([]model.StreamResponse, 0, chunkSize)
+	items := make([]model.ShortenItem, 0, chunkSize)
+	positions := make([]int, 0, chunkSize)
+
+	// flush сохраняет накопленную часть и отправляет её результаты
+	flush := func() error {
+		results, err := h.Service.CreateShortURLsBatch(c.Request.Context(), items, userIDStr, model.BatchModeBestEffort)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			response := &responses[positions[i]]
+			response.Status = result.Status
+			if result.ShortURL != "" {
+				response.ShortURL = h.Configuration.ShortAddress + "/" + result.ShortURL
+			}
+			if result.Err != nil {
+				response.Error = result.Err.Error()
+			}
+		}
+
+		c.Header("Content-Type", middleware.NDJSONContentType)
+		for _, response := range responses {
+			if err := encoder.Encode(response); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+
+		responses, items, positions = responses[:0], items[:0], positions[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, 4096), streamMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		response := model.StreamResponse{Line: line}
+		var request model.BatchRequest
+		err := json.Unmarshal(text, &request)
+		if err == nil {
+			response.CorrelationID = request.CorrelationID
+			err = validate.Struct(request)
+		}
+		if err != nil {
+			response.Status = model.BatchStatusInvalid
+			response.Error = err.Error()
+		} else {
+			items = append(items, model.ShortenItem{OriginalURL: request.OriginalURL, Options: request.Options()})
+			positions = append(positions, len(responses))
+		}
+		responses = append(responses, response)
+
+		if len(responses) == chunkSize {
+			if err := flush(); err != nil {
+				h.streamFailed(c, encoder, err)
+				return
+			}
+		}
+	}
+
+	// Прочитанные строки сохраняются и при ошибке чтения, чтобы клиент знал, с какой строки продолжить
+	if len(responses) > 0 {
+		if err := flush(); err != nil {
+			h.streamFailed(c, encoder, err)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		message := err.Error()
+		if errors.Is(err, bufio.ErrTooLong) {
+			message = fmt.Sprintf("line %d is longer than %d bytes", line+1, streamMaxLineSize)
+		}
+		h.streamError(c, encoder, http.StatusBadRequest, message)
+		return
+	}
+
+	if !c.Writer.Written() {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, "Empty stream not allowed")
+	}
+}
+
+// streamFailed завершает потоковый ответ при ошибке сохранения
+func (h *Handler) streamFailed(c *gin.Context, encoder *json.Encoder, err error) {
+	log.Printf("Ошибка потокового импорта: %v", err)
+	h.streamError(c, encoder, http.StatusInternalServerError, "Error creating short URL")
+}
+
+// streamError завершает потоковый ответ строкой с ошибкой, пока ответ не начат, возвращается код ошибки
+func (h *Handler) streamError(c *gin.Context, encoder *json.Encoder, statusCode int, message string) {
+	if !c.Writer.Written() {
+		h.handleGenericErrorJSON(c, statusCode, message)
+		return
+	}
+	_ = encoder.Encode(gin.H{"error": message})
+	c.Writer.Flush()
 }
 
 // Обработка GET запроса: редирект по короткой ссылке
@@ -243,26 +483,73 @@ func (h *Handler) GetURL(c *gin.Context) {
 	// Получаем параметр из URL: /:id
 	shortURL := c.Param("id")
 
+	// Путь после ключа берём в экранированном виде, чтобы закодированные слеши остались внутри сегментов
+	request := model.RedirectRequest{Query: c.Request.URL.RawQuery}
+	if c.Param("path") != "" {
+		_, request.Path, _ = strings.Cut(strings.TrimPrefix(c.Request.URL.EscapedPath(), "/"), "/")
+	}
+
 	// Ищем полную ссылку
-	fullURL, err := h.Service.GetFullURL(shortURL)
+	redirect, err := h.Service.GetRedirect(c.Request.Context(), shortURL, request)
+	if errors.Is(err, repository.ErrURLDeleted) {
+		h.handleGenericErrorText(c, http.StatusGone, "URL deleted")
+		return
+	}
+	if errors.Is(err, repository.ErrURLExpired) {
+		h.handleGenericErrorText(c, http.StatusGone, "URL expired")
+		return
+	}
+	if errors.Is(err, repository.ErrUnavailable) {
+		h.handleGenericErrorText(c, http.StatusServiceUnavailable, "Storage unavailable")
+		return
+	}
 	if err != nil {
 		h.handleGenericErrorText(c, http.StatusBadRequest, "URL not found")
 		return
 	}
 
-	// Редирект (307)
-	c.Redirect(http.StatusTemporaryRedirect, fullURL)
+	// Регистрируем переход, запись происходит асинхронно
+	h.Service.RecordClick(shortURL, c.Request.Referer(), c.Request.UserAgent(), c.ClientIP())
+
+	// Постоянные перенаправления разрешаем кешировать браузерам и CDN, временные не кешируются,
+	// чтобы каждый переход доходил до сервиса и попадал в статистику
+	if redirect.CacheFor > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(redirect.CacheFor.Seconds())))
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+
+	// Редирект с кодом ссылки или кодом по умолчанию
+	c.Redirect(redirect.StatusCode, redirect.URL)
 }
 
 // Ping PostgreSQL
 func (h *Handler) Ping(c *gin.Context) {
-	if err := h.Service.PingPostgreSQL(); err != nil {
+	if err := h.Service.PingPostgreSQL(c.Request.Context()); err != nil {
 		h.handleGenericErrorJSON(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "OK"})
 }
 
+// Healthz сообщает, что процесс жив и обрабатывает запросы
+func (h *Handler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "OK"})
+}
+
+// Readyz сообщает, готов ли сервис принимать запросы, с состоянием каждого компонента
+func (h *Handler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	readiness := h.Service.Readiness(ctx)
+	if !readiness.Ready() {
+		c.JSON(http.StatusServiceUnavailable, readiness)
+		return
+	}
+	c.JSON(http.StatusOK, readiness)
+}
+
 // GetUserURLs возвращает все URL пользователя
 func (h *Handler) GetUserURLs(c *gin.Context) {
 	// Получаем userID из контекста
@@ -278,12 +565,28 @@ func (h *Handler) GetUserURLs(c *gin.Context) {
 		return
 	}
 
-	// Получаем URL пользователя
-	userURLs, err := h.Service.GetUserURLs(userIDStr)
+	query, err := parseUserURLsQuery(c)
+	if err != nil {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Получаем страницу URL пользователя
+	page, err := h.Service.GetUserURLs(c.Request.Context(), userIDStr, query)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		h.handleGenericErrorJSON(c, http.StatusInternalServerError, "Error retrieving user URLs")
 		return
 	}
+	userURLs := page.URLs
+
+	// Курсор следующей страницы передаётся в заголовке, чтобы тело осталось массивом
+	if page.NextCursor != "" {
+		c.Header(nextCursorHeader, page.NextCursor)
+	}
 
 	// Если у пользователя нет URL
 	if len(userURLs) == 0 {
@@ -293,13 +596,123 @@ func (h *Handler) GetUserURLs(c *gin.Context) {
 
 	// Формируем ответ с полными URL
 	response := make([]model.UserURL, len(userURLs))
-	for i, urlData := range userURLs {
+	for i, url := range userURLs {
 		response[i] = model.UserURL{
-			ShortURL:    h.Configuration.ShortAddress + "/" + urlData["short_url"],
-			OriginalURL: urlData["original_url"],
+			ShortURL:    h.Configuration.ShortAddress + "/" + url.ShortURL,
+			OriginalURL: url.OriginalURL,
+			DeletedAt:   url.DeletedAt,
+			ExpiresAt:   url.ExpiresAt,
+			ClickCount:  url.ClickCount,
+		}
+		// Для записей без даты создания поле не выводится
+		if !url.CreatedAt.IsZero() {
+			createdAt := url.CreatedAt
+			response[i].CreatedAt = &createdAt
 		}
 	}
 
 	c.Header("Content-Type", "application/json")
 	c.JSON(http.StatusOK, response)
 }
+
+// parseUserURLsQuery разбирает параметры пагинации, сортировки и фильтрации списка URL
+func parseUserURLsQuery(c *gin.Context) (repository.UserURLsQuery, error) {
+	query := repository.UserURLsQuery{
+		Limit:    repository.DefaultUserURLsLimit,
+		Cursor:   c.Query("cursor"),
+		Contains: c.Query("q"),
+		Domain:   c.Query("domain"),
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > repository.MaxUserURLsLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", repository.MaxUserURLsLimit)
+		}
+		query.Limit = limit
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		query.Ascending = true
+	case "desc":
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+
+	return query, nil
+}
+
+// DeleteUserURLs принимает список коротких URL пользователя на асинхронное удаление
+func (h *Handler) DeleteUserURLs(c *gin.Context) {
+	// Проверка Content-Type
+	contentType := c.GetHeader("Content-Type")
+	if !strings.HasPrefix(strings.ToLower(contentType), "application/json") {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, "Invalid ContentType, application/json only")
+		return
+	}
+
+	// Получаем userID из контекста
+	userID, exists := c.Get(middleware.UserIDKey)
+	if !exists || userID.(string) == "" {
+		h.handleGenericErrorJSON(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Получаем список ID из body
+	var shortURLs []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&shortURLs); err != nil {
+		h.handleGenericErrorJSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Ставим удаление в очередь
+	err := h.Service.DeleteUserURLs(userID.(string), shortURLs)
+	if errors.Is(err, service.ErrDeleteQueueFull) || errors.Is(err, service.ErrDeleteQueueClosed) {
+		h.handleGenericErrorJSON(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		h.handleGenericErrorJSON(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// GetStats возвращает статистику сервиса для доверенной подсети
+func (h *Handler) GetStats(c *gin.Context) {
+	stats, err := h.Service.GetStats(c.Request.Context())
+	if err != nil {
+		h.handleGenericErrorJSON(c, http.StatusInternalServerError, "Error retrieving stats")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetURLStats возвращает статистику переходов по ссылке её владельцу
+func (h *Handler) GetURLStats(c *gin.Context) {
+	// Получаем userID из контекста
+	userID, exists := c.Get(middleware.UserIDKey)
+	if !exists || userID.(string) == "" {
+		h.handleGenericErrorJSON(c, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	stats, err := h.Service.GetClickStats(c.Request.Context(), c.Param("id"), userID.(string))
+	if errors.Is(err, repository.ErrNotFound) {
+		h.handleGenericErrorJSON(c, http.StatusNotFound, "URL not found")
+		return
+	}
+	if errors.Is(err, service.ErrNotOwner) {
+		h.handleGenericErrorJSON(c, http.StatusForbidden, "Forbidden")
+		return
+	}
+	if err != nil {
+		h.handleGenericErrorJSON(c, http.StatusInternalServerError, "Error retrieving stats")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,8 +12,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/middleware"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
 	"github.com/gin-gonic/gin"
@@ -81,7 +87,7 @@ func TestSendURLHandler(t *testing.T) {
 		assert.NoError(t, err)
 		defer resp.Body.Close()
 
-		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
 	})
 }
 
@@ -93,7 +99,7 @@ func TestGetURLHandler(t *testing.T) {
 
 	// Предварительно создаем тестовую короткую ссылку
 	longURL := "https://redirect.me"
-	shortURL, err := h.Service.CreateShortURL(longURL, "")
+	shortURL, err := h.Service.CreateShortURL(context.Background(), longURL, "")
 	assert.NoError(t, err)
 
 	// Создаем клиент, который не следует за редиректами автоматически
@@ -329,7 +335,7 @@ func TestRedirectIntegration(t *testing.T) {
 	t.Run("redirect works correctly", func(t *testing.T) {
 		// Создаем ссылку напрямую через сервис
 		longURL := "https://redirect-test.com"
-		shortURL, err := h.Service.CreateShortURL(longURL, "")
+		shortURL, err := h.Service.CreateShortURL(context.Background(), longURL, "")
 		assert.NoError(t, err)
 
 		// Проверяем редирект
@@ -466,7 +472,7 @@ func TestSendJSONURLBatchHandler(t *testing.T) {
 		for i := 1; i <= 100; i++ {
 			requests = append(requests, map[string]string{
 				"correlation_id": fmt.Sprintf("id_%d", i),
-				"original_url":   fmt.Sprintf("https://example%d.com", i),
+				"original_url":   fmt.Sprintf("https://large%d.example.com", i),
 			})
 		}
 
@@ -491,3 +497,1060 @@ func TestSendJSONURLBatchHandler(t *testing.T) {
 		assert.Len(t, responses, 100)
 	})
 }
+
+// Тесты для асинхронного удаления ссылок пользователя
+func TestDeleteUserURLsHandler(t *testing.T) {
+	mux, h := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Создаём ссылку, чтобы получить куку пользователя
+	req, err := http.NewRequest("POST", server.URL+"/", bytes.NewBufferString("https://to-delete.com"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	cookies := resp.Cookies()
+	shortID := strings.TrimPrefix(string(body), "http://localhost:8080/")
+
+	t.Run("delete accepted", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", server.URL+"/api/user/urls", bytes.NewBufferString(`["`+shortID+`"]`))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	})
+
+	t.Run("deleted URL returns 410", func(t *testing.T) {
+		// Дожидаемся сброса очереди удалений
+		h.Service.FlushDeletions()
+
+		resp, err := client.Get(server.URL + "/" + shortID)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		req, err := http.NewRequest("DELETE", server.URL+"/api/user/urls", bytes.NewBufferString(`{"id":1}`))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// Тесты для внутренней статистики
+func TestGetStatsHandler(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	configuration := &config.ConfigStruct{
+		Port:          ":8080",
+		ShortAddress:  "http://localhost:8080",
+		TrustedSubnet: "10.0.0.0/8",
+	}
+	service := service.NewURLShortnerService(repo, configuration)
+	ginEngine := gin.Default()
+	NewHandler(ginEngine, service, configuration)
+
+	_, _ = service.CreateShortURL(context.Background(), "https://stats1.com", "user-1")
+	_, _ = service.CreateShortURL(context.Background(), "https://stats2.com", "user-1")
+	_, _ = service.CreateShortURL(context.Background(), "https://stats3.com", "user-2")
+
+	t.Run("trusted ip gets stats", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/internal/stats", nil)
+		req.Header.Set("X-Real-IP", "10.1.2.3")
+		w := httptest.NewRecorder()
+		ginEngine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"urls": 3, "users": 2}`, w.Body.String())
+	})
+
+	t.Run("untrusted ip is forbidden", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/internal/stats", nil)
+		req.Header.Set("X-Real-IP", "192.168.0.1")
+		w := httptest.NewRecorder()
+		ginEngine.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+// Тесты для пользовательских алиасов
+func TestCustomAliasHandler(t *testing.T) {
+	mux, _ := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	shorten := func(body string) *http.Response {
+		req, err := http.NewRequest("POST", server.URL+"/api/shorten", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("alias is used in short URL", func(t *testing.T) {
+		resp := shorten(`{"url": "https://example.com/spring", "alias": "spring-sale"}`)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.JSONEq(t, `{"result": "http://localhost:8080/spring-sale"}`, string(body))
+	})
+
+	t.Run("taken alias returns 409", func(t *testing.T) {
+		resp := shorten(`{"url": "https://example.com/autumn", "alias": "spring-sale"}`)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "alias is already taken")
+	})
+
+	t.Run("reserved alias returns 409", func(t *testing.T) {
+		resp := shorten(`{"url": "https://example.com/ping", "alias": "ping"}`)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "is reserved")
+	})
+
+	t.Run("service routes are reserved aliases", func(t *testing.T) {
+		for _, alias := range []string{"metrics", "healthz", "readyz"} {
+			resp := shorten(`{"url": "https://example.com/` + alias + `", "alias": "` + alias + `"}`)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusConflict, resp.StatusCode, alias)
+		}
+	})
+
+	t.Run("batch alias", func(t *testing.T) {
+		jsonBody := `[{"correlation_id": "1", "original_url": "https://batch-alias.com", "alias": "batch-alias"}]`
+		req, err := http.NewRequest("POST", server.URL+"/api/shorten/batch", bytes.NewBufferString(jsonBody))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "http://localhost:8080/batch-alias")
+	})
+}
+
+// Тесты для ссылок с ограниченным сроком действия
+func TestExpiringLinksHandler(t *testing.T) {
+	mux, _ := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	shorten := func(body string) *http.Response {
+		req, err := http.NewRequest("POST", server.URL+"/api/shorten", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("expired link returns 410", func(t *testing.T) {
+		expiresAt := time.Now().Add(50 * time.Millisecond).UTC().Format(time.RFC3339Nano)
+		resp := shorten(`{"url": "https://expiring.com", "expires_at": "` + expiresAt + `"}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var response struct {
+			Result string `json:"result"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+
+		time.Sleep(100 * time.Millisecond)
+
+		redirect, err := client.Get(server.URL + strings.TrimPrefix(response.Result, "http://localhost:8080"))
+		assert.NoError(t, err)
+		defer redirect.Body.Close()
+		assert.Equal(t, http.StatusGone, redirect.StatusCode)
+	})
+
+	t.Run("invalid expiry returns 400", func(t *testing.T) {
+		resp := shorten(`{"url": "https://bad-expiry.com", "expires_in": -5}`)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("overflowing expiry returns 400", func(t *testing.T) {
+		for _, expiresIn := range []string{"9223372036854775807", "-9223372036854775807", "9300000000"} {
+			resp := shorten(`{"url": "https://huge-expiry.com", "expires_in": ` + expiresIn + `}`)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, expiresIn)
+		}
+	})
+}
+
+func TestRedirectCodesHandler(t *testing.T) {
+	mux, _ := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	shorten := func(body string) (*http.Response, string) {
+		resp, err := client.Post(server.URL+"/api/shorten", "application/json", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+
+		var response struct {
+			Result string `json:"result"`
+		}
+		if resp.StatusCode == http.StatusCreated {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		}
+		return resp, strings.TrimPrefix(response.Result, "http://localhost:8080")
+	}
+
+	t.Run("permanent redirect is cacheable", func(t *testing.T) {
+		resp, path := shorten(`{"url": "https://moved.com", "redirect_code": 301}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		redirect, err := client.Get(server.URL + path)
+		assert.NoError(t, err)
+		defer redirect.Body.Close()
+
+		assert.Equal(t, http.StatusMovedPermanently, redirect.StatusCode)
+		assert.Equal(t, "https://moved.com", redirect.Header.Get("Location"))
+		assert.Equal(t, "public, max-age=86400", redirect.Header.Get("Cache-Control"))
+	})
+
+	t.Run("temporary redirect is not cached", func(t *testing.T) {
+		resp, path := shorten(`{"url": "https://temporary.com"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		redirect, err := client.Get(server.URL + path)
+		assert.NoError(t, err)
+		defer redirect.Body.Close()
+
+		assert.Equal(t, http.StatusTemporaryRedirect, redirect.StatusCode)
+		assert.Equal(t, "no-store", redirect.Header.Get("Cache-Control"))
+	})
+
+	t.Run("unsupported code returns 400", func(t *testing.T) {
+		resp, _ := shorten(`{"url": "https://see-other.com", "redirect_code": 303}`)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestPassthroughHandler(t *testing.T) {
+	mux, _ := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	shorten := func(body string) string {
+		resp, err := client.Post(server.URL+"/api/shorten", "application/json", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var response struct {
+			Result string `json:"result"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		return strings.TrimPrefix(response.Result, "http://localhost:8080")
+	}
+
+	follow := func(path string) *http.Response {
+		resp, err := client.Get(server.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	docs := shorten(`{"url": "https://docs.example.com/v1?lang=en", "path_passthrough": true, "query_merge": "override"}`)
+	plain := shorten(`{"url": "https://plain.example.com/page"}`)
+
+	t.Run("path and query are forwarded", func(t *testing.T) {
+		resp := follow(docs + "/guide/start?lang=de&utm_source=mail")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "https://docs.example.com/v1/guide/start?lang=de&utm_source=mail", resp.Header.Get("Location"))
+	})
+
+	t.Run("trailing slash redirects to link", func(t *testing.T) {
+		resp := follow(plain + "/")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "https://plain.example.com/page", resp.Header.Get("Location"))
+	})
+
+	t.Run("query is dropped without merge", func(t *testing.T) {
+		resp := follow(plain + "?utm_source=mail")
+		assert.Equal(t, "https://plain.example.com/page", resp.Header.Get("Location"))
+	})
+
+	t.Run("path without passthrough is not found", func(t *testing.T) {
+		resp := follow(plain + "/docs")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("encoded path cannot change host", func(t *testing.T) {
+		resp := follow(docs + "/%2F%2Fevil.com")
+		assert.Equal(t, "https://docs.example.com/v1/%2F%2Fevil.com?lang=en", resp.Header.Get("Location"))
+
+		resp = follow(docs + "/..%2F..%2Fadmin")
+		assert.Equal(t, "https://docs.example.com/v1/..%2F..%2Fadmin?lang=en", resp.Header.Get("Location"))
+
+		resp = follow(docs + "/%2E%2E/admin")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("unknown merge mode returns 400", func(t *testing.T) {
+		resp, err := client.Post(server.URL+"/api/shorten", "application/json",
+			bytes.NewBufferString(`{"url": "https://bad-merge.com", "query_merge": "replace"}`))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// Тесты для статистики переходов по ссылке
+func TestGetURLStatsHandler(t *testing.T) {
+	mux, h := setupTest()
+	// Адреса переходов передаются через X-Forwarded-For, поэтому тестовый клиент считается прокси
+	assert.NoError(t, mux.SetTrustedProxies([]string{"127.0.0.1"}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Создаём ссылку от имени нового пользователя
+	req, err := http.NewRequest("POST", server.URL+"/", bytes.NewBufferString("https://clicks.com"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	cookies := resp.Cookies()
+	shortID := strings.TrimPrefix(string(body), "http://localhost:8080/")
+
+	// Два перехода с разными IP
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+		req, err := http.NewRequest("GET", server.URL+"/"+shortID, nil)
+		assert.NoError(t, err)
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set("Referer", "https://mail.example.com")
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+
+	// Дожидаемся сохранения буфера переходов
+	h.Service.FlushClicks()
+
+	getStats := func(id string, withCookies bool) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+"/api/user/urls/"+id+"/stats", nil)
+		assert.NoError(t, err)
+		if withCookies {
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+		}
+
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	t.Run("owner gets stats", func(t *testing.T) {
+		resp := getStats(shortID, true)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var stats struct {
+			TotalClicks    int `json:"total_clicks"`
+			UniqueVisitors int `json:"unique_visitors"`
+			Hourly         []struct {
+				Clicks int `json:"clicks"`
+			} `json:"hourly"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		assert.Equal(t, 2, stats.TotalClicks)
+		assert.Equal(t, 2, stats.UniqueVisitors)
+		assert.NotEmpty(t, stats.Hourly)
+	})
+
+	t.Run("stranger is forbidden", func(t *testing.T) {
+		resp := getStats(shortID, false)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("unknown link", func(t *testing.T) {
+		resp := getStats("unknown", true)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestGetUserURLsHandler(t *testing.T) {
+	mux, h := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Создаём ссылку со сроком действия от имени нового пользователя
+	req, err := http.NewRequest("POST", server.URL+"/api/shorten", bytes.NewBufferString(`{"url":"https://details.com","expires_in":3600}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	var created model.Response
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+
+	cookies := resp.Cookies()
+	shortID := strings.TrimPrefix(created.Result, "http://localhost:8080/")
+
+	// Один переход по ссылке
+	resp, err = client.Get(server.URL + "/" + shortID)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	h.Service.FlushClicks()
+
+	req, err = http.NewRequest("GET", server.URL+"/api/user/urls", nil)
+	assert.NoError(t, err)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var urls []model.UserURL
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&urls))
+	if assert.Len(t, urls, 1) {
+		assert.Equal(t, created.Result, urls[0].ShortURL)
+		assert.Equal(t, "https://details.com", urls[0].OriginalURL)
+		assert.NotNil(t, urls[0].CreatedAt)
+		assert.NotNil(t, urls[0].ExpiresAt)
+		assert.Nil(t, urls[0].DeletedAt)
+		assert.Equal(t, 1, urls[0].ClickCount)
+	}
+}
+
+func TestGetUserURLsPagination(t *testing.T) {
+	mux, _ := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Создаём три ссылки от имени одного пользователя
+	var cookies []*http.Cookie
+	for _, originalURL := range []string{"https://a.example.com/1", "https://b.example.com/2", "https://other.org/3"} {
+		req, err := http.NewRequest("POST", server.URL+"/", bytes.NewBufferString(originalURL))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		if cookies == nil {
+			cookies = resp.Cookies()
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	getURLs := func(query string) (*http.Response, []model.UserURL) {
+		req, err := http.NewRequest("GET", server.URL+"/api/user/urls"+query, nil)
+		assert.NoError(t, err)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var urls []model.UserURL
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&urls))
+		}
+		return resp, urls
+	}
+
+	t.Run("pages follow the cursor", func(t *testing.T) {
+		resp, urls := getURLs("?limit=2&order=asc")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		if assert.Len(t, urls, 2) {
+			assert.Equal(t, "https://a.example.com/1", urls[0].OriginalURL)
+		}
+
+		cursor := resp.Header.Get("X-Next-Cursor")
+		assert.NotEmpty(t, cursor)
+
+		resp, urls = getURLs("?limit=2&order=asc&cursor=" + cursor)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		if assert.Len(t, urls, 1) {
+			assert.Equal(t, "https://other.org/3", urls[0].OriginalURL)
+		}
+		assert.Empty(t, resp.Header.Get("X-Next-Cursor"))
+	})
+
+	t.Run("filter by domain", func(t *testing.T) {
+		resp, urls := getURLs("?domain=example.com")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, urls, 2)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"?limit=0", "?limit=abc", "?order=random", "?cursor=broken"} {
+			resp, _ := getURLs(query)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+}
+
+func TestMetricsHandler(t *testing.T) {
+	mux, _ := setupTest()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Создаём и открываем ссылку, чтобы появились метрики маршрутов
+	resp, err := client.Post(server.URL+"/", "text/plain", bytes.NewBufferString("https://metrics.com"))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	resp, err = client.Get(server.URL + "/" + strings.TrimPrefix(string(body), "http://localhost:8080/"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = client.Get(server.URL + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+
+	metrics, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(metrics), `shortener_http_requests_total{method="POST",route="/",status="201"}`)
+	assert.Contains(t, string(metrics), `shortener_http_request_duration_seconds_bucket{method="GET",route="/:id",status="307"`)
+	assert.Contains(t, string(metrics), `shortener_redirects_total{result="hit"}`)
+	assert.Contains(t, string(metrics), `shortener_links_created_total{kind="single"}`)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	mux, h := setupTest()
+
+	t.Run("liveness", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var readiness model.Readiness
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &readiness))
+		assert.Equal(t, model.StatusReady, readiness.Status)
+		assert.Equal(t, model.ComponentUp, readiness.Components["storage"].Status)
+		assert.Equal(t, model.ComponentUp, readiness.Components["server"].Status)
+	})
+
+	t.Run("not ready while draining", func(t *testing.T) {
+		h.Service.StartDraining()
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		var readiness model.Readiness
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &readiness))
+		assert.Equal(t, model.StatusNotReady, readiness.Status)
+		assert.Equal(t, model.ComponentDraining, readiness.Components["server"].Status)
+
+		// Живость от остановки не зависит
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+// Тесты для ограничения частоты запросов
+func TestRateLimitedRoutes(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	configuration := &config.ConfigStruct{
+		Port:           ":8080",
+		ShortAddress:   "http://localhost:8080",
+		RateLimitBatch: config.RateLimit{Requests: 1, Period: time.Minute},
+	}
+	service := service.NewURLShortnerService(repo, configuration)
+	ginEngine := gin.Default()
+	NewHandler(ginEngine, service, configuration)
+
+	sendBatch := func(originalURL string) *httptest.ResponseRecorder {
+		body := `[{"correlation_id": "1", "original_url": "` + originalURL + `"}]`
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		ginEngine.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("batch route is limited", func(t *testing.T) {
+		w := sendBatch("https://limited1.com")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+		w = sendBatch("https://limited2.com")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("routes without limit are not limited", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf("https://unlimited%d.com", i)))
+			req.Header.Set("Content-Type", "text/plain")
+			w := httptest.NewRecorder()
+			ginEngine.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusCreated, w.Code)
+			assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+		}
+	})
+}
+
+// Тесты для результатов элементов пакета
+func TestTrustedProxies(t *testing.T) {
+	shorten := func(ginEngine *gin.Engine, forwardedFor, originalURL string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(originalURL))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		ginEngine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	setup := func(proxies []string) *gin.Engine {
+		configuration := &config.ConfigStruct{
+			Port:            ":8080",
+			ShortAddress:    "http://localhost:8080",
+			RateLimitCreate: config.RateLimit{Requests: 1, Period: time.Minute},
+			TrustedProxies:  proxies,
+		}
+		ginEngine := gin.Default()
+		NewHandler(ginEngine, service.NewURLShortnerService(repository.NewMemoryRepository(), configuration), configuration)
+		return ginEngine
+	}
+
+	t.Run("forwarded address is ignored by default", func(t *testing.T) {
+		ginEngine := setup(nil)
+		assert.Equal(t, http.StatusCreated, shorten(ginEngine, "203.0.113.1", "https://proxy1.com"))
+		assert.Equal(t, http.StatusTooManyRequests, shorten(ginEngine, "203.0.113.2", "https://proxy2.com"))
+	})
+
+	t.Run("forwarded address from trusted proxy", func(t *testing.T) {
+		// httptest.NewRequest приходит с адреса 192.0.2.1
+		ginEngine := setup([]string{"192.0.2.0/24"})
+		assert.Equal(t, http.StatusCreated, shorten(ginEngine, "203.0.113.1", "https://proxy3.com"))
+		assert.Equal(t, http.StatusCreated, shorten(ginEngine, "203.0.113.2", "https://proxy4.com"))
+		assert.Equal(t, http.StatusTooManyRequests, shorten(ginEngine, "203.0.113.1", "https://proxy5.com"))
+	})
+}
+
+func TestBatchItemResults(t *testing.T) {
+	mux, _ := setupTest()
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) []model.BatchResponse {
+		var responses []model.BatchResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &responses))
+		return responses
+	}
+
+	t.Run("duplicates keep their correlation ids", func(t *testing.T) {
+		w := send("/api/shorten/batch", `[
+			{"correlation_id": "a", "original_url": "https://dup.example.com/"},
+			{"correlation_id": "b", "original_url": "https://dup.example.com/"}
+		]`)
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+		responses := decode(t, w)
+		assert.Len(t, responses, 2)
+		assert.Equal(t, "a", responses[0].CorrelationID)
+		assert.Equal(t, model.BatchStatusCreated, responses[0].Status)
+		assert.Equal(t, "b", responses[1].CorrelationID)
+		assert.Equal(t, model.BatchStatusExists, responses[1].Status)
+		assert.Equal(t, responses[0].ShortURL, responses[1].ShortURL)
+	})
+
+	t.Run("already shortened link does not fail the batch", func(t *testing.T) {
+		w := send("/api/shorten/batch", `[
+			{"correlation_id": "1", "original_url": "https://dup.example.com/"},
+			{"correlation_id": "2", "original_url": "https://fresh.example.com/"}
+		]`)
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+		responses := decode(t, w)
+		assert.Equal(t, model.BatchStatusExists, responses[0].Status)
+		assert.Equal(t, model.BatchStatusCreated, responses[1].Status)
+		assert.Contains(t, responses[1].ShortURL, "http://localhost:8080/")
+	})
+
+	t.Run("best effort keeps valid items", func(t *testing.T) {
+		w := send("/api/shorten/batch?mode=best_effort", `[
+			{"correlation_id": "ok", "original_url": "https://best.example.com/"},
+			{"correlation_id": "bad", "original_url": "not-a-valid-url"},
+			{"correlation_id": "loop", "original_url": "http://localhost:8080/loop"}
+		]`)
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+		responses := decode(t, w)
+		assert.Equal(t, model.BatchStatusCreated, responses[0].Status)
+		assert.Equal(t, model.BatchStatusInvalid, responses[1].Status)
+		assert.Empty(t, responses[1].ShortURL)
+		assert.NotEmpty(t, responses[1].Error)
+		assert.Equal(t, model.BatchStatusInvalid, responses[2].Status)
+		assert.Contains(t, responses[2].Error, "url is not allowed")
+	})
+
+	t.Run("atomic is the default mode", func(t *testing.T) {
+		w := send("/api/shorten/batch", `[
+			{"correlation_id": "ok", "original_url": "https://atomic.example.com/"},
+			{"correlation_id": "loop", "original_url": "http://localhost:8080/loop"}
+		]`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "item 1")
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		w := send("/api/shorten/batch?mode=partial", `[{"correlation_id": "1", "original_url": "https://mode.example.com/"}]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// Тесты для повторов запросов с ключом идемпотентности
+func TestIdempotentRoutes(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	configuration := &config.ConfigStruct{
+		Port:           ":8080",
+		ShortAddress:   "http://localhost:8080",
+		IdempotencyTTL: config.Duration(time.Hour),
+	}
+	service := service.NewURLShortnerService(repo, configuration)
+	ginEngine := gin.Default()
+	NewHandler(ginEngine, service, configuration)
+
+	var cookies []*http.Cookie
+	send := func(path, contentType, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		ginEngine.ServeHTTP(w, req)
+		if len(cookies) == 0 {
+			cookies = w.Result().Cookies()
+		}
+		return w
+	}
+
+	t.Run("batch retry gets the same links", func(t *testing.T) {
+		body := `[{"correlation_id": "1", "original_url": "https://idempotent1.example.com/"},
+			{"correlation_id": "2", "original_url": "https://idempotent2.example.com/"}]`
+
+		first := send("/api/shorten/batch", "application/json", "batch-1", body)
+		assert.Equal(t, http.StatusCreated, first.Code)
+
+		retry := send("/api/shorten/batch", "application/json", "batch-1", body)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+		assert.JSONEq(t, first.Body.String(), retry.Body.String())
+
+		// Без ключа тот же пакет получает статус exists
+		assert.Equal(t, http.StatusMultiStatus, send("/api/shorten/batch", "application/json", "", body).Code)
+	})
+
+	t.Run("key reused with another body", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("/", "text/plain", "plain-1", "https://idempotent3.example.com/").Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, send("/", "text/plain", "plain-1", "https://idempotent4.example.com/").Code)
+	})
+
+	t.Run("stream ignores the key", func(t *testing.T) {
+		line := `{"correlation_id": "1", "original_url": "https://idempotent5.example.com/"}` + "\n"
+		assert.Equal(t, http.StatusOK, send("/api/shorten/stream", middleware.NDJSONContentType, "stream-1", line).Code)
+		w := send("/api/shorten/stream", middleware.NDJSONContentType, "stream-1", line)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(middleware.IdempotentReplayedHeader))
+	})
+}
+
+// Тесты для потокового сокращения ссылок
+func TestSendNDJSONStream(t *testing.T) {
+	configuration := &config.ConfigStruct{
+		Port:            ":8080",
+		ShortAddress:    "http://localhost:8080",
+		StreamChunkSize: 2,
+	}
+	svc := service.NewURLShortnerService(repository.NewMemoryRepository(), configuration)
+	defer svc.Close()
+	mux := gin.Default()
+	NewHandler(mux, svc, configuration)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	send := func(t *testing.T, body io.Reader, header map[string]string) (*http.Response, []map[string]any) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/shorten/stream", body)
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var lines []map[string]any
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line map[string]any
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+			lines = append(lines, line)
+		}
+		return resp, lines
+	}
+
+	t.Run("results in request order", func(t *testing.T) {
+		resp, lines := send(t, strings.NewReader(strings.Join([]string{
+			`{"correlation_id": "1", "original_url": "https://stream1.example.com/"}`,
+			`{"correlation_id": "2", "original_url": "not-a-valid-url"}`,
+			``,
+			`{"correlation_id": "3", "original_url": "https://STREAM1.example.com"}`,
+			`not json`,
+			`{"correlation_id": "5", "original_url": "http://localhost:8080/loop"}`,
+		}, "\n")), nil)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		if !assert.Len(t, lines, 5) {
+			return
+		}
+
+		assert.Equal(t, float64(1), lines[0]["line"])
+		assert.Equal(t, model.BatchStatusCreated, lines[0]["status"])
+		assert.Equal(t, model.BatchStatusInvalid, lines[1]["status"])
+		assert.Equal(t, "2", lines[1]["correlation_id"])
+
+		// Повтор из следующей части находит ссылку, сохранённую раньше
+		assert.Equal(t, float64(4), lines[2]["line"])
+		assert.Equal(t, model.BatchStatusExists, lines[2]["status"])
+		assert.Equal(t, lines[0]["short_url"], lines[2]["short_url"])
+
+		assert.Equal(t, float64(5), lines[3]["line"])
+		assert.Equal(t, model.BatchStatusInvalid, lines[3]["status"])
+		assert.Contains(t, lines[4]["error"], "url is not allowed")
+	})
+
+	t.Run("chunk results arrive before the request ends", func(t *testing.T) {
+		reader, writer := io.Pipe()
+		next := make(chan struct{})
+		go func() {
+			fmt.Fprintln(writer, `{"correlation_id": "1", "original_url": "https://pipe1.example.com/"}`)
+			fmt.Fprintln(writer, `{"correlation_id": "2", "original_url": "https://pipe2.example.com/"}`)
+			<-next
+			fmt.Fprintln(writer, `{"correlation_id": "3", "original_url": "https://pipe3.example.com/"}`)
+			writer.Close()
+		}()
+
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/shorten/stream", reader)
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			close(next)
+			return
+		}
+		defer resp.Body.Close()
+
+		// Первая часть приходит, пока клиент ещё не дописал запрос
+		scanner := bufio.NewScanner(resp.Body)
+		for i := 0; i < 2; i++ {
+			assert.True(t, scanner.Scan())
+			assert.Contains(t, scanner.Text(), `"status":"created"`)
+		}
+		close(next)
+
+		assert.True(t, scanner.Scan())
+		assert.Contains(t, scanner.Text(), `"correlation_id":"3"`)
+		assert.False(t, scanner.Scan())
+	})
+
+	t.Run("gzip request", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(gz, `{"correlation_id": "%d", "original_url": "https://gzip%d.example.com/"}`+"\n", i, i)
+		}
+		assert.NoError(t, gz.Close())
+
+		resp, lines := send(t, &buf, map[string]string{"Content-Encoding": "gzip"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, lines, 5)
+		for _, line := range lines {
+			assert.Equal(t, model.BatchStatusCreated, line["status"])
+		}
+	})
+
+	t.Run("too long line ends the stream", func(t *testing.T) {
+		body := `{"correlation_id": "1", "original_url": "https://long1.example.com/"}` + "\n" +
+			`{"correlation_id": "2", "original_url": "https://long2.example.com/` + strings.Repeat("a", 70*1024) + `"}`
+		resp, lines := send(t, strings.NewReader(body), nil)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		if assert.Len(t, lines, 2) {
+			assert.Equal(t, model.BatchStatusCreated, lines[0]["status"])
+			assert.Contains(t, lines[1]["error"], "line 2 is longer")
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		resp, _ := send(t, strings.NewReader("\n\n"), nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("invalid content type", func(t *testing.T) {
+		resp, _ := send(t, strings.NewReader(`{}`), map[string]string{"Content-Type": "application/json"})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// Тесты для запрещённых ссылок
+func TestRejectedURLHandlers(t *testing.T) {
+	mux, _ := setupTest()
+
+	send := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("text endpoint", func(t *testing.T) {
+		w := send("/", "text/plain", "javascript:alert(1)")
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), "url is not allowed")
+	})
+
+	t.Run("json endpoint", func(t *testing.T) {
+		w := send("/api/shorten", "application/json", `{"url": "http://127.0.0.1:8080/admin"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		var response map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Contains(t, response["error"], "url is not allowed")
+	})
+
+	t.Run("batch endpoint", func(t *testing.T) {
+		w := send("/api/shorten/batch", "application/json", `[
+			{"correlation_id": "1", "original_url": "https://fine.com"},
+			{"correlation_id": "2", "original_url": "http://localhost:8080/loop"}
+		]`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		var response map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Contains(t, response["error"], "url is not allowed")
+	})
+}
@@ -0,0 +1,124 @@
+// Package keygen содержит стратегии генерации коротких ключей
+package keygen
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidAlphabet ошибка, которая возникает при недопустимом алфавите ключей
+var ErrInvalidAlphabet = errors.New("invalid key alphabet")
+
+// ErrInvalidLength ошибка, которая возникает при недопустимой длине ключа
+var ErrInvalidLength = errors.New("invalid key length")
+
+// Generator выдаёт кандидатов в короткие ключи
+// Уникальность не гарантируется: занятый ключ отклоняется хранилищем при вставке, и запрашивается следующий
+type Generator interface {
+	Next(ctx context.Context) (string, error)
+}
+
+// ValidateAlphabet проверяет, что алфавит состоит хотя бы из двух различных ASCII-символов
+func ValidateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return fmt.Errorf("%w: at least 2 characters required", ErrInvalidAlphabet)
+	}
+
+	seen := make(map[byte]struct{}, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c <= ' ' || c > '~' {
+			return fmt.Errorf("%w: only printable ASCII characters allowed", ErrInvalidAlphabet)
+		}
+		if _, ok := seen[c]; ok {
+			return fmt.Errorf("%w: duplicate character %q", ErrInvalidAlphabet, c)
+		}
+		seen[c] = struct{}{}
+	}
+	return nil
+}
+
+// Random генерирует случайные ключи фиксированной длины из алфавита
+type Random struct {
+	length   int
+	alphabet string
+}
+
+// NewRandom создаёт генератор случайных ключей
+func NewRandom(length int, alphabet string) (*Random, error) {
+	if length < 1 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, length)
+	}
+	if err := ValidateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	return &Random{length: length, alphabet: alphabet}, nil
+}
+
+// Next возвращает случайный ключ
+// Байты, не делящиеся нацело на размер алфавита, отбрасываются, чтобы символы выпадали равновероятно
+func (g *Random) Next(_ context.Context) (string, error) {
+	size := len(g.alphabet)
+	limit := 256 - 256%size
+
+	key := make([]byte, 0, g.length)
+	buf := make([]byte, g.length*2)
+	for len(key) < g.length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			key = append(key, g.alphabet[int(b)%size])
+			if len(key) == g.length {
+				break
+			}
+		}
+	}
+	return string(key), nil
+}
+
+// Sequence кодирует значения монотонного счётчика в позиционную систему по алфавиту
+// Ключи получаются короткими и не повторяются, но предсказуемы
+type Sequence struct {
+	next     func(ctx context.Context) (int64, error)
+	alphabet string
+}
+
+// NewSequence создаёт генератор на основе счётчика next
+func NewSequence(next func(ctx context.Context) (int64, error), alphabet string) (*Sequence, error) {
+	if err := ValidateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	return &Sequence{next: next, alphabet: alphabet}, nil
+}
+
+// Next возвращает ключ для следующего значения счётчика
+func (g *Sequence) Next(ctx context.Context) (string, error) {
+	value, err := g.next(ctx)
+	if err != nil {
+		return "", err
+	}
+	return Encode(value, g.alphabet), nil
+}
+
+// Encode записывает неотрицательное число в системе счисления по алфавиту
+func Encode(value int64, alphabet string) string {
+	if value <= 0 {
+		return alphabet[:1]
+	}
+
+	base := int64(len(alphabet))
+	var buf [64]byte
+	i := len(buf)
+	for value > 0 {
+		i--
+		buf[i] = alphabet[value%base]
+		value /= base
+	}
+	return string(buf[i:])
+}
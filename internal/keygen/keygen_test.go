@@ -0,0 +1,104 @@
+package keygen
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAlphabet(t *testing.T) {
+	assert.NoError(t, ValidateAlphabet("ab"))
+
+	for _, alphabet := range []string{"", "a", "aba", "ab c", "abв"} {
+		assert.ErrorIs(t, ValidateAlphabet(alphabet), ErrInvalidAlphabet, alphabet)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	t.Run("length and alphabet", func(t *testing.T) {
+		generator, err := NewRandom(8, "xyz")
+		assert.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			key, err := generator.Next(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, key, 8)
+			assert.Empty(t, strings.Trim(key, "xyz"))
+		}
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		_, err := NewRandom(0, "abc")
+		assert.ErrorIs(t, err, ErrInvalidLength)
+
+		_, err = NewRandom(6, "a")
+		assert.ErrorIs(t, err, ErrInvalidAlphabet)
+	})
+}
+
+func TestSequence(t *testing.T) {
+	t.Run("encode", func(t *testing.T) {
+		assert.Equal(t, "0", Encode(0, "0123456789"))
+		assert.Equal(t, "42", Encode(42, "0123456789"))
+		assert.Equal(t, "101", Encode(5, "01"))
+		assert.Equal(t, "ba", Encode(2, "ab"))
+	})
+
+	t.Run("counter values are encoded", func(t *testing.T) {
+		var counter int64
+		generator, err := NewSequence(func(context.Context) (int64, error) {
+			return atomic.AddInt64(&counter, 1), nil
+		}, "0123456789abcdef")
+		assert.NoError(t, err)
+
+		for _, expected := range []string{"1", "2", "3"} {
+			key, err := generator.Next(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, expected, key)
+		}
+	})
+
+	t.Run("counter error", func(t *testing.T) {
+		failure := errors.New("counter failed")
+		generator, err := NewSequence(func(context.Context) (int64, error) {
+			return 0, failure
+		}, "ab")
+		assert.NoError(t, err)
+
+		_, err = generator.Next(context.Background())
+		assert.ErrorIs(t, err, failure)
+	})
+}
+
+func TestPool(t *testing.T) {
+	var counter int64
+	source, err := NewSequence(func(context.Context) (int64, error) {
+		return atomic.AddInt64(&counter, 1), nil
+	}, "0123456789")
+	assert.NoError(t, err)
+
+	pool := NewPool(source, 5)
+
+	// Пул заполняется в фоне
+	assert.Eventually(t, func() bool { return len(pool.keys) == 5 }, time.Second, time.Millisecond)
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 20; i++ {
+		key, err := pool.Next(context.Background())
+		assert.NoError(t, err)
+		seen[key] = struct{}{}
+	}
+	assert.Len(t, seen, 20)
+
+	assert.NoError(t, pool.Close())
+	assert.NoError(t, pool.Close())
+
+	// После остановки ключи выдаются напрямую источником
+	_, err = pool.Next(context.Background())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,83 @@
+package keygen
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// poolRetryDelay пауза перед повторным пополнением пула после ошибки источника
+const poolRetryDelay = time.Second
+
+// Pool заранее получает ключи от источника в фоне и отдаёт их без обращения к нему
+// Если пул опустел, ключ запрашивается у источника напрямую
+type Pool struct {
+	source Generator
+	keys   chan string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewPool создаёт пул ёмкостью size и запускает его пополнение
+func NewPool(source Generator, size int) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &Pool{
+		source: source,
+		keys:   make(chan string, size),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go pool.fill(ctx)
+	return pool
+}
+
+// fill пополняет пул, пока он не остановлен
+func (p *Pool) fill(ctx context.Context) {
+	defer close(p.done)
+
+	for {
+		key, err := p.source.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Ошибка пополнения пула ключей: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(poolRetryDelay):
+			}
+			continue
+		}
+
+		select {
+		case p.keys <- key:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Next возвращает ключ из пула или от источника, если пул пуст
+func (p *Pool) Next(ctx context.Context) (string, error) {
+	select {
+	case key := <-p.keys:
+		return key, nil
+	default:
+		return p.source.Next(ctx)
+	}
+}
+
+// Close останавливает пополнение пула и дожидается его завершения
+func (p *Pool) Close() error {
+	p.once.Do(func() {
+		p.cancel()
+		<-p.done
+	})
+	return nil
+}
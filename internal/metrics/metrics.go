@@ -0,0 +1,128 @@
+// Package metrics содержит метрики сервиса в формате Prometheus
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace общий префикс метрик сервиса
+const namespace = "shortener"
+
+// Виды создания ссылок для метрики созданных ссылок
+const (
+	KindSingle = "single"
+	KindBatch  = "batch"
+)
+
+// Registry реестр метрик сервиса, отдаётся обработчиком Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Short URL lookups for redirect by result (hit or miss).",
+	}, []string{"result"})
+
+	linksCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "Number of created short URLs by kind (single or batch).",
+	}, []string{"kind"})
+
+	repositoryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_operation_duration_seconds",
+		Help:      "Repository operation latency by backend and operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"backend", "operation"})
+
+	repositoryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repository_errors_total",
+		Help:      "Repository operation errors by backend and operation.",
+	}, []string{"backend", "operation"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Link cache lookups by result (hit or miss).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		redirects,
+		linksCreated,
+		repositoryDuration,
+		repositoryErrors,
+		cacheLookups,
+	)
+}
+
+// Handler возвращает обработчик, отдающий метрики в текстовом формате Prometheus
+// Сжатие отключено, ответы сжимает GzipMiddleware
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{DisableCompression: true})
+}
+
+// ObserveHTTPRequest учитывает обработанный HTTP запрос
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(method, route, code).Inc()
+	httpDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+// ObserveRedirect учитывает поиск ссылки для редиректа
+func ObserveRedirect(hit bool) {
+	redirects.WithLabelValues(hitLabel(hit)).Inc()
+}
+
+// ObserveCacheLookup учитывает обращение к кешу ссылок
+func ObserveCacheLookup(hit bool) {
+	cacheLookups.WithLabelValues(hitLabel(hit)).Inc()
+}
+
+// hitLabel возвращает значение метки результата поиска
+func hitLabel(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// AddLinksCreated учитывает созданные короткие ссылки
+func AddLinksCreated(kind string, count int) {
+	linksCreated.WithLabelValues(kind).Add(float64(count))
+}
+
+// ObserveRepositoryOperation учитывает операцию хранилища и её неуспешное завершение
+func ObserveRepositoryOperation(backend, operation string, duration time.Duration, failed bool) {
+	repositoryDuration.WithLabelValues(backend, operation).Observe(duration.Seconds())
+	if failed {
+		repositoryErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveHTTPRequest(t *testing.T) {
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/:id", "307"))
+
+	ObserveHTTPRequest("GET", "/:id", http.StatusTemporaryRedirect, 10*time.Millisecond)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/:id", "307")))
+}
+
+func TestObserveRedirect(t *testing.T) {
+	hits := testutil.ToFloat64(redirects.WithLabelValues("hit"))
+	misses := testutil.ToFloat64(redirects.WithLabelValues("miss"))
+
+	ObserveRedirect(true)
+	ObserveRedirect(false)
+	ObserveRedirect(false)
+
+	assert.Equal(t, hits+1, testutil.ToFloat64(redirects.WithLabelValues("hit")))
+	assert.Equal(t, misses+2, testutil.ToFloat64(redirects.WithLabelValues("miss")))
+}
+
+func TestAddLinksCreated(t *testing.T) {
+	single := testutil.ToFloat64(linksCreated.WithLabelValues(KindSingle))
+	batch := testutil.ToFloat64(linksCreated.WithLabelValues(KindBatch))
+
+	AddLinksCreated(KindSingle, 1)
+	AddLinksCreated(KindBatch, 3)
+
+	assert.Equal(t, single+1, testutil.ToFloat64(linksCreated.WithLabelValues(KindSingle)))
+	assert.Equal(t, batch+3, testutil.ToFloat64(linksCreated.WithLabelValues(KindBatch)))
+}
+
+func TestObserveRepositoryOperation(t *testing.T) {
+	errorsBefore := testutil.ToFloat64(repositoryErrors.WithLabelValues("memory", "set_value"))
+
+	ObserveRepositoryOperation("memory", "set_value", time.Millisecond, false)
+	ObserveRepositoryOperation("memory", "set_value", time.Millisecond, true)
+
+	assert.Equal(t, errorsBefore+1, testutil.ToFloat64(repositoryErrors.WithLabelValues("memory", "set_value")))
+}
+
+func TestHandler(t *testing.T) {
+	ObserveRedirect(true)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), `shortener_redirects_total{result="hit"}`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
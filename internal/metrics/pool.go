@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector снимает статистику пула соединений PostgreSQL при каждом сборе метрик
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquiredConns     *prometheus.Desc
+	idleConns         *prometheus.Desc
+	constructingConns *prometheus.Desc
+	totalConns        *prometheus.Desc
+	maxConns          *prometheus.Desc
+	acquireCount      *prometheus.Desc
+	acquireDuration   *prometheus.Desc
+	emptyAcquireCount *prometheus.Desc
+	canceledAcquires  *prometheus.Desc
+	newConnsCount     *prometheus.Desc
+}
+
+// RegisterPool регистрирует метрики пула соединений PostgreSQL
+func RegisterPool(pool *pgxpool.Pool) error {
+	return Registry.Register(newPoolCollector(pool))
+}
+
+// newPoolCollector создаёт коллектор статистики пула
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", name), help, nil, nil)
+	}
+
+	return &poolCollector{
+		pool:              pool,
+		acquiredConns:     desc("acquired_conns", "Number of currently acquired connections."),
+		idleConns:         desc("idle_conns", "Number of currently idle connections."),
+		constructingConns: desc("constructing_conns", "Number of connections being established."),
+		totalConns:        desc("total_conns", "Total number of connections in the pool."),
+		maxConns:          desc("max_conns", "Maximum size of the pool."),
+		acquireCount:      desc("acquire_total", "Cumulative count of successful acquires."),
+		acquireDuration:   desc("acquire_duration_seconds_total", "Total time spent acquiring connections."),
+		emptyAcquireCount: desc("empty_acquire_total", "Cumulative count of acquires that waited for a connection."),
+		canceledAcquires:  desc("canceled_acquire_total", "Cumulative count of acquires canceled by context."),
+		newConnsCount:     desc("new_conns_total", "Cumulative count of new connections opened."),
+	}
+}
+
+// Describe реализует prometheus.Collector
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+// Collect реализует prometheus.Collector
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+
+	gauge(c.acquiredConns, float64(stat.AcquiredConns()))
+	gauge(c.idleConns, float64(stat.IdleConns()))
+	gauge(c.constructingConns, float64(stat.ConstructingConns()))
+	gauge(c.totalConns, float64(stat.TotalConns()))
+	gauge(c.maxConns, float64(stat.MaxConns()))
+	counter(c.acquireCount, float64(stat.AcquireCount()))
+	counter(c.acquireDuration, stat.AcquireDuration().Seconds())
+	counter(c.emptyAcquireCount, float64(stat.EmptyAcquireCount()))
+	counter(c.canceledAcquires, float64(stat.CanceledAcquireCount()))
+	counter(c.newConnsCount, float64(stat.NewConnsCount()))
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"compress/gzip"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,6 +23,17 @@ func (g *gzipWriter) WriteString(s string) (int, error) {
 	return g.writer.Write([]byte(s))
 }
 
+// Flush отправляет клиенту уже сжатые данные, нужен для потоковых ответов
+func (g *gzipWriter) Flush() {
+	g.writer.Flush()
+	g.ResponseWriter.Flush()
+}
+
+// Unwrap возвращает исходный ResponseWriter для http.ResponseController
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
 // Close закрывает gzip writer
 func (g *gzipWriter) Close() {
 	g.writer.Close()
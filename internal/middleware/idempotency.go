@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// Заголовки идемпотентных запросов
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeyLength максимальная длина ключа идемпотентности
+const maxIdempotencyKeyLength = 255
+
+// idempotencyStoreTimeout ограничение времени сохранения ответа после обработки запроса
+const idempotencyStoreTimeout = 5 * time.Second
+
+// IdempotencyStore хранилище ключей идемпотентности, его реализуют все репозитории
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, userID, key string) error
+}
+
+// IdempotencyMiddleware повторяет сохранённый ответ на запрос с тем же заголовком Idempotency-Key
+// Ключ действует для одного пользователя в течение ttl. Тот же ключ с другим запросом отклоняется с 422,
+// а пока первый запрос обрабатывается, повтор получает 409
+// Пользователь определяется по куке: запрос без куки получает нового пользователя, поэтому повторять его нужно
+// с кукой из первого ответа. Повтор без куки выполняется заново, как запрос другого пользователя
+// Ответы с ошибкой сервера не сохраняются, чтобы запрос можно было повторить
+// Должен стоять после AuthMiddleware. При ошибке хранилища запрос выполняется без идемпотентности
+func IdempotencyMiddleware(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if !ValidIdempotencyKey(key) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid Idempotency-Key header"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := model.IdempotencyRecord{
+			Key:         key,
+			UserID:      c.GetString(UserIDKey),
+			RequestHash: requestHash(c.Request, body),
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+
+		existing, err := store.ReserveIdempotencyKey(c.Request.Context(), record)
+		switch {
+		case errors.Is(err, repository.ErrIdempotencyKeyExists):
+			replay(c, record, existing)
+			return
+		case err != nil:
+			log.Printf("Ошибка резервирования ключа идемпотентности: %v", err)
+			c.Next()
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		// Ответ сохраняем и при отменённом запросе: обработчик мог уже создать ссылки
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), idempotencyStoreTimeout)
+		defer cancel()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if err := store.ReleaseIdempotencyKey(ctx, record.UserID, record.Key); err != nil {
+				log.Printf("Ошибка освобождения ключа идемпотентности: %v", err)
+			}
+			return
+		}
+
+		record.StatusCode = status
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		if err := store.CompleteIdempotencyKey(ctx, record); err != nil {
+			log.Printf("Ошибка сохранения ответа для ключа идемпотентности: %v", err)
+		}
+	}
+}
+
+// replay отвечает на повтор запроса с уже использованным ключом
+func replay(c *gin.Context, record, existing model.IdempotencyRecord) {
+	switch {
+	case existing.RequestHash != record.RequestHash:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was used with a different request"})
+	case !existing.Completed():
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Request with this Idempotency-Key is in progress"})
+	default:
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+		c.Abort()
+	}
+}
+
+// ValidIdempotencyKey проверяет длину ключа и что он состоит из видимых ASCII-символов
+func ValidIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '!' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHash считает хеш метода, пути, параметров и тела запроса
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.URL.RawQuery} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordingWriter копирует тело ответа, чтобы сохранить его для повторов
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write записывает данные клиенту и в копию ответа
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString записывает строку клиенту и в копию ответа
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -4,16 +4,22 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
 	"github.com/gin-gonic/gin"
 )
 
 // UserIDKey является ключом для хранения ID пользователя в контексте
 const UserIDKey = "userID"
 
+// NDJSONContentType тип содержимого потокового импорта: по одному JSON-объекту в строке
+const NDJSONContentType = "application/x-ndjson"
+
 // Middleware для логирования запросов
 func LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -34,6 +40,28 @@ func LoggingMiddleware() gin.HandlerFunc {
 	}
 }
 
+// unmatchedRoute метка маршрута для запросов, не совпавших ни с одним маршрутом
+const unmatchedRoute = "unmatched"
+
+// MetricsMiddleware учитывает количество и длительность запросов по шаблону маршрута и статусу
+// Шаблон вместо пути не даёт меткам разрастаться на каждую короткую ссылку
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		// Учитываем запрос и при панике в следующих обработчиках
+		defer func() {
+			route := c.FullPath()
+			if route == "" {
+				route = unmatchedRoute
+			}
+			metrics.ObserveHTTPRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+		}()
+
+		c.Next()
+	}
+}
+
 // GzipMiddleware middleware для обработки gzip сжатия
 func GzipMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -46,25 +74,32 @@ func GzipMiddleware() gin.HandlerFunc {
 			}
 			defer reader.Close()
 
-			// Заменяем тело запроса на распакованное
-			body, err := io.ReadAll(reader)
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Error reading gzip data"})
-				return
-			}
+			// Потоковый импорт распаковывается по мере чтения, чтобы не держать всё тело в памяти
+			if strings.HasPrefix(strings.ToLower(c.GetHeader("Content-Type")), NDJSONContentType) {
+				c.Request.Body = reader
+				c.Request.ContentLength = -1
+				c.Request.Header.Del("Content-Encoding")
+			} else {
+				// Заменяем тело запроса на распакованное
+				body, err := io.ReadAll(reader)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Error reading gzip data"})
+					return
+				}
 
-			c.Request.Body = io.NopCloser(bytes.NewReader(body))
-			c.Request.ContentLength = int64(len(body))
-			c.Request.Header.Del("Content-Encoding")
-
-			// Восстанавливаем правильный Content-Type для разных случаев
-			contentType := c.GetHeader("Content-Type")
-			if contentType == "application/x-gzip" {
-				// Определяем тип содержимого по тому, что делаем
-				if strings.Contains(c.Request.URL.Path, "/api/") {
-					c.Request.Header.Set("Content-Type", "application/json")
-				} else {
-					c.Request.Header.Set("Content-Type", "text/plain")
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				c.Request.ContentLength = int64(len(body))
+				c.Request.Header.Del("Content-Encoding")
+
+				// Восстанавливаем правильный Content-Type для разных случаев
+				contentType := c.GetHeader("Content-Type")
+				if contentType == "application/x-gzip" {
+					// Определяем тип содержимого по тому, что делаем
+					if strings.Contains(c.Request.URL.Path, "/api/") {
+						c.Request.Header.Set("Content-Type", "application/json")
+					} else {
+						c.Request.Header.Set("Content-Type", "text/plain")
+					}
 				}
 			}
 		}
@@ -144,4 +179,32 @@ func RequireAuthMiddleware(authService AuthServiceInterface) gin.HandlerFunc {
 		
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// TrustedSubnetMiddleware пропускает только запросы, у которых X-Real-IP входит в доверенную подсеть
+// Пустая или некорректная подсеть запрещает доступ всем
+func TrustedSubnetMiddleware(cidr string) gin.HandlerFunc {
+	var subnet *net.IPNet
+	if cidr != "" {
+		if _, parsed, err := net.ParseCIDR(cidr); err == nil {
+			subnet = parsed
+		} else {
+			log.Printf("invalid trusted subnet %s: %v", cidr, err)
+		}
+	}
+
+	return func(c *gin.Context) {
+		if subnet == nil {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		ip := net.ParseIP(strings.TrimSpace(c.GetHeader("X-Real-IP")))
+		if ip == nil || !subnet.Contains(ip) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
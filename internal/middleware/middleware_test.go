@@ -3,11 +3,20 @@ package middleware
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/ratelimit"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -119,3 +128,259 @@ func TestLoggingMiddleware(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "OK", w.Body.String())
 }
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		subnet   string
+		realIP   string
+		expected int
+	}{
+		{"ip inside subnet", "192.168.1.0/24", "192.168.1.15", http.StatusOK},
+		{"ip outside subnet", "192.168.1.0/24", "10.0.0.1", http.StatusForbidden},
+		{"missing header", "192.168.1.0/24", "", http.StatusForbidden},
+		{"empty subnet", "", "192.168.1.15", http.StatusForbidden},
+		{"invalid subnet", "not-a-cidr", "192.168.1.15", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/stats", TrustedSubnetMiddleware(tt.subnet), func(c *gin.Context) {
+				c.String(http.StatusOK, "OK")
+			})
+
+			req := httptest.NewRequest("GET", "/stats", nil)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(MetricsMiddleware())
+	router.GET("/items/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, path := range []string{"/items/1", "/items/2", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	// Запросы группируются по шаблону маршрута, а не по пути
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/items/:id",status="200"} 2`)
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.False(t, strings.Contains(body, `route="/items/1"`))
+}
+
+// failingStore хранилище корзин, которое всегда возвращает ошибку
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store is down")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(store ratelimit.Store) *gin.Engine {
+		limiter := ratelimit.NewLimiter(store, map[string]ratelimit.Limit{
+			ratelimit.ScopeCreate: ratelimit.PerPeriod(2, time.Minute),
+		})
+
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			if userID := c.GetHeader("X-User"); userID != "" {
+				c.Set(UserIDKey, userID)
+			}
+			c.Next()
+		})
+		router.POST("/", RateLimitMiddleware(limiter, ratelimit.ScopeCreate), func(c *gin.Context) {
+			c.String(http.StatusCreated, "ok")
+		})
+		return router
+	}
+
+	send := func(router *gin.Engine, ip, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		if userID != "" {
+			req.Header.Set("X-User", userID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("limits by ip", func(t *testing.T) {
+		router := newRouter(ratelimit.NewMemoryStore())
+
+		w := send(router, "10.0.0.1", "")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "30", w.Header().Get("X-RateLimit-Reset"))
+		assert.Empty(t, w.Header().Get("Retry-After"))
+
+		assert.Equal(t, http.StatusCreated, send(router, "10.0.0.1", "").Code)
+
+		w = send(router, "10.0.0.1", "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		assert.JSONEq(t, `{"error":"rate limit exceeded"}`, w.Body.String())
+
+		// Другой адрес ограничивается отдельно
+		assert.Equal(t, http.StatusCreated, send(router, "10.0.0.2", "").Code)
+	})
+
+	t.Run("limits by user across ips", func(t *testing.T) {
+		router := newRouter(ratelimit.NewMemoryStore())
+
+		assert.Equal(t, http.StatusCreated, send(router, "10.0.0.1", "alice").Code)
+		assert.Equal(t, http.StatusCreated, send(router, "10.0.0.2", "alice").Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, "10.0.0.3", "alice").Code)
+		assert.Equal(t, http.StatusCreated, send(router, "10.0.0.3", "bob").Code)
+	})
+
+	t.Run("store failure lets requests through", func(t *testing.T) {
+		router := newRouter(failingStore{})
+
+		for i := 0; i < 3; i++ {
+			w := send(router, "10.0.0.1", "")
+			assert.Equal(t, http.StatusCreated, w.Code)
+			assert.Empty(t, w.Header().Get("X-RateLimit-Limit"))
+		}
+	})
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(store IdempotencyStore) (*gin.Engine, *atomic.Int32) {
+		var calls atomic.Int32
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set(UserIDKey, c.GetHeader("X-User"))
+			c.Next()
+		})
+		router.POST("/", IdempotencyMiddleware(store, time.Hour), func(c *gin.Context) {
+			n := calls.Add(1)
+			body, _ := io.ReadAll(c.Request.Body)
+			if string(body) == "fail" {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed"})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{"result": string(body), "call": n})
+		})
+		return router, &calls
+	}
+
+	send := func(router *gin.Engine, key, userID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req.Header.Set("X-User", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("retry replays stored response", func(t *testing.T) {
+		router, calls := newRouter(repository.NewMemoryRepository())
+
+		first := send(router, "retry", "alice", "https://a.com")
+		assert.Equal(t, http.StatusCreated, first.Code)
+		assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+		second := send(router, "retry", "alice", "https://a.com")
+		assert.Equal(t, http.StatusCreated, second.Code)
+		assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, first.Header().Get("Content-Type"), second.Header().Get("Content-Type"))
+		assert.Equal(t, int32(1), calls.Load())
+
+		// Ключ другого пользователя и запросы без ключа обрабатываются заново
+		assert.Empty(t, send(router, "retry", "bob", "https://a.com").Header().Get(IdempotentReplayedHeader))
+		send(router, "", "alice", "https://a.com")
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("same key with different body", func(t *testing.T) {
+		router, calls := newRouter(repository.NewMemoryRepository())
+
+		assert.Equal(t, http.StatusCreated, send(router, "reuse", "alice", "https://a.com").Code)
+		w := send(router, "reuse", "alice", "https://b.com")
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("request in progress", func(t *testing.T) {
+		store := repository.NewMemoryRepository()
+		router, calls := newRouter(store)
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		_, err := store.ReserveIdempotencyKey(context.Background(), model.IdempotencyRecord{
+			Key: "busy", UserID: "alice", RequestHash: requestHash(req, []byte("https://a.com")), ExpiresAt: time.Now().Add(time.Hour),
+		})
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusConflict, send(router, "busy", "alice", "https://a.com").Code)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("server error releases key", func(t *testing.T) {
+		router, calls := newRouter(repository.NewMemoryRepository())
+
+		assert.Equal(t, http.StatusInternalServerError, send(router, "flaky", "alice", "fail").Code)
+		assert.Equal(t, http.StatusInternalServerError, send(router, "flaky", "alice", "fail").Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		router, calls := newRouter(repository.NewMemoryRepository())
+
+		assert.Equal(t, http.StatusBadRequest, send(router, "with space", "alice", "https://a.com").Code)
+		assert.Equal(t, http.StatusBadRequest, send(router, strings.Repeat("k", 256), "alice", "https://a.com").Code)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("store failure lets requests through", func(t *testing.T) {
+		router, calls := newRouter(failingIdempotencyStore{})
+
+		assert.Equal(t, http.StatusCreated, send(router, "down", "alice", "https://a.com").Code)
+		assert.Equal(t, http.StatusCreated, send(router, "down", "alice", "https://a.com").Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+}
+
+// failingIdempotencyStore хранилище ключей идемпотентности, которое всегда возвращает ошибку
+type failingIdempotencyStore struct{}
+
+func (failingIdempotencyStore) ReserveIdempotencyKey(context.Context, model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	return model.IdempotencyRecord{}, errors.New("store is down")
+}
+
+func (failingIdempotencyStore) CompleteIdempotencyKey(context.Context, model.IdempotencyRecord) error {
+	return errors.New("store is down")
+}
+
+func (failingIdempotencyStore) ReleaseIdempotencyKey(context.Context, string, string) error {
+	return errors.New("store is down")
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware ограничивает частоту запросов области scope отдельно для пользователя и для IP-адреса
+// Должен стоять после AuthMiddleware, иначе учитывается только IP-адрес
+// При ошибке хранилища корзин запрос пропускается, чтобы сбой лимитов не останавливал сервис
+func RateLimitMiddleware(limiter *ratelimit.Limiter, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := []string{"ip:" + c.ClientIP()}
+		if userID := c.GetString(UserIDKey); userID != "" {
+			keys = append(keys, "user:"+userID)
+		}
+
+		res, err := limiter.Allow(c.Request.Context(), scope, keys...)
+		if err != nil {
+			log.Printf("Ошибка ограничения частоты запросов %s: %v", scope, err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ceilSeconds округляет длительность вверх до целых секунд
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
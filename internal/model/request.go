@@ -1,8 +1,17 @@
 package model
 
+import (
+	"net/http"
+	"time"
+)
+
 // Model Request
 type Request struct {
-	URL string `json:"url" validate:"required,url"`
+	URL       string     `json:"url" validate:"required,url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresIn int64      `json:"expires_in,omitempty"` // срок жизни ссылки в секундах
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RedirectOptions
 }
 
 // Model Response
@@ -12,26 +21,259 @@ type Response struct {
 
 // Model for URL storage in JSON format
 type URLRecord struct {
-	ID          int    `json:"id"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
+	ID           int        `json:"id"`
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	CanonicalURL string     `json:"canonical_url,omitempty"`
+	UserID       string     `json:"user_id"`
+	IsDeleted    bool       `json:"is_deleted,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	RedirectOptions
 }
 
 // Model for batch request
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id" validate:"required"`
-	OriginalURL   string `json:"original_url" validate:"required,url"`
+	CorrelationID string     `json:"correlation_id" validate:"required"`
+	OriginalURL   string     `json:"original_url" validate:"required,url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresIn     int64      `json:"expires_in,omitempty"` // срок жизни ссылки в секундах
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RedirectOptions
 }
 
 // Model for batch response
 type BatchResponse struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	Status        string `json:"status"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Статусы элемента пакетного создания ссылок
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusExists   = "exists"
+	BatchStatusInvalid  = "invalid"
+	BatchStatusConflict = "conflict"
+)
+
+// BatchMode режим пакетного создания ссылок
+type BatchMode string
+
+// Режимы пакетного создания ссылок: весь пакет или ничего, либо сохранение корректных элементов
+const (
+	BatchModeAtomic     BatchMode = "atomic"
+	BatchModeBestEffort BatchMode = "best_effort"
+)
+
+// Valid сообщает, известен ли режим
+func (m BatchMode) Valid() bool {
+	return m == BatchModeAtomic || m == BatchModeBestEffort
+}
+
+// StreamResponse результат строки потокового сокращения ссылок, line — номер строки запроса
+type StreamResponse struct {
+	Line int `json:"line"`
+	BatchResponse
+}
+
+// BatchItemResult результат создания ссылки для элемента пакета
+type BatchItemResult struct {
+	Status   string
+	ShortURL string
+	Err      error
 }
 
 // UserURL представляет URL пользователя в ответе
 type UserURL struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ClickCount  int        `json:"click_count,omitempty"`
+}
+
+// Stats статистика сервиса для внутреннего эндпоинта
+type Stats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// MaxExpiresIn наибольший относительный срок жизни ссылки
+const MaxExpiresIn = 100 * 365 * 24 * time.Hour
+
+// LinkOptions параметры создаваемой короткой ссылки
+type LinkOptions struct {
+	Alias     string
+	ExpiresIn time.Duration
+	ExpiresAt *time.Time
+	RedirectOptions
+}
+
+// RedirectOptions параметры перенаправления, которые выбираются при создании ссылки
+type RedirectOptions struct {
+	// RedirectCode код перенаправления, 0 — код по умолчанию из конфигурации
+	RedirectCode int `json:"redirect_code,omitempty"`
+	// QueryMerge способ передачи параметров запроса перехода в оригинальный URL, пустая строка — не передавать
+	QueryMerge string `json:"query_merge,omitempty"`
+	// PathPassthrough передаёт путь после короткого ключа: /abc123/docs/x -> <оригинальный URL>/docs/x
+	PathPassthrough bool `json:"path_passthrough,omitempty"`
+}
+
+// Способы объединения параметров запроса перехода с параметрами оригинального URL
+const (
+	QueryMergeKeep     = "keep"     // при совпадении имён остаются параметры ссылки
+	QueryMergeOverride = "override" // при совпадении имён параметры перехода заменяют параметры ссылки
+	QueryMergeAppend   = "append"   // значения из перехода добавляются к значениям ссылки
+)
+
+// ValidQueryMerge сообщает, можно ли выбрать способ объединения параметров для ссылки
+func ValidQueryMerge(mode string) bool {
+	switch mode {
+	case "", QueryMergeKeep, QueryMergeOverride, QueryMergeAppend:
+		return true
+	}
+	return false
+}
+
+// ValidRedirectCode сообщает, можно ли выбрать код для перенаправления по ссылке
+func ValidRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// PermanentRedirect сообщает, является ли перенаправление постоянным
+// Постоянные перенаправления браузеры и CDN могут кешировать
+func PermanentRedirect(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
+}
+
+// RedirectRequest части адреса перехода, которые можно передать в оригинальный URL
+type RedirectRequest struct {
+	// Path экранированный путь после короткого ключа без начального слеша: /abc123/docs/x -> docs/x
+	Path string
+	// Query строка параметров запроса перехода без "?"
+	Query string
+}
+
+// Redirect перенаправление по короткой ссылке
+type Redirect struct {
+	URL        string
+	StatusCode int
+	// CacheFor сколько браузеры и CDN могут кешировать перенаправление, 0 запрещает кеширование
+	CacheFor time.Duration
+}
+
+// ShortenItem элемент пакетного создания коротких ссылок
+type ShortenItem struct {
+	OriginalURL string
+	Options     LinkOptions
+}
+
+// Options возвращает параметры ссылки из запроса
+func (r Request) Options() LinkOptions {
+	return LinkOptions{
+		Alias:           r.Alias,
+		ExpiresIn:       expiresInDuration(r.ExpiresIn),
+		ExpiresAt:       r.ExpiresAt,
+		RedirectOptions: r.RedirectOptions,
+	}
+}
+
+// Options возвращает параметры ссылки из элемента пакета
+func (r BatchRequest) Options() LinkOptions {
+	return LinkOptions{
+		Alias:           r.Alias,
+		ExpiresIn:       expiresInDuration(r.ExpiresIn),
+		ExpiresAt:       r.ExpiresAt,
+		RedirectOptions: r.RedirectOptions,
+	}
+}
+
+// expiresInDuration переводит срок жизни из секунд без переполнения
+// Значения вне допустимого диапазона заменяются такими, которые отклонит проверка срока
+func expiresInDuration(seconds int64) time.Duration {
+	switch {
+	case seconds < 0:
+		return -time.Second
+	case seconds > int64(MaxExpiresIn/time.Second):
+		return MaxExpiresIn + time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ClickEvent событие перехода по короткой ссылке
+type ClickEvent struct {
+	ShortURL  string    `json:"short_url"`
+	Timestamp time.Time `json:"timestamp"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash"`
+}
+
+// ClickBucket количество переходов за интервал времени
+type ClickBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// ClickStats статистика переходов по ссылке
+type ClickStats struct {
+	TotalClicks    int           `json:"total_clicks"`
+	UniqueVisitors int           `json:"unique_visitors"`
+	Hourly         []ClickBucket `json:"hourly"`
+	Daily          []ClickBucket `json:"daily"`
+}
+
+// Статусы проверки готовности сервиса и его компонентов
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+
+	ComponentUp       = "up"
+	ComponentDegraded = "degraded"
+	ComponentDown     = "down"
+	ComponentDraining = "draining"
+)
+
+// ComponentHealth состояние компонента сервиса
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Readiness результат проверки готовности сервиса принимать запросы
+type Readiness struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// Ready сообщает, готов ли сервис принимать запросы
+func (r Readiness) Ready() bool {
+	return r.Status == StatusReady
+}
+
+// IdempotencyRecord сохранённый ответ на запрос с ключом идемпотентности
+// Нулевой StatusCode означает, что первый запрос с этим ключом ещё обрабатывается
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	UserID      string    `json:"user_id"`
+	RequestHash string    `json:"request_hash"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// LockedUntil до какого момента незавершённый запрос считается выполняющимся, после этого ключ можно занять заново
+	LockedUntil time.Time `json:"-"`
+}
+
+// Completed сообщает, сохранён ли уже ответ на запрос
+func (r IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
 }
@@ -2,7 +2,9 @@ package model
 
 import (
 	"encoding/json"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -31,6 +33,19 @@ func TestRequest(t *testing.T) {
 	})
 }
 
+func TestRequestOptions(t *testing.T) {
+	t.Run("expires_in is converted to duration", func(t *testing.T) {
+		assert.Equal(t, time.Hour, Request{ExpiresIn: 3600}.Options().ExpiresIn)
+		assert.Equal(t, MaxExpiresIn, BatchRequest{ExpiresIn: int64(MaxExpiresIn / time.Second)}.Options().ExpiresIn)
+	})
+
+	t.Run("out of range expires_in does not overflow", func(t *testing.T) {
+		assert.Greater(t, Request{ExpiresIn: math.MaxInt64}.Options().ExpiresIn, MaxExpiresIn)
+		assert.Greater(t, BatchRequest{ExpiresIn: int64(MaxExpiresIn/time.Second) + 1}.Options().ExpiresIn, MaxExpiresIn)
+		assert.Negative(t, Request{ExpiresIn: math.MinInt64}.Options().ExpiresIn)
+	})
+}
+
 func TestResponse(t *testing.T) {
 	t.Run("Response struct creation and JSON marshaling", func(t *testing.T) {
 		resp := Response{
@@ -137,6 +152,7 @@ func TestBatchResponse(t *testing.T) {
 	t.Run("BatchResponse struct creation and JSON marshaling", func(t *testing.T) {
 		resp := BatchResponse{
 			CorrelationID: "req-1",
+			Status:        BatchStatusCreated,
 			ShortURL:      "https://short.ly/abc123",
 		}
 
@@ -148,11 +164,24 @@ func TestBatchResponse(t *testing.T) {
 		assert.NoError(t, err)
 		expected := `{
 			"correlation_id": "req-1",
+			"status": "created",
 			"short_url": "https://short.ly/abc123"
 		}`
 		assert.JSONEq(t, expected, string(jsonData))
 	})
 
+	t.Run("Invalid item has no short URL", func(t *testing.T) {
+		jsonData, err := json.Marshal(BatchResponse{CorrelationID: "req-3", Status: BatchStatusInvalid, Error: "url is not allowed"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"correlation_id": "req-3", "status": "invalid", "error": "url is not allowed"}`, string(jsonData))
+	})
+
+	t.Run("BatchMode validation", func(t *testing.T) {
+		assert.True(t, BatchModeAtomic.Valid())
+		assert.True(t, BatchModeBestEffort.Valid())
+		assert.False(t, BatchMode("partial").Valid())
+	})
+
 	t.Run("BatchResponse struct JSON unmarshaling", func(t *testing.T) {
 		jsonStr := `{
 			"correlation_id": "req-2",
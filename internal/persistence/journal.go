@@ -0,0 +1,324 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+)
+
+// SyncPolicy определяет, когда записи журнала сбрасываются на диск
+type SyncPolicy string
+
+// Политики синхронизации журнала
+const (
+	// SyncAlways вызывает fsync после каждой записи
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval вызывает fsync в фоне не чаще заданного интервала
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever оставляет сброс на диск операционной системе
+	SyncNever SyncPolicy = "never"
+)
+
+// defaultSyncInterval интервал синхронизации, если он не задан
+const defaultSyncInterval = time.Second
+
+// Операции журнала
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+	OpPurge  = "purge"
+	// OpComplete сохраняет ответы на запросы с ключом идемпотентности
+	OpComplete = "complete"
+)
+
+// ErrInvalidSyncPolicy ошибка, которая возникает при неизвестной политике синхронизации
+var ErrInvalidSyncPolicy = errors.New("invalid sync policy")
+
+// ErrJournalClosed ошибка, которая возникает при записи в закрытый журнал
+var ErrJournalClosed = errors.New("journal is closed")
+
+// ValidateSyncPolicy проверяет название политики синхронизации
+func ValidateSyncPolicy(policy string) error {
+	switch SyncPolicy(policy) {
+	case SyncAlways, SyncInterval, SyncNever:
+		return nil
+	}
+	return fmt.Errorf("%w: %q, expected %s, %s or %s", ErrInvalidSyncPolicy, policy, SyncAlways, SyncInterval, SyncNever)
+}
+
+// JournalEntry одна запись журнала изменений
+type JournalEntry struct {
+	Op        string            `json:"op"`
+	Records   []model.URLRecord `json:"records,omitempty"`
+	ShortURLs []string          `json:"short_urls,omitempty"`
+	UserID    string            `json:"user_id,omitempty"`
+	At        *time.Time        `json:"at,omitempty"`
+
+	Idempotency []model.IdempotencyRecord `json:"idempotency,omitempty"`
+}
+
+// JournalOptions параметры журнала
+type JournalOptions struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+}
+
+// journalFile файл журнала, в тестах подменяется для имитации сбоев записи
+type journalFile interface {
+	io.Writer
+	Truncate(size int64) error
+	Seek(offset int64, whence int) (int64, error)
+	Sync() error
+	Close() error
+}
+
+// Journal журнал изменений в формате JSON-lines, в который записи только дописываются
+type Journal struct {
+	path    string
+	options JournalOptions
+
+	mu      sync.Mutex
+	file    journalFile
+	size    int64
+	entries int
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenJournal открывает журнал и возвращает сохранённые в нём записи
+// Недописанная последняя строка, оставшаяся после падения, отбрасывается
+func OpenJournal(path string, options JournalOptions) (*Journal, []JournalEntry, error) {
+	if options.Sync == "" {
+		options.Sync = SyncAlways
+	}
+	if err := ValidateSyncPolicy(string(options.Sync)); err != nil {
+		return nil, nil, err
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = defaultSyncInterval
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, size, err := replay(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	// Обрезаем оборванный хвост, чтобы новые записи начинались с новой строки
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	journal := &Journal{
+		path:    path,
+		options: options,
+		file:    file,
+		size:    size,
+		entries: len(entries),
+	}
+
+	if options.Sync == SyncInterval {
+		journal.stop = make(chan struct{})
+		journal.done = make(chan struct{})
+		go journal.syncLoop(journal.stop)
+	}
+
+	return journal, entries, nil
+}
+
+// replay читает записи журнала и возвращает размер корректной части файла
+// Повреждённые строки в середине пропускаются, оборванная последняя строка не учитывается
+func replay(file *os.File) ([]JournalEntry, int64, error) {
+	var entries []JournalEntry
+	var size int64
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Строка без перевода строки в конце файла не была дописана до конца
+			if len(line) > 0 {
+				log.Printf("Журнал %s: отброшена недописанная запись длиной %d байт", file.Name(), len(line))
+			}
+			return entries, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Printf("Журнал %s: пропущена повреждённая запись: %v", file.Name(), err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Append дописывает записи в журнал одной операцией записи
+func (j *Journal) Append(entries ...JournalEntry) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return ErrJournalClosed
+	}
+
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		// Убираем частично записанные данные, чтобы не оставить оборванную строку,
+		// и возвращаем позицию записи, иначе следующая запись оставит перед собой дыру из нулей
+		if truncateErr := j.file.Truncate(j.size); truncateErr != nil {
+			log.Printf("Журнал %s: не удалось откатить неполную запись: %v", j.path, truncateErr)
+		} else if _, seekErr := j.file.Seek(j.size, io.SeekStart); seekErr != nil {
+			log.Printf("Журнал %s: не удалось вернуть позицию записи: %v", j.path, seekErr)
+		}
+		return err
+	}
+	j.size += int64(buf.Len())
+	j.entries += len(entries)
+
+	if j.options.Sync == SyncAlways {
+		return j.file.Sync()
+	}
+	j.dirty = true
+	return nil
+}
+
+// Len возвращает количество записей в журнале
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.entries
+}
+
+// Reset очищает журнал после того, как его записи сохранены в снимок
+func (j *Journal) Reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return ErrJournalClosed
+	}
+
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.size = 0
+	j.entries = 0
+	j.dirty = false
+	return j.file.Sync()
+}
+
+// Sync сбрасывает записанные данные на диск
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.sync()
+}
+
+// sync вызывается под блокировкой
+func (j *Journal) sync() error {
+	if j.file == nil || !j.dirty {
+		return nil
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.dirty = false
+	return nil
+}
+
+// syncLoop периодически сбрасывает журнал на диск
+func (j *Journal) syncLoop(stop <-chan struct{}) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := j.Sync(); err != nil {
+				log.Printf("Журнал %s: ошибка синхронизации: %v", j.path, err)
+			}
+		}
+	}
+}
+
+// Close сбрасывает журнал на диск и закрывает файл, повторный вызов ничего не делает
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.file == nil {
+		j.mu.Unlock()
+		return nil
+	}
+	stop := j.stop
+	j.stop = nil
+	j.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-j.done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	// При штатном закрытии журнал сбрасывается на диск при любой политике
+	j.dirty = true
+	syncErr := j.sync()
+	closeErr := j.file.Close()
+	j.file = nil
+	return errors.Join(syncErr, closeErr)
+}
@@ -12,6 +12,8 @@ import (
 type JSONPersistence interface {
 	Save(filePath string, data map[string]string, userMap map[string]string) error
 	Load(filePath string) (map[string]string, map[string]string, int, error)
+	SaveRecords(filePath string, records []model.URLRecord) error
+	LoadRecords(filePath string) ([]model.URLRecord, error)
 }
 
 // Реализация для работы с JSON файлами
@@ -63,6 +65,16 @@ func (p *FileJSONPersistence) Load(filePath string) (map[string]string, map[stri
 	return data, userMap, maxID, nil
 }
 
+// Сохраняет готовые записи в JSON файл
+func (p *FileJSONPersistence) SaveRecords(filePath string, records []model.URLRecord) error {
+	return p.saveRecordsToFile(filePath, records)
+}
+
+// Загружает записи из JSON файла без преобразования в мапы
+func (p *FileJSONPersistence) LoadRecords(filePath string) ([]model.URLRecord, error) {
+	return p.loadRecordsFromFile(filePath)
+}
+
 // Сохраняет записи в файл
 func (p *FileJSONPersistence) saveRecordsToFile(filePath string, records []model.URLRecord) error {
 	// Если records nil, инициализируем пустым slice
@@ -81,7 +93,47 @@ func (p *FileJSONPersistence) saveRecordsToFile(filePath string, records []model
 		return err
 	}
 	
-	return os.WriteFile(filePath, data, 0644)
+	return WriteFileAtomic(filePath, data)
+}
+
+// WriteFileAtomic записывает файл через временный файл и переименование, чтобы при падении не остался наполовину записанный файл
+func WriteFileAtomic(filePath string, data []byte) error {
+	dir := filepath.Dir(filePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Синхронизируем каталог, чтобы переименование пережило сбой питания
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
 }
 
 // Загружает записи из файла
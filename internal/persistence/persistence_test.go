@@ -1,9 +1,11 @@
 package persistence
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 	"github.com/stretchr/testify/assert"
@@ -239,3 +241,122 @@ func TestFileJSONPersistence_loadRecordsFromFile(t *testing.T) {
 		assert.Empty(t, records)
 	})
 }
+
+func TestJournal(t *testing.T) {
+	t.Run("Append and replay", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.journal.jsonl")
+
+		journal, entries, err := OpenJournal(path, JournalOptions{Sync: SyncAlways})
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+
+		assert.NoError(t, journal.Append(JournalEntry{Op: OpPut, Records: []model.URLRecord{{ShortURL: "abc", OriginalURL: "https://a.com"}}}))
+		assert.NoError(t, journal.Append(JournalEntry{Op: OpDelete, ShortURLs: []string{"abc"}}))
+		assert.Equal(t, 2, journal.Len())
+		assert.NoError(t, journal.Close())
+		assert.NoError(t, journal.Close())
+		assert.ErrorIs(t, journal.Append(JournalEntry{Op: OpPurge}), ErrJournalClosed)
+
+		journal, entries, err = OpenJournal(path, JournalOptions{Sync: SyncNever})
+		assert.NoError(t, err)
+		defer journal.Close()
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, "https://a.com", entries[0].Records[0].OriginalURL)
+			assert.Equal(t, []string{"abc"}, entries[1].ShortURLs)
+		}
+		assert.Equal(t, 2, journal.Len())
+	})
+
+	t.Run("Truncated last line is dropped", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.journal.jsonl")
+		content := `{"op":"put","records":[{"short_url":"ok","original_url":"https://ok.com"}]}` + "\n" +
+			`{"op":"put","records":[{"short_url":"torn","orig`
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+		journal, entries, err := OpenJournal(path, JournalOptions{Sync: SyncInterval, SyncInterval: time.Millisecond})
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "ok", entries[0].Records[0].ShortURL)
+		}
+
+		// Новая запись начинается с новой строки
+		assert.NoError(t, journal.Append(JournalEntry{Op: OpPurge, ShortURLs: []string{"ok"}}))
+		assert.NoError(t, journal.Close())
+
+		_, entries, err = OpenJournal(path, JournalOptions{})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+	})
+
+	t.Run("Short write is rolled back", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.journal.jsonl")
+
+		journal, _, err := OpenJournal(path, JournalOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, journal.Append(JournalEntry{Op: OpPurge, ShortURLs: []string{"first"}}))
+
+		file := journal.file
+		journal.file = &shortWriteFile{journalFile: file, limit: 10}
+		assert.ErrorIs(t, journal.Append(JournalEntry{Op: OpPurge, ShortURLs: []string{"lost"}}), io.ErrShortWrite)
+		journal.file = file
+
+		assert.NoError(t, journal.Append(JournalEntry{Op: OpPurge, ShortURLs: []string{"second"}}))
+		assert.Equal(t, 2, journal.Len())
+		assert.NoError(t, journal.Close())
+
+		// Следующая запись идёт сразу за последней целой строкой, без нулей на месте отменённой
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(content), "\x00")
+
+		_, entries, err := OpenJournal(path, JournalOptions{})
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, []string{"first"}, entries[0].ShortURLs)
+			assert.Equal(t, []string{"second"}, entries[1].ShortURLs)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.journal.jsonl")
+
+		journal, _, err := OpenJournal(path, JournalOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, journal.Append(JournalEntry{Op: OpPurge, ShortURLs: []string{"a"}}))
+		assert.NoError(t, journal.Reset())
+		assert.Equal(t, 0, journal.Len())
+		assert.NoError(t, journal.Close())
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Empty(t, content)
+	})
+
+	t.Run("Sync policies", func(t *testing.T) {
+		for _, policy := range []string{"always", "interval", "never"} {
+			assert.NoError(t, ValidateSyncPolicy(policy))
+		}
+		assert.ErrorIs(t, ValidateSyncPolicy("sometimes"), ErrInvalidSyncPolicy)
+
+		_, _, err := OpenJournal(filepath.Join(t.TempDir(), "j.jsonl"), JournalOptions{Sync: "sometimes"})
+		assert.ErrorIs(t, err, ErrInvalidSyncPolicy)
+	})
+}
+
+// shortWriteFile записывает не больше limit байт и сообщает о неполной записи
+type shortWriteFile struct {
+	journalFile
+	limit int
+}
+
+// Write имитирует диск, на котором закончилось место посреди записи
+func (f *shortWriteFile) Write(data []byte) (int, error) {
+	if len(data) <= f.limit {
+		return f.journalFile.Write(data)
+	}
+	n, err := f.journalFile.Write(data[:f.limit])
+	if err != nil {
+		return n, err
+	}
+	return n, io.ErrShortWrite
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+)
+
+// storeConnectTimeout ограничение времени подключения хранилища корзин к PostgreSQL
+const storeConnectTimeout = 5 * time.Second
+
+// NewLimiterFromConfig создаёт ограничитель частоты запросов по лимитам из конфигурации
+// Если PostgreSQL не отвечает при запуске, лимиты хранятся в памяти. Без включённых лимитов база не используется
+func NewLimiterFromConfig(configuration *config.ConfigStruct) *Limiter {
+	limits := map[string]Limit{
+		ScopeCreate:   limitFromConfig(configuration.RateLimitCreate),
+		ScopeBatch:    limitFromConfig(configuration.RateLimitBatch),
+		ScopeRedirect: limitFromConfig(configuration.RateLimitRedirect),
+	}
+	enabled := configuration.RateLimitCreate.Enabled() || configuration.RateLimitBatch.Enabled() ||
+		configuration.RateLimitRedirect.Enabled()
+
+	var store Store = NewMemoryStore()
+	if enabled && configuration.RateLimitStore == config.RateLimitStorePostgres {
+		ctx, cancel := context.WithTimeout(context.Background(), storeConnectTimeout)
+		defer cancel()
+
+		pgStore, err := NewPostgresStore(ctx, configuration.AddressDB)
+		if err != nil {
+			log.Printf("Ошибка подключения хранилища лимитов к PostgreSQL: %v. Лимиты хранятся в памяти", err)
+		} else {
+			store = pgStore
+		}
+	}
+
+	return NewLimiter(store, limits)
+}
+
+// limitFromConfig переводит лимит из конфигурации в параметры корзины токенов
+func limitFromConfig(limit config.RateLimit) Limit {
+	return PerPeriod(limit.Requests, limit.Period)
+}
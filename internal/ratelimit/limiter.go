@@ -0,0 +1,58 @@
+package ratelimit
+
+import "context"
+
+// Limiter проверяет запросы по лимитам своих областей
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+}
+
+// NewLimiter создаёт ограничитель, области без заданного лимита не ограничиваются
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	enabled := make(map[string]Limit, len(limits))
+	for scope, limit := range limits {
+		if limit.Enabled() {
+			enabled[scope] = limit
+		}
+	}
+	return &Limiter{store: store, limits: enabled}
+}
+
+// Limited сообщает, ограничена ли область
+func (l *Limiter) Limited(scope string) bool {
+	_, ok := l.limits[scope]
+	return ok
+}
+
+// Allow берёт токен из корзины каждого ключа в области и возвращает самый строгий результат
+// Запрос разрешён, только если токен нашёлся во всех корзинах
+func (l *Limiter) Allow(ctx context.Context, scope string, keys ...string) (Result, error) {
+	limit, ok := l.limits[scope]
+	if !ok {
+		return Result{Allowed: true}, nil
+	}
+
+	var strictest Result
+	for i, key := range keys {
+		res, err := l.store.Take(ctx, scope+":"+key, limit)
+		if err != nil {
+			return Result{}, err
+		}
+		if i == 0 || stricter(res, strictest) {
+			strictest = res
+		}
+	}
+	return strictest, nil
+}
+
+// stricter сравнивает результаты: отказ строже разрешения, затем меньше оставшихся токенов
+func stricter(a, b Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryPruneInterval период удаления заполненных корзин, которые не отличаются от новых
+const memoryPruneInterval = time.Minute
+
+// MemoryStore хранит корзины в памяти процесса, лимиты действуют в пределах одного экземпляра
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// bucket состояние корзины
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// NewMemoryStore создаёт хранилище корзин в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take берёт токен из корзины key
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	var res Result
+	b.tokens, res = take(b.tokens, now.Sub(b.updated), limit)
+	b.updated = now
+	b.limit = limit
+	return res, nil
+}
+
+// Len возвращает количество хранимых корзин
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.buckets)
+}
+
+// prune удаляет корзины, успевшие заполниться, вызывается под блокировкой
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < memoryPruneInterval {
+		return
+	}
+	s.lastPrune = now
+
+	for key, b := range s.buckets {
+		if refill(b.tokens, now.Sub(b.updated), b.limit) >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
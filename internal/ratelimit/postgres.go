@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Параметры очистки корзин в базе данных
+// Корзина, которую не трогали дольше postgresIdleTTL, заполнена для любого лимита с периодом не больше него
+const (
+	postgresCleanupInterval = 10 * time.Minute
+	postgresIdleTTL         = time.Hour
+)
+
+// takeSQL пополняет корзину и берёт токен одним запросом, строка блокируется на время обновления
+// Время берётся из базы, чтобы часы разных экземпляров сервиса не влияли на лимиты
+const takeSQL = `
+INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at)
+VALUES ($1, $2::double precision - 1, true, now())
+ON CONFLICT (key) DO UPDATE SET
+	allowed = LEAST($2::double precision, b.tokens + GREATEST(EXTRACT(EPOCH FROM now() - b.updated_at)::double precision, 0) * $3) >= 1,
+	tokens = LEAST($2::double precision, b.tokens + GREATEST(EXTRACT(EPOCH FROM now() - b.updated_at)::double precision, 0) * $3)
+		- CASE WHEN LEAST($2::double precision, b.tokens + GREATEST(EXTRACT(EPOCH FROM now() - b.updated_at)::double precision, 0) * $3) >= 1 THEN 1 ELSE 0 END,
+	updated_at = now()
+RETURNING tokens, allowed`
+
+// PostgresStore хранит корзины в PostgreSQL, лимиты общие для всех экземпляров сервиса
+type PostgresStore struct {
+	pool        *pgxpool.Pool
+	lastCleanup atomic.Int64
+}
+
+// NewPostgresStore подключается к базе данных, таблица rate_limits создаётся миграциями
+func NewPostgresStore(ctx context.Context, dsn string) (*PostgresStore, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Пул подключается лениво, поэтому доступность базы проверяется сразу
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &PostgresStore{pool: pool}, nil
+}
+
+// Take берёт токен из корзины key
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.cleanup()
+
+	var tokens float64
+	var allowed bool
+	err := s.pool.QueryRow(ctx, takeSQL, key, float64(limit.Burst), limit.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(tokens, allowed, limit), nil
+}
+
+// cleanup время от времени удаляет давно не используемые корзины в фоне
+func (s *PostgresStore) cleanup() {
+	now := time.Now()
+	last := s.lastCleanup.Load()
+	if now.Sub(time.Unix(0, last)) < postgresCleanupInterval || !s.lastCleanup.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		_, err := s.pool.Exec(ctx, "DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)", postgresIdleTTL.Seconds())
+		if err != nil {
+			log.Printf("Ошибка очистки корзин ограничения запросов: %v", err)
+		}
+	}()
+}
+
+// Close закрывает соединение с базой данных
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
@@ -0,0 +1,95 @@
+// Package ratelimit ограничивает частоту запросов по алгоритму token bucket
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Области ограничения с отдельными лимитами
+const (
+	ScopeCreate   = "create"
+	ScopeBatch    = "batch"
+	ScopeRedirect = "redirect"
+)
+
+// Limit параметры корзины токенов: Burst запросов подряд, затем Rate запросов в секунду
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerPeriod возвращает лимит в requests запросов за period с возможностью потратить их разом
+func PerPeriod(requests int, period time.Duration) Limit {
+	if requests <= 0 || period <= 0 {
+		return Limit{}
+	}
+	return Limit{Rate: float64(requests) / period.Seconds(), Burst: requests}
+}
+
+// Enabled сообщает, задан ли лимит
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Result результат попытки взять токен из корзины
+type Result struct {
+	Allowed bool
+	// Limit ёмкость корзины
+	Limit int
+	// Remaining количество оставшихся целых токенов
+	Remaining int
+	// RetryAfter через сколько появится следующий токен, если запрос отклонён
+	RetryAfter time.Duration
+	// ResetAfter через сколько корзина заполнится полностью
+	ResetAfter time.Duration
+}
+
+// Store хранит состояние корзин
+// Take пополняет корзину key за прошедшее время и берёт из неё токен, если он есть
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// take применяет алгоритм к корзине с tokens токенов, обновлённой elapsed назад
+// Возвращает новое количество токенов и результат
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	tokens = refill(tokens, elapsed, limit)
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, result(tokens, allowed, limit)
+}
+
+// refill пополняет корзину за прошедшее время, не больше её ёмкости
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * limit.Rate
+	}
+	return math.Min(tokens, float64(limit.Burst))
+}
+
+// result описывает состояние корзины после попытки взять токен
+func result(tokens float64, allowed bool, limit Limit) Result {
+	res := Result{
+		Allowed:    allowed,
+		Limit:      limit.Burst,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	return res
+}
+
+// secondsToDuration переводит секунды в длительность
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
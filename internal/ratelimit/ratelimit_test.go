@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock управляемые часы для хранилища в памяти
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestPerPeriod(t *testing.T) {
+	limit := PerPeriod(60, time.Minute)
+	assert.Equal(t, Limit{Rate: 1, Burst: 60}, limit)
+	assert.True(t, limit.Enabled())
+
+	assert.False(t, PerPeriod(0, time.Minute).Enabled())
+	assert.False(t, PerPeriod(10, 0).Enabled())
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	limit := PerPeriod(3, 3*time.Second)
+
+	t.Run("burst then refill", func(t *testing.T) {
+		store, clock := newTestStore()
+
+		for remaining := 2; remaining >= 0; remaining-- {
+			res, err := store.Take(ctx, "k", limit)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 3, res.Limit)
+			assert.Equal(t, remaining, res.Remaining)
+		}
+
+		res, err := store.Take(ctx, "k", limit)
+		require.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, time.Second, res.RetryAfter)
+		assert.Equal(t, 3*time.Second, res.ResetAfter)
+
+		// Через полсекунды токен ещё не накопился
+		clock.Advance(500 * time.Millisecond)
+		res, _ = store.Take(ctx, "k", limit)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+		clock.Advance(500 * time.Millisecond)
+		res, _ = store.Take(ctx, "k", limit)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+
+		// Корзина не наполняется больше своей ёмкости
+		clock.Advance(time.Hour)
+		res, _ = store.Take(ctx, "k", limit)
+		assert.Equal(t, 2, res.Remaining)
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		store, _ := newTestStore()
+
+		for i := 0; i < 3; i++ {
+			_, _ = store.Take(ctx, "a", limit)
+		}
+		res, _ := store.Take(ctx, "a", limit)
+		assert.False(t, res.Allowed)
+
+		res, _ = store.Take(ctx, "b", limit)
+		assert.True(t, res.Allowed)
+	})
+
+	t.Run("full buckets are pruned", func(t *testing.T) {
+		store, clock := newTestStore()
+
+		_, _ = store.Take(ctx, "a", limit)
+		_, _ = store.Take(ctx, "b", limit)
+		assert.Equal(t, 2, store.Len())
+
+		clock.Advance(memoryPruneInterval)
+		_, _ = store.Take(ctx, "c", limit)
+		assert.Equal(t, 1, store.Len())
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unlimited scope", func(t *testing.T) {
+		store, _ := newTestStore()
+		limiter := NewLimiter(store, map[string]Limit{
+			ScopeCreate:   PerPeriod(1, time.Minute),
+			ScopeRedirect: {},
+		})
+
+		assert.True(t, limiter.Limited(ScopeCreate))
+		assert.False(t, limiter.Limited(ScopeRedirect))
+		assert.False(t, limiter.Limited(ScopeBatch))
+
+		for i := 0; i < 3; i++ {
+			res, err := limiter.Allow(ctx, ScopeRedirect, "ip:1")
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+		}
+		assert.Equal(t, 0, store.Len())
+	})
+
+	t.Run("strictest key wins", func(t *testing.T) {
+		store, _ := newTestStore()
+		limiter := NewLimiter(store, map[string]Limit{ScopeCreate: PerPeriod(2, time.Minute)})
+
+		res, err := limiter.Allow(ctx, ScopeCreate, "ip:1", "user:a")
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 1, res.Remaining)
+
+		// Пользователь a исчерпал лимит с другого адреса
+		_, _ = limiter.Allow(ctx, ScopeCreate, "ip:2", "user:a")
+		res, _ = limiter.Allow(ctx, ScopeCreate, "ip:3", "user:a")
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 30*time.Second, res.RetryAfter)
+
+		// Адрес ip:3 потратил токен, а другой пользователь с него ещё проходит
+		res, _ = limiter.Allow(ctx, ScopeCreate, "ip:3", "user:b")
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+	})
+
+	t.Run("scopes are independent", func(t *testing.T) {
+		store, _ := newTestStore()
+		limiter := NewLimiter(store, map[string]Limit{
+			ScopeCreate: PerPeriod(1, time.Minute),
+			ScopeBatch:  PerPeriod(1, time.Minute),
+		})
+
+		res, _ := limiter.Allow(ctx, ScopeCreate, "ip:1")
+		assert.True(t, res.Allowed)
+		res, _ = limiter.Allow(ctx, ScopeBatch, "ip:1")
+		assert.True(t, res.Allowed)
+		res, _ = limiter.Allow(ctx, ScopeCreate, "ip:1")
+		assert.False(t, res.Allowed)
+	})
+}
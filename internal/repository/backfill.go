@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/urlnorm"
+	"github.com/jackc/pgx/v5"
+)
+
+// canonicalBackfill название переноса канонических форм ссылок, сохранённых до их появления
+const canonicalBackfill = "canonical_url"
+
+// backfillBatchSize количество ссылок, которые читаются за один запрос переноса
+const backfillBatchSize = 500
+
+// BackfillCanonicalURLs приводит к канонической форме ссылки, сохранённые до её появления
+// Миграция 000010 записала им присланный URL, поэтому повторы в другой записи не находились
+// Если каноническая форма уже занята другой ссылкой, ссылка остаётся в присланном виде: обе продолжают работать
+// Перенос выполняется один раз, о завершении делается запись в таблице backfills
+func (r *PostgreSQLRepository) BackfillCanonicalURLs(ctx context.Context, opts urlnorm.Options) (int, error) {
+	var done bool
+	err := r.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM backfills WHERE name = $1)", canonicalBackfill).Scan(&done)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check backfill: %w", err)
+	}
+	if done {
+		return 0, nil
+	}
+
+	updated := 0
+	var lastID int64
+	for {
+		rows, err := r.legacyCanonicalRows(ctx, lastID)
+		if err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		lastID = rows[len(rows)-1].id
+
+		for _, row := range rows {
+			canonicalURL, err := urlnorm.Canonicalize(row.originalURL, opts)
+			if err != nil || canonicalURL == row.originalURL {
+				continue
+			}
+
+			err = r.updateCanonicalURL(ctx, row.id, canonicalURL)
+			switch {
+			case isUniqueViolation(err):
+				log.Printf("Ссылка %s повторяет уже сокращённый URL %s и остаётся в присланном виде", row.shortURL, canonicalURL)
+			case err != nil:
+				return updated, err
+			default:
+				updated++
+			}
+		}
+	}
+
+	_, err = r.pool.Exec(ctx,
+		"INSERT INTO backfills (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", canonicalBackfill)
+	if err != nil {
+		return updated, fmt.Errorf("failed to complete backfill: %w", err)
+	}
+	return updated, nil
+}
+
+// legacyRow ссылка, каноническая форма которой совпадает с присланным URL
+type legacyRow struct {
+	id          int64
+	shortURL    string
+	originalURL string
+}
+
+// legacyCanonicalRows читает следующую страницу ссылок, сохранённых с присланным URL вместо канонической формы
+func (r *PostgreSQLRepository) legacyCanonicalRows(ctx context.Context, afterID int64) ([]legacyRow, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT id, short_url, original_url FROM urls
+		 WHERE id > $1 AND canonical_url = original_url AND NOT is_deleted
+		 ORDER BY id LIMIT $2`,
+		afterID, backfillBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query legacy urls: %w", err)
+	}
+
+	result, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (legacyRow, error) {
+		var legacy legacyRow
+		err := row.Scan(&legacy.id, &legacy.shortURL, &legacy.originalURL)
+		return legacy, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan legacy urls: %w", err)
+	}
+	return result, nil
+}
+
+// updateCanonicalURL записывает каноническую форму ссылки
+func (r *PostgreSQLRepository) updateCanonicalURL(ctx context.Context, id int64, canonicalURL string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	_, err := r.pool.Exec(ctx, "UPDATE urls SET canonical_url = $1 WHERE id = $2", canonicalURL, id)
+	if err != nil && !isUniqueViolation(err) {
+		return fmt.Errorf("failed to update canonical url: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheOptions параметры кеша ссылок
+type CacheOptions struct {
+	// Size максимальное количество ссылок в кеше
+	Size int
+	// TTL время жизни найденной ссылки, не дольше срока действия самой ссылки
+	TTL time.Duration
+	// NegativeTTL время жизни ответа об отсутствующей, удалённой или просроченной ссылке
+	NegativeTTL time.Duration
+}
+
+// CachedRepository декоратор репозитория с LRU-кешем оригинальных URL для редиректов
+// Одновременные промахи по одному ключу объединяются в один запрос к хранилищу,
+// а отсутствующие ключи кешируются, чтобы перебор коротких ссылок не доходил до базы
+// Запись и удаление через декоратор сбрасывают затронутые ключи
+type CachedRepository struct {
+	URLRepository
+
+	options CacheOptions
+	group   singleflight.Group
+
+	mu      sync.Mutex
+	order   *list.List // элементы *cacheEntry, недавно использованные в начале
+	entries map[string]*list.Element
+	// generation увеличивается при сбросе ключей, чтобы не сохранить результат, прочитанный до сброса
+	generation uint64
+	now        func() time.Time
+}
+
+// cacheEntry закешированный результат поиска ссылки
+type cacheEntry struct {
+	key  string
+	link Link
+	err  error
+	// expiresAt момент устаревания записи, не позже срока действия самой ссылки
+	expiresAt time.Time
+}
+
+// NewCachedRepository оборачивает репозиторий кешем
+func NewCachedRepository(repo URLRepository, options CacheOptions) *CachedRepository {
+	return &CachedRepository{
+		URLRepository: repo,
+		options:       options,
+		order:         list.New(),
+		entries:       make(map[string]*list.Element),
+		now:           time.Now,
+	}
+}
+
+// GetFullValue получает оригинальный URL из кеша или из хранилища
+func (r *CachedRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	link, err := r.GetLink(ctx, shortURL)
+	return link.OriginalURL, err
+}
+
+// GetLink получает ссылку для перенаправления из кеша или из хранилища
+func (r *CachedRepository) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	if entry, ok := r.lookup(shortURL); ok {
+		metrics.ObserveCacheLookup(true)
+		return entry.link, entry.err
+	}
+	metrics.ObserveCacheLookup(false)
+
+	// Загрузка не зависит от отмены запроса, начавшего её: её результат ждут и другие запросы
+	loadCtx := context.WithoutCancel(ctx)
+	ch := r.group.DoChan(shortURL, func() (any, error) {
+		return r.load(loadCtx, shortURL)
+	})
+
+	select {
+	case <-ctx.Done():
+		return Link{}, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return Link{}, res.Err
+		}
+		return res.Val.(Link), nil
+	}
+}
+
+// load читает ссылку из хранилища и кеширует результат
+func (r *CachedRepository) load(ctx context.Context, shortURL string) (Link, error) {
+	r.mu.Lock()
+	generation := r.generation
+	r.mu.Unlock()
+
+	link, err := r.URLRepository.GetLink(ctx, shortURL)
+	switch {
+	case err == nil:
+		r.store(generation, shortURL, link, nil, r.options.TTL)
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrURLDeleted), errors.Is(err, ErrURLExpired):
+		r.store(generation, shortURL, Link{}, err, r.options.NegativeTTL)
+	}
+	return link, err
+}
+
+// lookup возвращает неустаревшую запись и поднимает её в начало списка
+func (r *CachedRepository) lookup(key string) (*cacheEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !r.now().Before(entry.expiresAt) {
+		r.order.Remove(element)
+		delete(r.entries, key)
+		return nil, false
+	}
+
+	r.order.MoveToFront(element)
+	return entry, true
+}
+
+// store сохраняет результат, если ключи не сбрасывались с начала загрузки
+// Найденная ссылка хранится не дольше своего срока действия
+func (r *CachedRepository) store(generation uint64, key string, link Link, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	expiresAt := r.now().Add(ttl)
+	if link.ExpiresAt != nil && link.ExpiresAt.Before(expiresAt) {
+		expiresAt = *link.ExpiresAt
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if generation != r.generation {
+		return
+	}
+
+	entry := &cacheEntry{key: key, link: link, err: err, expiresAt: expiresAt}
+	if element, ok := r.entries[key]; ok {
+		element.Value = entry
+		r.order.MoveToFront(element)
+		return
+	}
+
+	r.entries[key] = r.order.PushFront(entry)
+	for r.order.Len() > r.options.Size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate сбрасывает ключи из кеша
+func (r *CachedRepository) invalidate(keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	for _, key := range keys {
+		if element, ok := r.entries[key]; ok {
+			r.order.Remove(element)
+			delete(r.entries, key)
+		}
+	}
+}
+
+// purge очищает кеш полностью
+func (r *CachedRepository) purge() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	r.order.Init()
+	r.entries = make(map[string]*list.Element)
+}
+
+// Len возвращает количество ссылок в кеше
+func (r *CachedRepository) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.order.Len()
+}
+
+// SetValue сохраняет ссылку и сбрасывает её из кеша, в том числе закешированное отсутствие
+func (r *CachedRepository) SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	defer r.invalidate(shortURL)
+	return r.URLRepository.SetValue(ctx, shortURL, originalURL, canonicalURL, userID, expiresAt, redirect)
+}
+
+// SetValuesBatch сохраняет пакет ссылок и сбрасывает их из кеша
+func (r *CachedRepository) SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+
+	defer r.invalidate(keys...)
+	return r.URLRepository.SetValuesBatch(ctx, pairs, canonical, userID, expiresAt, redirects)
+}
+
+// DeleteUserURLs помечает ссылки удалёнными и сбрасывает их из кеша
+func (r *CachedRepository) DeleteUserURLs(ctx context.Context, userID string, shortURLs []string) error {
+	defer r.invalidate(shortURLs...)
+	return r.URLRepository.DeleteUserURLs(ctx, userID, shortURLs)
+}
+
+// PurgeExpired удаляет просроченные ссылки и очищает кеш, если что-то было удалено
+func (r *CachedRepository) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	purged, err := r.URLRepository.PurgeExpired(ctx, before)
+	if purged > 0 {
+		r.purge()
+	}
+	return purged, err
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+)
+
+// aggregateClicks считает статистику переходов по списку событий
+func aggregateClicks(events []model.ClickEvent) model.ClickStats {
+	aggregate := newClickAggregate()
+	for _, event := range events {
+		aggregate.add(event)
+	}
+	return aggregate.stats()
+}
+
+// clickAggregate накопленная статистика переходов по одной ссылке без самих событий
+type clickAggregate struct {
+	total    int
+	visitors map[string]struct{}
+	hourly   map[time.Time]int
+	daily    map[time.Time]int
+}
+
+// newClickAggregate создаёт пустую статистику переходов
+func newClickAggregate() *clickAggregate {
+	return &clickAggregate{
+		visitors: make(map[string]struct{}),
+		hourly:   make(map[time.Time]int),
+		daily:    make(map[time.Time]int),
+	}
+}
+
+// add учитывает переход
+func (a *clickAggregate) add(event model.ClickEvent) {
+	a.total++
+	a.visitors[event.IPHash] = struct{}{}
+	timestamp := event.Timestamp.UTC()
+	a.hourly[timestamp.Truncate(time.Hour)]++
+	a.daily[time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), 0, 0, 0, 0, time.UTC)]++
+}
+
+// stats возвращает статистику в формате ответа
+func (a *clickAggregate) stats() model.ClickStats {
+	return model.ClickStats{
+		TotalClicks:    a.total,
+		UniqueVisitors: len(a.visitors),
+		Hourly:         sortedBuckets(a.hourly),
+		Daily:          sortedBuckets(a.daily),
+	}
+}
+
+// sortedBuckets преобразует мапу интервалов в отсортированный по времени список
+func sortedBuckets(counts map[time.Time]int) []model.ClickBucket {
+	buckets := make([]model.ClickBucket, 0, len(counts))
+	for start, clicks := range counts {
+		buckets = append(buckets, model.ClickBucket{Start: start, Clicks: clicks})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+	return buckets
+}
+
+// clickFile хранит события переходов в JSON-lines файле рядом с основным хранилищем
+type clickFile struct {
+	path string
+	mu   sync.Mutex
+	// totals количество переходов по ссылкам, читается из файла один раз при первом обращении
+	totals map[string]int
+}
+
+// newClickFile создаёт хранилище событий для файла с урлами: data/urls.json -> data/urls.clicks.jsonl
+func newClickFile(filePath string) *clickFile {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	return &clickFile{path: base + ".clicks.jsonl"}
+}
+
+// append дописывает события в конец файла
+func (f *clickFile) append(events []model.ClickEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			f.totals = nil
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		// Часть событий могла попасть в файл, счётчики перечитываются при следующем обращении
+		f.totals = nil
+		return err
+	}
+
+	if f.totals != nil {
+		for _, event := range events {
+			f.totals[event.ShortURL]++
+		}
+	}
+	return nil
+}
+
+// load читает события для короткого URL, повреждённые строки пропускаются
+func (f *clickFile) load(shortURL string) ([]model.ClickEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []model.ClickEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event model.ClickEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if event.ShortURL == shortURL {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// counts возвращает количество переходов по указанным коротким URL
+func (f *clickFile) counts(shortURLs []string) (map[string]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.totals == nil {
+		totals, err := f.readTotalsLocked()
+		if err != nil {
+			return nil, err
+		}
+		f.totals = totals
+	}
+
+	counts := make(map[string]int, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		counts[shortURL] = f.totals[shortURL]
+	}
+	return counts, nil
+}
+
+// readTotalsLocked считает переходы по всем ссылкам из файла, вызывается под блокировкой
+func (f *clickFile) readTotalsLocked() (map[string]int, error) {
+	totals := make(map[string]int)
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return totals, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event model.ClickEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		totals[event.ShortURL]++
+	}
+	return totals, scanner.Err()
+}
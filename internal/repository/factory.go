@@ -1,36 +1,130 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/urlnorm"
 )
 
+// ErrNotFound ошибка, которая возникает, когда короткий URL не найден
+var ErrNotFound = errors.New("not found key in database")
+
 // ErrRowExists ошибка, которая возникает, когда запись уже существует
 var ErrRowExists = errors.New("short URL already exists")
 
+// ErrKeyExists ошибка, которая возникает, когда короткий ключ уже занят другой ссылкой
+var ErrKeyExists = errors.New("short key is already taken")
+
+// ErrIdempotencyKeyExists ошибка, которая возникает, когда ключ идемпотентности уже использован
+var ErrIdempotencyKeyExists = errors.New("idempotency key is already used")
+
+// ErrUnavailable ошибка, которая возникает, когда основное хранилище недоступно, а операцию нельзя выполнить локально
+var ErrUnavailable = errors.New("storage is temporarily unavailable")
+
+// ErrDegraded ошибка, которая возникает, когда основное хранилище недоступно, но запросы обслуживаются локально
+var ErrDegraded = errors.New("primary storage is down, serving from local replica")
+
+// ErrClosed ошибка, которая возникает при обращении к закрытому хранилищу
+var ErrClosed = errors.New("storage is closed")
+
+// ErrURLDeleted ошибка, которая возникает при обращении к удалённому URL
+var ErrURLDeleted = errors.New("short URL has been deleted")
+
+// ErrURLExpired ошибка, которая возникает при обращении к просроченному URL
+var ErrURLExpired = errors.New("short URL has expired")
+
 // CreateRepository создает репозиторий в зависимости от конфигурации
 // Приоритет: PostgreSQL -> File -> Memory
-func CreateRepository(databaseDSN, filePath string) URLRepository {
+// Операции репозитория учитываются в метриках с названием выбранного хранилища
+// При сбое PostgreSQL во время работы редиректы отдаются из локальной реплики, а записи копятся в журнале до восстановления
+func CreateRepository(configuration *config.ConfigStruct) URLRepository {
+	databaseDSN := configuration.AddressDB
+	filePath := configuration.FilePath
+
 	// Если есть DATABASE_DSN и он не пустой, используем PostgreSQL
 	if databaseDSN != "" && !isDefaultPostgresValue(databaseDSN) {
 		log.Printf("Используем PostgreSQL репозиторий с DSN: %s", databaseDSN)
-		repo, err := NewPostgreSQLRepository(databaseDSN)
+		timeouts := Timeouts{
+			Read:  time.Duration(configuration.DBReadTimeout),
+			Write: time.Duration(configuration.DBWriteTimeout),
+		}
+		repo, err := NewPostgreSQLRepository(databaseDSN, timeouts)
 		if err != nil {
 			log.Printf("Ошибка создания PostgreSQL репозитория: %v. Переходим к файловому хранилищу", err)
 		} else {
-			return repo
+			if pg, ok := repo.(*PostgreSQLRepository); ok {
+				if err := metrics.RegisterPool(pg.pool); err != nil {
+					log.Printf("Ошибка регистрации метрик пула соединений: %v", err)
+				}
+				// Ссылки, сохранённые до появления канонической формы, переносятся в фоне, не задерживая запуск
+				go backfillCanonicalURLs(pg, urlnorm.Options{SortQuery: configuration.URLSortQuery})
+			}
+			return withCache(withFailover(NewInstrumentedRepository(repo, BackendPostgres), configuration), configuration)
 		}
 	}
 
 	// Если есть FILE_STORAGE_PATH и он не пустой, используем файловое хранилище
 	if filePath != "" {
 		log.Printf("Используем файловый репозиторий с путем: %s", filePath)
-		return NewFileRepository(filePath)
+		return NewInstrumentedRepository(NewFileRepositoryWithOptions(filePath, FileOptions{
+			Sync:             persistence.SyncPolicy(configuration.FileSyncPolicy),
+			SyncInterval:     time.Duration(configuration.FileSyncInterval),
+			CompactThreshold: configuration.FileCompactThreshold,
+		}), BackendFile)
 	}
 
 	// Иначе используем память
 	log.Println("Используем репозиторий в памяти")
-	return NewMemoryRepository()
+	return NewInstrumentedRepository(NewMemoryRepository(), BackendMemory)
+}
+
+// backfillCanonicalURLs переносит канонические формы ссылок и сообщает о результате в лог
+func backfillCanonicalURLs(repo *PostgreSQLRepository, opts urlnorm.Options) {
+	updated, err := repo.BackfillCanonicalURLs(context.Background(), opts)
+	if err != nil {
+		log.Printf("Ошибка переноса канонических форм ссылок: %v. Перенос продолжится при следующем запуске", err)
+		return
+	}
+	if updated > 0 {
+		log.Printf("Канонические формы записаны для %d ссылок", updated)
+	}
+}
+
+// withFailover оборачивает базу данных декоратором, который продолжает работу при её сбое, если задан журнал записей
+func withFailover(repo URLRepository, configuration *config.ConfigStruct) URLRepository {
+	if configuration.FailoverSpoolPath == "" {
+		return repo
+	}
+
+	resilient, err := NewResilientRepository(repo, ResilientOptions{
+		SpoolPath:     configuration.FailoverSpoolPath,
+		ProbeInterval: time.Duration(configuration.FailoverProbeInterval),
+	})
+	if err != nil {
+		log.Printf("Ошибка создания журнала записей на время сбоя: %v. Работаем без переключения", err)
+		return repo
+	}
+	return resilient
+}
+
+// withCache оборачивает репозиторий кешем ссылок, если он включён
+// Файловое хранилище и память держат ссылки в памяти, поэтому кеш ставится только перед базой данных
+func withCache(repo URLRepository, configuration *config.ConfigStruct) URLRepository {
+	if configuration.CacheSize <= 0 {
+		return repo
+	}
+
+	return NewCachedRepository(repo, CacheOptions{
+		Size:        configuration.CacheSize,
+		TTL:         time.Duration(configuration.CacheTTL),
+		NegativeTTL: time.Duration(configuration.CacheNegativeTTL),
+	})
 }
 
 // isDefaultPostgresValue проверяет, является ли значение DSN дефолтным значением из флагов
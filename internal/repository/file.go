@@ -1,123 +1,549 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"log"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/entity"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
 )
 
+// defaultCompactThreshold количество записей журнала, после которого он сворачивается в снимок
+const defaultCompactThreshold = 1000
+
+// FileOptions параметры файлового хранилища
+type FileOptions struct {
+	// Sync политика сброса журнала на диск, по умолчанию после каждой записи
+	Sync         persistence.SyncPolicy
+	SyncInterval time.Duration
+	// CompactThreshold количество записей журнала, после которого он сворачивается в снимок
+	CompactThreshold int
+}
+
 // FileRepository реализация репозитория для хранения в файле
+// Состояние хранится в снимке filePath и журнале изменений рядом с ним: data/urls.json -> data/urls.journal.jsonl
+// Изменения дописываются в журнал, который периодически сворачивается в снимок
 type FileRepository struct {
-	data         map[string]string
-	reversedData map[string]string
-	userMap      map[string]string
-	mu           sync.RWMutex
-	filePath     string
-	persistence  persistence.JSONPersistence
+	data             map[string]string
+	canonical        map[string]string // shortURL -> каноническая форма originalURL
+	reversedData     map[string]string // каноническая форма originalURL -> shortURL
+	userMap          map[string]string
+	created          map[string]time.Time
+	deleted          map[string]time.Time
+	expires          map[string]time.Time
+	redirects        map[string]model.RedirectOptions // shortURL -> параметры перенаправления, если они заданы
+	mu               sync.RWMutex
+	filePath         string
+	persistence      persistence.JSONPersistence
+	journal          *persistence.Journal
+	compactThreshold int
+	closed           bool
+	clicks           *clickFile
+	sequence         *sequenceFile
+	idempotency      *idempotencyTable
 }
 
 // NewFileRepository создает новый репозиторий для работы с файлом
 func NewFileRepository(filePath string) URLRepository {
+	return NewFileRepositoryWithOptions(filePath, FileOptions{})
+}
+
+// NewFileRepositoryWithOptions создает репозиторий для работы с файлом с заданными параметрами журнала
+func NewFileRepositoryWithOptions(filePath string, options FileOptions) URLRepository {
+	if options.CompactThreshold <= 0 {
+		options.CompactThreshold = defaultCompactThreshold
+	}
+
 	repo := &FileRepository{
-		data:         make(map[string]string),
-		reversedData: make(map[string]string),
-		userMap:      make(map[string]string),
-		filePath:     filePath,
-		persistence:  persistence.NewFileJSONPersistence(),
+		data:             make(map[string]string),
+		canonical:        make(map[string]string),
+		reversedData:     make(map[string]string),
+		userMap:          make(map[string]string),
+		created:          make(map[string]time.Time),
+		deleted:          make(map[string]time.Time),
+		expires:          make(map[string]time.Time),
+		redirects:        make(map[string]model.RedirectOptions),
+		filePath:         filePath,
+		persistence:      persistence.NewFileJSONPersistence(),
+		compactThreshold: options.CompactThreshold,
+		clicks:           newClickFile(filePath),
+		sequence:         newSequenceFile(filePath),
+		idempotency: newIdempotencyFile(filePath, persistence.JournalOptions{
+			Sync:         options.Sync,
+			SyncInterval: options.SyncInterval,
+		}),
 	}
 
-	// Загружаем данные из файла при инициализации
-	data, userMap, _, err := repo.persistence.Load(filePath)
+	// Загружаем снимок из файла при инициализации
+	records, err := repo.persistence.LoadRecords(filePath)
 	if err == nil {
-		repo.data = data
-		repo.userMap = userMap
+		repo.apply(persistence.JournalEntry{Op: persistence.OpPut, Records: records})
 	}
 
-	// Формирование обратной мапы
-	for originalURL, shortURL := range data {
-		repo.reversedData[originalURL] = shortURL
+	// Применяем изменения, записанные в журнал после снимка
+	journal, entries, err := persistence.OpenJournal(journalPath(filePath), persistence.JournalOptions{
+		Sync:         options.Sync,
+		SyncInterval: options.SyncInterval,
+	})
+	if err != nil {
+		log.Printf("Ошибка открытия журнала %s: %v. Изменения будут сохраняться полной перезаписью файла", journalPath(filePath), err)
+	} else {
+		repo.journal = journal
+		for _, entry := range entries {
+			repo.apply(entry)
+		}
+		if len(entries) > 0 {
+			repo.compact()
+		}
 	}
 
 	return repo
 }
 
+// journalPath возвращает путь к журналу для файла с урлами
+func journalPath(filePath string) string {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	return base + ".journal.jsonl"
+}
+
 // GetFullValue получает оригинальный URL по короткому
-func (r *FileRepository) GetFullValue(shortURL string) (string, error) {
+func (r *FileRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	link, err := r.GetLink(ctx, shortURL)
+	return link.OriginalURL, err
+}
+
+// GetLink получает оригинальный URL по короткому вместе со сроком действия и кодом перенаправления
+func (r *FileRepository) GetLink(_ context.Context, shortURL string) (Link, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	if value, ok := r.data[shortURL]; ok {
-		return value, nil
+		if _, deleted := r.deleted[shortURL]; deleted {
+			return Link{}, ErrURLDeleted
+		}
+		expiresAt := timeRef(r.expires, shortURL)
+		if expiresAt != nil && !time.Now().Before(*expiresAt) {
+			return Link{}, ErrURLExpired
+		}
+		return Link{OriginalURL: value, ExpiresAt: expiresAt, RedirectOptions: r.redirects[shortURL]}, nil
 	}
-	return "", errors.New("not found key in database")
+	return Link{}, ErrNotFound
 }
 
-// GetShortValue получает короткий URL по оригинальному
-func (r *FileRepository) GetShortValue(originalURL string) (string, error) {
+// GetShortValue получает короткий URL действующей ссылки по канонической форме оригинального
+func (r *FileRepository) GetShortValue(_ context.Context, canonicalURL string) (string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	if value, ok := r.reversedData[originalURL]; ok {
+	if value, ok := r.canonicalHolderLocked(canonicalURL); ok {
 		return value, nil
 	}
-	return "", errors.New("not found key in database")
+	return "", ErrNotFound
+}
+
+// GetShortValues получает короткие URL действующих ссылок по каноническим формам
+func (r *FileRepository) GetShortValues(_ context.Context, canonicalURLs []string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := make(map[string]string)
+	for _, canonicalURL := range canonicalURLs {
+		if shortURL, ok := r.canonicalHolderLocked(canonicalURL); ok {
+			values[canonicalURL] = shortURL
+		}
+	}
+	return values, nil
+}
+
+// GetExistingKeys возвращает занятые короткие ключи из списка
+func (r *FileRepository) GetExistingKeys(_ context.Context, shortURLs []string) (map[string]struct{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make(map[string]struct{})
+	for _, shortURL := range shortURLs {
+		if _, ok := r.data[shortURL]; ok {
+			keys[shortURL] = struct{}{}
+		}
+	}
+	return keys, nil
 }
 
 // SetValue сохраняет пару короткий URL - оригинальный URL с user_id
-func (r *FileRepository) SetValue(shortURL, originalURL, userID string) error {
+func (r *FileRepository) SetValue(_ context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	return r.setValue(newURLRecord(shortURL, originalURL, canonicalURL, userID, time.Now().UTC(), expiresAt, redirect))
+}
+
+// RestoreValue сохраняет ссылку с исходным моментом создания
+func (r *FileRepository) RestoreValue(_ context.Context, record model.URLRecord) error {
+	return r.setValue(newURLRecord(record.ShortURL, record.OriginalURL, record.CanonicalURL, record.UserID, createdAtOf(record), record.ExpiresAt, record.RedirectOptions))
+}
+
+// setValue сохраняет новую ссылку в журнал
+func (r *FileRepository) setValue(record model.URLRecord) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if _, ok := r.data[shortURL]; ok {
+	if _, ok := r.canonicalHolderLocked(record.CanonicalURL); ok {
 		return ErrRowExists
 	}
-	r.data[shortURL] = originalURL
-	r.userMap[shortURL] = userID
+	if _, ok := r.data[record.ShortURL]; ok {
+		return ErrKeyExists
+	}
 
-	// Сохраняем в файл
-	return r.persistence.Save(r.filePath, r.data, r.userMap)
+	return r.commit(persistence.JournalEntry{Op: persistence.OpPut, Records: []model.URLRecord{record}})
 }
 
 // SetValuesBatch сохраняет пакет пар короткий URL - оригинальный URL с user_id
-func (r *FileRepository) SetValuesBatch(pairs map[string]string, userID string) error {
+func (r *FileRepository) SetValuesBatch(_ context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	// Проверяем весь пакет до записи, чтобы не сохранить его частично
+	batch := make(map[string]struct{}, len(pairs))
 	for key, value := range pairs {
 		if _, ok := r.data[key]; ok {
+			return ErrKeyExists
+		}
+		canonicalURL := canonicalOf(canonical, key, value)
+		if _, ok := r.canonicalHolderLocked(canonicalURL); ok {
 			return ErrRowExists
 		}
-		r.data[key] = value
-		r.userMap[key] = userID
+		if _, ok := batch[canonicalURL]; ok {
+			return ErrRowExists
+		}
+		batch[canonicalURL] = struct{}{}
 	}
 
-	// Сохраняем в файл
-	return r.persistence.Save(r.filePath, r.data, r.userMap)
+	now := time.Now().UTC()
+	records := make([]model.URLRecord, 0, len(pairs))
+	for key, value := range pairs {
+		var expires *time.Time
+		if at, ok := expiresAt[key]; ok {
+			expires = &at
+		}
+		records = append(records, newURLRecord(key, value, canonicalOf(canonical, key, value), userID, now, expires, redirects[key]))
+	}
+
+	return r.commit(persistence.JournalEntry{Op: persistence.OpPut, Records: records})
 }
 
-// Close закрывает соединение с хранилищем
-func (r *FileRepository) Close() error {
+// Ping проверяет, что хранилище не закрыто
+func (r *FileRepository) Ping(_ context.Context) error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	if r.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close сохраняет снимок, очищает журнал и закрывает его
+func (r *FileRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
 	// Финальное сохранение в файл
-	return r.persistence.Save(r.filePath, r.data, r.userMap)
+	err := r.save()
+	idempotencyErr := r.idempotency.close()
+	if r.journal == nil {
+		return errors.Join(err, idempotencyErr)
+	}
+	// Если снимок не сохранился, журнал остаётся и будет применён при следующем запуске
+	if err == nil {
+		err = r.journal.Reset()
+	}
+	return errors.Join(err, idempotencyErr, r.journal.Close())
+}
+
+// GetUserURLs получает страницу URL пользователя
+func (r *FileRepository) GetUserURLs(_ context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var urls []entity.URL
+	for shortURL, originalURL := range r.data {
+		if _, deleted := r.deleted[shortURL]; deleted || userID != r.userMap[shortURL] || !query.matches(originalURL) {
+			continue
+		}
+		urls = append(urls, entity.URL{
+			ShortURL:    shortURL,
+			OriginalURL: originalURL,
+			UserID:      userID,
+			CreatedAt:   r.created[shortURL],
+			DeletedAt:   timeRef(r.deleted, shortURL),
+			ExpiresAt:   timeRef(r.expires, shortURL),
+		})
+	}
+
+	page, err := paginate(urls, query)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	clickCounts, err := r.clicks.counts(pageShortURLs(page))
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	for i := range page.URLs {
+		page.URLs[i].ClickCount = clickCounts[page.URLs[i].ShortURL]
+	}
+	return page, nil
+}
+
+// DeleteUserURLs помечает удалёнными URL пользователя
+func (r *FileRepository) DeleteUserURLs(_ context.Context, userID string, shortURLs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var shortURLsToDelete []string
+	for _, shortURL := range shortURLs {
+		if _, deleted := r.deleted[shortURL]; deleted {
+			continue
+		}
+		if owner, ok := r.userMap[shortURL]; ok && owner == userID {
+			shortURLsToDelete = append(shortURLsToDelete, shortURL)
+		}
+	}
+
+	if len(shortURLsToDelete) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	return r.commit(persistence.JournalEntry{Op: persistence.OpDelete, ShortURLs: shortURLsToDelete, At: &now})
+}
+
+// CountURLs возвращает количество сохранённых URL
+func (r *FileRepository) CountURLs(_ context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for shortURL := range r.data {
+		if _, deleted := r.deleted[shortURL]; !deleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountUsers возвращает количество уникальных пользователей
+func (r *FileRepository) CountUsers(_ context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make(map[string]struct{})
+	for shortURL, userID := range r.userMap {
+		if _, deleted := r.deleted[shortURL]; !deleted && userID != "" {
+			users[userID] = struct{}{}
+		}
+	}
+	return len(users), nil
+}
+
+// PurgeExpired удаляет ссылки, срок действия которых истёк раньше указанного момента
+func (r *FileRepository) PurgeExpired(_ context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged []string
+	for shortURL, expiresAt := range r.expires {
+		if expiresAt.Before(before) {
+			purged = append(purged, shortURL)
+		}
+	}
+
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	return len(purged), r.commit(persistence.JournalEntry{Op: persistence.OpPurge, ShortURLs: purged})
 }
 
-// GetUserURLs получает все URL пользователя
-func (r *FileRepository) GetUserURLs(userID string) ([]map[string]string, error) {
+// GetUserID возвращает владельца короткого URL
+func (r *FileRepository) GetUserID(_ context.Context, shortURL string) (string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	var urls []map[string]string
+	if userID, ok := r.userMap[shortURL]; ok {
+		return userID, nil
+	}
+	return "", ErrNotFound
+}
+
+// SaveClicks дописывает события переходов в соседний файл
+func (r *FileRepository) SaveClicks(_ context.Context, events []model.ClickEvent) error {
+	return r.clicks.append(events)
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL
+func (r *FileRepository) GetClickStats(_ context.Context, shortURL string) (model.ClickStats, error) {
+	events, err := r.clicks.load(shortURL)
+	if err != nil {
+		return model.ClickStats{}, err
+	}
+	return aggregateClicks(events), nil
+}
+
+// save сохраняет текущее состояние в файл, вызывается под блокировкой
+func (r *FileRepository) save() error {
+	records := make([]model.URLRecord, 0, len(r.data))
+
+	counter := 1
 	for shortURL, originalURL := range r.data {
-		if userID == r.userMap[shortURL] {
-			urls = append(urls, map[string]string{
-				"short_url":    shortURL,
-				"original_url": originalURL,
-			})
+		_, deleted := r.deleted[shortURL]
+		records = append(records, model.URLRecord{
+			ID:              counter,
+			ShortURL:        shortURL,
+			OriginalURL:     originalURL,
+			CanonicalURL:    r.canonical[shortURL],
+			UserID:          r.userMap[shortURL],
+			IsDeleted:       deleted,
+			ExpiresAt:       timeRef(r.expires, shortURL),
+			CreatedAt:       timeRef(r.created, shortURL),
+			DeletedAt:       timeRef(r.deleted, shortURL),
+			RedirectOptions: r.redirects[shortURL],
+		})
+		counter++
+	}
+
+	return r.persistence.SaveRecords(r.filePath, records)
+}
+
+// commit сохраняет изменение и применяет его к состоянию в памяти, вызывается под блокировкой
+// Изменение сначала дописывается в журнал, поэтому при ошибке записи состояние не меняется
+func (r *FileRepository) commit(entry persistence.JournalEntry) error {
+	if r.journal == nil {
+		r.apply(entry)
+		return r.save()
+	}
+
+	if err := r.journal.Append(entry); err != nil {
+		return err
+	}
+	r.apply(entry)
+
+	if r.journal.Len() >= r.compactThreshold {
+		r.compact()
+	}
+	return nil
+}
+
+// apply применяет запись журнала к состоянию в памяти
+// Повторное применение записи не меняет результат, поэтому журнал можно проигрывать поверх более нового снимка
+func (r *FileRepository) apply(entry persistence.JournalEntry) {
+	switch entry.Op {
+	case persistence.OpPut:
+		for _, record := range entry.Records {
+			// Ссылки, сохранённые до появления канонической формы, сравниваются в присланном виде
+			canonicalURL := record.CanonicalURL
+			if canonicalURL == "" {
+				canonicalURL = record.OriginalURL
+			}
+			r.data[record.ShortURL] = record.OriginalURL
+			r.canonical[record.ShortURL] = canonicalURL
+			r.userMap[record.ShortURL] = record.UserID
+			if record.CreatedAt != nil {
+				r.created[record.ShortURL] = *record.CreatedAt
+			}
+			// В старых файлах момент удаления не сохранялся
+			if record.DeletedAt != nil {
+				r.deleted[record.ShortURL] = *record.DeletedAt
+			} else if record.IsDeleted {
+				r.deleted[record.ShortURL] = time.Time{}
+			}
+			if record.ExpiresAt != nil {
+				r.expires[record.ShortURL] = *record.ExpiresAt
+			}
+			if record.RedirectOptions != (model.RedirectOptions{}) {
+				r.redirects[record.ShortURL] = record.RedirectOptions
+			}
+			// Снимок читается в произвольном порядке: истёкшая ссылка не вытесняет действующую на тот же URL
+			if _, deleted := r.deleted[record.ShortURL]; !deleted {
+				if holder, ok := r.canonicalHolderLocked(canonicalURL); !ok || holder == record.ShortURL {
+					r.reversedData[canonicalURL] = record.ShortURL
+				}
+			}
+		}
+	case persistence.OpDelete:
+		var at time.Time
+		if entry.At != nil {
+			at = *entry.At
+		}
+		for _, shortURL := range entry.ShortURLs {
+			if _, ok := r.data[shortURL]; !ok {
+				continue
+			}
+			if _, deleted := r.deleted[shortURL]; !deleted {
+				r.deleted[shortURL] = at
+			}
+			// Удалённая ссылка освобождает оригинальный URL
+			if canonicalURL := r.canonical[shortURL]; r.reversedData[canonicalURL] == shortURL {
+				delete(r.reversedData, canonicalURL)
+			}
 		}
+	case persistence.OpPurge:
+		for _, shortURL := range entry.ShortURLs {
+			if canonicalURL, ok := r.canonical[shortURL]; ok && r.reversedData[canonicalURL] == shortURL {
+				delete(r.reversedData, canonicalURL)
+			}
+			delete(r.data, shortURL)
+			delete(r.canonical, shortURL)
+			delete(r.userMap, shortURL)
+			delete(r.created, shortURL)
+			delete(r.deleted, shortURL)
+			delete(r.expires, shortURL)
+			delete(r.redirects, shortURL)
+		}
+	}
+}
+
+// canonicalHolderLocked возвращает ссылку, занимающую оригинальный URL
+// Истёкшая ссылка его не занимает: URL можно сократить заново до очистки
+func (r *FileRepository) canonicalHolderLocked(canonicalURL string) (string, bool) {
+	shortURL, ok := r.reversedData[canonicalURL]
+	if !ok {
+		return "", false
+	}
+	if expiresAt, ok := r.expires[shortURL]; ok && !time.Now().Before(expiresAt) {
+		return "", false
+	}
+	return shortURL, true
+}
+
+// compact сворачивает журнал в снимок, вызывается под блокировкой
+// Ошибка не прерывает запись: изменения остаются в журнале до следующей попытки
+func (r *FileRepository) compact() {
+	if err := r.save(); err != nil {
+		log.Printf("Ошибка сохранения снимка %s: %v", r.filePath, err)
+		return
+	}
+	if err := r.journal.Reset(); err != nil {
+		log.Printf("Ошибка очистки журнала %s: %v", journalPath(r.filePath), err)
+	}
+}
+
+// newURLRecord создаёт запись о новой ссылке
+func newURLRecord(shortURL, originalURL, canonicalURL, userID string, createdAt time.Time, expiresAt *time.Time, redirect model.RedirectOptions) model.URLRecord {
+	return model.URLRecord{
+		ShortURL:        shortURL,
+		OriginalURL:     originalURL,
+		CanonicalURL:    canonicalURL,
+		UserID:          userID,
+		CreatedAt:       &createdAt,
+		ExpiresAt:       expiresAt,
+		RedirectOptions: redirect,
 	}
-	return urls, nil
 }
@@ -0,0 +1,363 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
+	"github.com/jackc/pgx/v5"
+)
+
+// idempotencyPurgeInterval минимальный интервал между удалениями просроченных ключей
+const idempotencyPurgeInterval = time.Minute
+
+// idempotencyLease сколько незавершённый запрос удерживает ключ, если процесс упал и не освободил его
+// Берётся с запасом к самым долгим запросам, после него повтор выполняет запрос заново
+const idempotencyLease = time.Minute
+
+// withLease задаёт срок удержания ключа незавершённым запросом, если он не задан
+func withLease(record model.IdempotencyRecord, now time.Time) model.IdempotencyRecord {
+	if record.LockedUntil.IsZero() {
+		record.LockedUntil = now.Add(idempotencyLease)
+	}
+	return record
+}
+
+// idempotencyTable ключи идемпотентности для хранилищ в памяти и в файле
+// Просроченные ключи не учитываются и удаляются не чаще idempotencyPurgeInterval
+// Если задан путь, сохранённые ответы дописываются в журнал, который сворачивается в снимок
+type idempotencyTable struct {
+	records   map[string]model.IdempotencyRecord // userID + ключ -> запись
+	path      string
+	journal   *persistence.Journal
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+// newIdempotencyTable создаёт таблицу ключей в памяти
+func newIdempotencyTable() *idempotencyTable {
+	return &idempotencyTable{records: make(map[string]model.IdempotencyRecord)}
+}
+
+// newIdempotencyFile создаёт таблицу ключей для файла с урлами
+// Снимок: data/urls.json -> data/urls.idempotency.json, журнал: data/urls.idempotency.journal.jsonl
+func newIdempotencyFile(filePath string, options persistence.JournalOptions) *idempotencyTable {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".idempotency"
+	table := newIdempotencyTable()
+	table.path = base + ".json"
+	table.load()
+
+	journal, entries, err := persistence.OpenJournal(base+".journal.jsonl", options)
+	if err != nil {
+		log.Printf("Ошибка открытия журнала ключей идемпотентности: %v. Ответы будут сохраняться полной перезаписью файла", err)
+		return table
+	}
+	table.journal = journal
+	for _, entry := range entries {
+		table.apply(entry)
+	}
+	if len(entries) > 0 {
+		table.compactLocked()
+	}
+	return table
+}
+
+// idempotencyID возвращает ключ таблицы, ключи идемпотентности разных пользователей не пересекаются
+func idempotencyID(userID, key string) string {
+	return userID + "\x00" + key
+}
+
+// reserve занимает ключ, действующий ключ возвращается вместе с ErrIdempotencyKeyExists
+func (t *idempotencyTable) reserve(record model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastPrune) >= idempotencyPurgeInterval {
+		t.pruneLocked(now)
+		t.lastPrune = now
+	}
+
+	// Незавершённый ключ с истёкшим удержанием занимается заново: первый запрос уже не завершится
+	id := idempotencyID(record.UserID, record.Key)
+	if existing, ok := t.records[id]; ok && now.Before(existing.ExpiresAt) &&
+		(existing.Completed() || now.Before(existing.LockedUntil)) {
+		return existing, ErrIdempotencyKeyExists
+	}
+
+	record = withLease(record, now)
+	record.StatusCode = 0
+	record.ContentType = ""
+	record.Body = nil
+	t.records[id] = record
+	return record, nil
+}
+
+// complete сохраняет ответ для занятого ключа
+// Ответ сначала дописывается в журнал, поэтому при ошибке записи ключ остаётся незавершённым
+func (t *idempotencyTable) complete(record model.IdempotencyRecord) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := idempotencyID(record.UserID, record.Key)
+	existing, ok := t.records[id]
+	if !ok || existing.RequestHash != record.RequestHash {
+		return ErrNotFound
+	}
+
+	if t.journal == nil {
+		t.records[id] = record
+		return t.storeLocked()
+	}
+
+	entry := persistence.JournalEntry{Op: persistence.OpComplete, Idempotency: []model.IdempotencyRecord{record}}
+	if err := t.journal.Append(entry); err != nil {
+		return err
+	}
+	t.apply(entry)
+
+	if t.journal.Len() >= defaultCompactThreshold {
+		t.compactLocked()
+	}
+	return nil
+}
+
+// release удаляет ключ, ответ для которого не сохранён
+func (t *idempotencyTable) release(userID, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := idempotencyID(userID, key)
+	if existing, ok := t.records[id]; ok && !existing.Completed() {
+		delete(t.records, id)
+	}
+}
+
+// close сворачивает журнал в снимок и закрывает его
+func (t *idempotencyTable) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.journal == nil {
+		return nil
+	}
+	t.compactLocked()
+	err := t.journal.Close()
+	t.journal = nil
+	return err
+}
+
+// apply применяет запись журнала к таблице
+func (t *idempotencyTable) apply(entry persistence.JournalEntry) {
+	if entry.Op != persistence.OpComplete {
+		return
+	}
+	for _, record := range entry.Idempotency {
+		t.records[idempotencyID(record.UserID, record.Key)] = record
+	}
+}
+
+// pruneLocked удаляет просроченные ключи
+func (t *idempotencyTable) pruneLocked(now time.Time) {
+	for id, record := range t.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(t.records, id)
+		}
+	}
+}
+
+// load читает сохранённые ответы из снимка, повреждённый снимок пропускается
+func (t *idempotencyTable) load() {
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return
+	}
+
+	var records []model.IdempotencyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return
+	}
+	for _, record := range records {
+		if record.Completed() {
+			t.records[idempotencyID(record.UserID, record.Key)] = record
+		}
+	}
+	t.pruneLocked(time.Now())
+}
+
+// compactLocked сворачивает журнал в снимок без просроченных ключей
+// Ошибка не прерывает запись: ответы остаются в журнале до следующей попытки
+func (t *idempotencyTable) compactLocked() {
+	t.pruneLocked(time.Now())
+	if err := t.storeLocked(); err != nil {
+		log.Printf("Ошибка сохранения снимка %s: %v", t.path, err)
+		return
+	}
+	if err := t.journal.Reset(); err != nil {
+		log.Printf("Ошибка очистки журнала ключей идемпотентности: %v", err)
+	}
+}
+
+// storeLocked атомарно записывает снимок сохранённых ответов
+// Незавершённые ключи не записываются: после перезапуска запрос можно повторить
+func (t *idempotencyTable) storeLocked() error {
+	if t.path == "" {
+		return nil
+	}
+
+	records := make([]model.IdempotencyRecord, 0, len(t.records))
+	for _, record := range t.records {
+		if record.Completed() {
+			records = append(records, record)
+		}
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	return persistence.WriteFileAtomic(t.path, data)
+}
+
+// ReserveIdempotencyKey занимает ключ идемпотентности пользователя
+func (r *MemoryRepository) ReserveIdempotencyKey(_ context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	return r.idempotency.reserve(record)
+}
+
+// CompleteIdempotencyKey сохраняет ответ для занятого ключа идемпотентности
+func (r *MemoryRepository) CompleteIdempotencyKey(_ context.Context, record model.IdempotencyRecord) error {
+	return r.idempotency.complete(record)
+}
+
+// ReleaseIdempotencyKey освобождает ключ, ответ для которого не был сохранён
+func (r *MemoryRepository) ReleaseIdempotencyKey(_ context.Context, userID, key string) error {
+	r.idempotency.release(userID, key)
+	return nil
+}
+
+// ReserveIdempotencyKey занимает ключ идемпотентности пользователя
+func (r *FileRepository) ReserveIdempotencyKey(_ context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	return r.idempotency.reserve(record)
+}
+
+// CompleteIdempotencyKey сохраняет ответ для занятого ключа идемпотентности и записывает его в файл
+func (r *FileRepository) CompleteIdempotencyKey(_ context.Context, record model.IdempotencyRecord) error {
+	return r.idempotency.complete(record)
+}
+
+// ReleaseIdempotencyKey освобождает ключ, ответ для которого не был сохранён
+func (r *FileRepository) ReleaseIdempotencyKey(_ context.Context, userID, key string) error {
+	r.idempotency.release(userID, key)
+	return nil
+}
+
+// ReserveIdempotencyKey занимает ключ идемпотентности пользователя
+// Просроченная запись с тем же ключом перезаписывается, как и незавершённая с истёкшим удержанием
+func (r *PostgreSQLRepository) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	r.purgeIdempotencyKeys()
+
+	record = withLease(record, time.Now())
+	var reserved bool
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at, locked_until)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (user_id, key) DO UPDATE
+		 SET request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = NULL, body = NULL,
+		     expires_at = EXCLUDED.expires_at, locked_until = EXCLUDED.locked_until
+		 WHERE idempotency_keys.expires_at <= now()
+		    OR (idempotency_keys.status_code IS NULL AND COALESCE(idempotency_keys.locked_until, '-infinity') <= now())
+		 RETURNING true`,
+		record.UserID, record.Key, record.RequestHash, record.ExpiresAt, record.LockedUntil).Scan(&reserved)
+	if err == nil {
+		return record, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return model.IdempotencyRecord{}, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	existing := model.IdempotencyRecord{Key: record.Key, UserID: record.UserID}
+	var statusCode *int
+	var contentType *string
+	err = r.pool.QueryRow(ctx,
+		`SELECT request_hash, status_code, content_type, body, expires_at
+		 FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key).Scan(&existing.RequestHash, &statusCode, &contentType, &existing.Body, &existing.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Ключ освободили между запросами, пробуем занять его ещё раз
+		return r.ReserveIdempotencyKey(ctx, record)
+	}
+	if err != nil {
+		return model.IdempotencyRecord{}, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if statusCode != nil {
+		existing.StatusCode = *statusCode
+	}
+	if contentType != nil {
+		existing.ContentType = *contentType
+	}
+	return existing, ErrIdempotencyKeyExists
+}
+
+// CompleteIdempotencyKey сохраняет ответ для занятого ключа идемпотентности
+func (r *PostgreSQLRepository) CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx,
+		`UPDATE idempotency_keys SET status_code = $4, content_type = $5, body = $6
+		 WHERE user_id = $1 AND key = $2 AND request_hash = $3`,
+		record.UserID, record.Key, record.RequestHash, record.StatusCode, record.ContentType, record.Body)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey освобождает ключ, ответ для которого не был сохранён
+func (r *PostgreSQLRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	_, err := r.pool.Exec(ctx,
+		"DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL", userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// purgeIdempotencyKeys время от времени удаляет просроченные ключи в фоне
+func (r *PostgreSQLRepository) purgeIdempotencyKeys() {
+	now := time.Now()
+	last := r.lastIdempotencyPurge.Load()
+	if now.Sub(time.Unix(0, last)) < idempotencyPurgeInterval || !r.lastIdempotencyPurge.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if _, err := r.pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= now()"); err != nil {
+			log.Printf("Ошибка удаления просроченных ключей идемпотентности: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+)
+
+// Названия хранилищ для метрик
+const (
+	BackendPostgres = "postgres"
+	BackendFile     = "file"
+	BackendMemory   = "memory"
+)
+
+// InstrumentedRepository декоратор репозитория, собирающий метрики времени выполнения и ошибок операций
+type InstrumentedRepository struct {
+	repo    URLRepository
+	backend string
+}
+
+// NewInstrumentedRepository оборачивает репозиторий сбором метрик с указанным названием хранилища
+func NewInstrumentedRepository(repo URLRepository, backend string) *InstrumentedRepository {
+	return &InstrumentedRepository{repo: repo, backend: backend}
+}
+
+// observe записывает метрики операции, начатой в момент start
+func (r *InstrumentedRepository) observe(operation string, start time.Time, err error) {
+	metrics.ObserveRepositoryOperation(r.backend, operation, time.Since(start), isFailure(err))
+}
+
+// isFailure отделяет сбои хранилища от ожидаемых результатов: отсутствия, конфликтов, удаления и истечения ссылки
+func isFailure(err error) bool {
+	return err != nil &&
+		!errors.Is(err, ErrNotFound) &&
+		!errors.Is(err, ErrRowExists) &&
+		!errors.Is(err, ErrKeyExists) &&
+		!errors.Is(err, ErrIdempotencyKeyExists) &&
+		!errors.Is(err, ErrURLDeleted) &&
+		!errors.Is(err, ErrURLExpired)
+}
+
+// GetFullValue получает оригинальный URL по короткому
+func (r *InstrumentedRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	start := time.Now()
+	value, err := r.repo.GetFullValue(ctx, shortURL)
+	r.observe("get_full_value", start, err)
+	return value, err
+}
+
+// GetLink получает ссылку для перенаправления, учитывается вместе с GetFullValue
+func (r *InstrumentedRepository) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	start := time.Now()
+	link, err := r.repo.GetLink(ctx, shortURL)
+	r.observe("get_full_value", start, err)
+	return link, err
+}
+
+// GetShortValue получает короткий URL по канонической форме оригинального
+func (r *InstrumentedRepository) GetShortValue(ctx context.Context, canonicalURL string) (string, error) {
+	start := time.Now()
+	value, err := r.repo.GetShortValue(ctx, canonicalURL)
+	r.observe("get_short_value", start, err)
+	return value, err
+}
+
+// GetShortValues получает короткие URL по каноническим формам
+func (r *InstrumentedRepository) GetShortValues(ctx context.Context, canonicalURLs []string) (map[string]string, error) {
+	start := time.Now()
+	values, err := r.repo.GetShortValues(ctx, canonicalURLs)
+	r.observe("get_short_values", start, err)
+	return values, err
+}
+
+// GetExistingKeys возвращает занятые короткие ключи из списка
+func (r *InstrumentedRepository) GetExistingKeys(ctx context.Context, shortURLs []string) (map[string]struct{}, error) {
+	start := time.Now()
+	keys, err := r.repo.GetExistingKeys(ctx, shortURLs)
+	r.observe("get_existing_keys", start, err)
+	return keys, err
+}
+
+// SetValue сохраняет пару короткий URL - оригинальный URL
+func (r *InstrumentedRepository) SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	start := time.Now()
+	err := r.repo.SetValue(ctx, shortURL, originalURL, canonicalURL, userID, expiresAt, redirect)
+	r.observe("set_value", start, err)
+	return err
+}
+
+// RestoreValue сохраняет ссылку с исходным моментом создания
+func (r *InstrumentedRepository) RestoreValue(ctx context.Context, record model.URLRecord) error {
+	start := time.Now()
+	err := r.repo.RestoreValue(ctx, record)
+	r.observe("restore_value", start, err)
+	return err
+}
+
+// SetValuesBatch сохраняет пакет пар короткий URL - оригинальный URL
+func (r *InstrumentedRepository) SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
+	start := time.Now()
+	err := r.repo.SetValuesBatch(ctx, pairs, canonical, userID, expiresAt, redirects)
+	r.observe("set_values_batch", start, err)
+	return err
+}
+
+// GetUserURLs получает страницу URL пользователя
+func (r *InstrumentedRepository) GetUserURLs(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	start := time.Now()
+	page, err := r.repo.GetUserURLs(ctx, userID, query)
+	r.observe("get_user_urls", start, err)
+	return page, err
+}
+
+// DeleteUserURLs помечает удалёнными короткие URL пользователя
+func (r *InstrumentedRepository) DeleteUserURLs(ctx context.Context, userID string, shortURLs []string) error {
+	start := time.Now()
+	err := r.repo.DeleteUserURLs(ctx, userID, shortURLs)
+	r.observe("delete_user_urls", start, err)
+	return err
+}
+
+// CountURLs возвращает количество сохранённых URL
+func (r *InstrumentedRepository) CountURLs(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := r.repo.CountURLs(ctx)
+	r.observe("count_urls", start, err)
+	return count, err
+}
+
+// CountUsers возвращает количество уникальных пользователей
+func (r *InstrumentedRepository) CountUsers(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := r.repo.CountUsers(ctx)
+	r.observe("count_users", start, err)
+	return count, err
+}
+
+// PurgeExpired удаляет ссылки, срок действия которых истёк
+func (r *InstrumentedRepository) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	count, err := r.repo.PurgeExpired(ctx, before)
+	r.observe("purge_expired", start, err)
+	return count, err
+}
+
+// GetUserID возвращает владельца короткого URL
+func (r *InstrumentedRepository) GetUserID(ctx context.Context, shortURL string) (string, error) {
+	start := time.Now()
+	userID, err := r.repo.GetUserID(ctx, shortURL)
+	r.observe("get_user_id", start, err)
+	return userID, err
+}
+
+// SaveClicks сохраняет пакет событий переходов
+func (r *InstrumentedRepository) SaveClicks(ctx context.Context, events []model.ClickEvent) error {
+	start := time.Now()
+	err := r.repo.SaveClicks(ctx, events)
+	r.observe("save_clicks", start, err)
+	return err
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL
+func (r *InstrumentedRepository) GetClickStats(ctx context.Context, shortURL string) (model.ClickStats, error) {
+	start := time.Now()
+	stats, err := r.repo.GetClickStats(ctx, shortURL)
+	r.observe("get_click_stats", start, err)
+	return stats, err
+}
+
+// NextSequence возвращает следующее значение счётчика ключей
+func (r *InstrumentedRepository) NextSequence(ctx context.Context) (int64, error) {
+	start := time.Now()
+	value, err := r.repo.NextSequence(ctx)
+	r.observe("next_sequence", start, err)
+	return value, err
+}
+
+// ReserveIdempotencyKey занимает ключ идемпотентности пользователя
+func (r *InstrumentedRepository) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	start := time.Now()
+	existing, err := r.repo.ReserveIdempotencyKey(ctx, record)
+	r.observe("reserve_idempotency_key", start, err)
+	return existing, err
+}
+
+// CompleteIdempotencyKey сохраняет ответ для ключа идемпотентности
+func (r *InstrumentedRepository) CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) error {
+	start := time.Now()
+	err := r.repo.CompleteIdempotencyKey(ctx, record)
+	r.observe("complete_idempotency_key", start, err)
+	return err
+}
+
+// ReleaseIdempotencyKey освобождает ключ идемпотентности
+func (r *InstrumentedRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	start := time.Now()
+	err := r.repo.ReleaseIdempotencyKey(ctx, userID, key)
+	r.observe("release_idempotency_key", start, err)
+	return err
+}
+
+// Ping проверяет готовность хранилища
+func (r *InstrumentedRepository) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := r.repo.Ping(ctx)
+	r.observe("ping", start, err)
+	return err
+}
+
+// Close закрывает соединение с хранилищем
+func (r *InstrumentedRepository) Close() error {
+	return r.repo.Close()
+}
@@ -1,94 +1,357 @@
 package repository
 
 import (
-	"errors"
+	"context"
 	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/entity"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 )
 
 // MemoryRepository реализация репозитория для хранения в памяти
 type MemoryRepository struct {
-	data    map[string]string // shortURL -> originalURL
-	userMap map[string]string // shortURL -> userID
-	mu      sync.RWMutex
+	data        map[string]string                // shortURL -> originalURL
+	canon       map[string]string                // shortURL -> каноническая форма originalURL
+	byCanon     map[string]string                // каноническая форма originalURL -> shortURL
+	userMap     map[string]string                // shortURL -> userID
+	created     map[string]time.Time             // shortURL -> момент создания
+	deleted     map[string]time.Time             // shortURL -> момент удаления
+	expires     map[string]time.Time             // shortURL -> срок действия
+	redirects   map[string]model.RedirectOptions // shortURL -> параметры перенаправления, если они заданы
+	clicks      map[string]*clickAggregate       // shortURL -> статистика переходов, сами события не хранятся
+	seq         int64                            // счётчик последовательных ключей
+	idempotency *idempotencyTable                // ключи идемпотентности, хранятся только в памяти
+	mu          sync.RWMutex
 }
 
 // NewMemoryRepository создает новый репозиторий для работы с памятью
 func NewMemoryRepository() URLRepository {
 	return &MemoryRepository{
-		data:    make(map[string]string),
-		userMap: make(map[string]string),
+		data:      make(map[string]string),
+		canon:     make(map[string]string),
+		byCanon:   make(map[string]string),
+		userMap:   make(map[string]string),
+		created:   make(map[string]time.Time),
+		deleted:   make(map[string]time.Time),
+		expires:   make(map[string]time.Time),
+		redirects: make(map[string]model.RedirectOptions),
+		clicks:    make(map[string]*clickAggregate),
+
+		idempotency: newIdempotencyTable(),
 	}
 }
 
 // GetValue получает оригинальный URL по короткому
-func (r *MemoryRepository) GetFullValue(shortURL string) (string, error) {
+func (r *MemoryRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	link, err := r.GetLink(ctx, shortURL)
+	return link.OriginalURL, err
+}
+
+// GetLink получает оригинальный URL по короткому вместе со сроком действия и параметрами перенаправления
+func (r *MemoryRepository) GetLink(_ context.Context, shortURL string) (Link, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	if value, ok := r.data[shortURL]; ok {
-		return value, nil
+		if _, deleted := r.deleted[shortURL]; deleted {
+			return Link{}, ErrURLDeleted
+		}
+		expiresAt := timeRef(r.expires, shortURL)
+		if expiresAt != nil && !time.Now().Before(*expiresAt) {
+			return Link{}, ErrURLExpired
+		}
+		return Link{OriginalURL: value, ExpiresAt: expiresAt, RedirectOptions: r.redirects[shortURL]}, nil
+	}
+	return Link{}, ErrNotFound
+}
+
+// GetShortValue получает короткий URL действующей ссылки по канонической форме оригинального
+func (r *MemoryRepository) GetShortValue(_ context.Context, canonicalURL string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if short, ok := r.canonicalHolderLocked(canonicalURL); ok {
+		return short, nil
+	}
+	return "", ErrNotFound
+}
+
+// GetShortValues получает короткие URL действующих ссылок по каноническим формам
+func (r *MemoryRepository) GetShortValues(_ context.Context, canonicalURLs []string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := make(map[string]string)
+	for _, canonicalURL := range canonicalURLs {
+		if shortURL, ok := r.canonicalHolderLocked(canonicalURL); ok {
+			values[canonicalURL] = shortURL
+		}
 	}
-	return "", errors.New("not found key in database")
+	return values, nil
 }
 
-// GetShortValue получает короткий URL по оригинальному
-func (r *MemoryRepository) GetShortValue(originalURL string) (string, error) {
+// GetExistingKeys возвращает занятые короткие ключи из списка
+func (r *MemoryRepository) GetExistingKeys(_ context.Context, shortURLs []string) (map[string]struct{}, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	for short, long := range r.data {
-		if long == originalURL {
-			return short, nil
+	keys := make(map[string]struct{})
+	for _, shortURL := range shortURLs {
+		if _, ok := r.data[shortURL]; ok {
+			keys[shortURL] = struct{}{}
 		}
 	}
-	return "", errors.New("not found key in database")
+	return keys, nil
 }
 
 // SetValue сохраняет пару короткий URL - оригинальный URL с user_id
-func (r *MemoryRepository) SetValue(shortURL, originalURL, userID string) error {
+func (r *MemoryRepository) SetValue(_ context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	return r.setValue(shortURL, originalURL, canonicalURL, userID, time.Now().UTC(), expiresAt, redirect)
+}
+
+// RestoreValue сохраняет ссылку с исходным моментом создания
+func (r *MemoryRepository) RestoreValue(_ context.Context, record model.URLRecord) error {
+	return r.setValue(record.ShortURL, record.OriginalURL, record.CanonicalURL, record.UserID, createdAtOf(record), record.ExpiresAt, record.RedirectOptions)
+}
+
+// setValue сохраняет ссылку с заданным моментом создания
+func (r *MemoryRepository) setValue(shortURL, originalURL, canonicalURL, userID string, createdAt time.Time, expiresAt *time.Time, redirect model.RedirectOptions) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, ok := r.data[shortURL]; ok {
+	if _, ok := r.canonicalHolderLocked(canonicalURL); ok {
 		return ErrRowExists
 	}
+	if _, ok := r.data[shortURL]; ok {
+		return ErrKeyExists
+	}
 	r.data[shortURL] = originalURL
+	r.canon[shortURL] = canonicalURL
+	r.byCanon[canonicalURL] = shortURL
 	r.userMap[shortURL] = userID
+	r.created[shortURL] = createdAt
+	if expiresAt != nil {
+		r.expires[shortURL] = *expiresAt
+	}
+	if redirect != (model.RedirectOptions{}) {
+		r.redirects[shortURL] = redirect
+	}
 	return nil
 }
 
 // SetValuesBatch сохраняет пакет пар короткий URL - оригинальный URL с user_id
-func (r *MemoryRepository) SetValuesBatch(pairs map[string]string, userID string) error {
+func (r *MemoryRepository) SetValuesBatch(_ context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	// Проверяем весь пакет до записи, чтобы не сохранить его частично
+	batch := make(map[string]struct{}, len(pairs))
 	for key, value := range pairs {
 		if _, ok := r.data[key]; ok {
+			return ErrKeyExists
+		}
+		canonicalURL := canonicalOf(canonical, key, value)
+		if _, ok := r.canonicalHolderLocked(canonicalURL); ok {
 			return ErrRowExists
 		}
+		if _, ok := batch[canonicalURL]; ok {
+			return ErrRowExists
+		}
+		batch[canonicalURL] = struct{}{}
+	}
+
+	now := time.Now().UTC()
+	for key, value := range pairs {
+		canonicalURL := canonicalOf(canonical, key, value)
 		r.data[key] = value
+		r.canon[key] = canonicalURL
+		r.byCanon[canonicalURL] = key
 		r.userMap[key] = userID
+		r.created[key] = now
+		if expires, ok := expiresAt[key]; ok {
+			r.expires[key] = expires
+		}
+		if redirect, ok := redirects[key]; ok {
+			r.redirects[key] = redirect
+		}
 	}
 	return nil
 }
 
+// Ping проверяет готовность хранилища, память готова всегда
+func (r *MemoryRepository) Ping(_ context.Context) error {
+	return nil
+}
+
 // Close закрывает соединение с хранилищем (для памяти это заглушка)
 func (r *MemoryRepository) Close() error {
 	return nil
 }
 
-// GetUserURLs получает все URL пользователя
-func (r *MemoryRepository) GetUserURLs(userID string) ([]map[string]string, error) {
+// GetUserURLs получает страницу URL пользователя
+func (r *MemoryRepository) GetUserURLs(_ context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	var urls []map[string]string
+	var urls []entity.URL
 	for shortURL, originalURL := range r.data {
-		if userID == r.userMap[shortURL] {
-			urls = append(urls, map[string]string{
-				"short_url":    shortURL,
-				"original_url": originalURL,
-			})
+		if _, deleted := r.deleted[shortURL]; deleted || userID != r.userMap[shortURL] || !query.matches(originalURL) {
+			continue
+		}
+		urls = append(urls, entity.URL{
+			ShortURL:    shortURL,
+			OriginalURL: originalURL,
+			UserID:      userID,
+			CreatedAt:   r.created[shortURL],
+			DeletedAt:   timeRef(r.deleted, shortURL),
+			ExpiresAt:   timeRef(r.expires, shortURL),
+		})
+	}
+
+	page, err := paginate(urls, query)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	for i := range page.URLs {
+		if aggregate, ok := r.clicks[page.URLs[i].ShortURL]; ok {
+			page.URLs[i].ClickCount = aggregate.total
+		}
+	}
+	return page, nil
+}
+
+// DeleteUserURLs помечает удалёнными URL пользователя
+func (r *MemoryRepository) DeleteUserURLs(_ context.Context, userID string, shortURLs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, shortURL := range shortURLs {
+		if _, deleted := r.deleted[shortURL]; deleted {
+			continue
+		}
+		if owner, ok := r.userMap[shortURL]; ok && owner == userID {
+			r.deleted[shortURL] = now
+			// Удалённая ссылка освобождает оригинальный URL
+			if r.byCanon[r.canon[shortURL]] == shortURL {
+				delete(r.byCanon, r.canon[shortURL])
+			}
 		}
 	}
-	return urls, nil
+	return nil
+}
+
+// CountURLs возвращает количество сохранённых URL
+func (r *MemoryRepository) CountURLs(_ context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for shortURL := range r.data {
+		if _, deleted := r.deleted[shortURL]; !deleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountUsers возвращает количество уникальных пользователей
+func (r *MemoryRepository) CountUsers(_ context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make(map[string]struct{})
+	for shortURL, userID := range r.userMap {
+		if _, deleted := r.deleted[shortURL]; !deleted && userID != "" {
+			users[userID] = struct{}{}
+		}
+	}
+	return len(users), nil
+}
+
+// PurgeExpired удаляет ссылки, срок действия которых истёк раньше указанного момента
+func (r *MemoryRepository) PurgeExpired(_ context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for shortURL, expiresAt := range r.expires {
+		if expiresAt.Before(before) {
+			// Оригинальный URL мог быть уже сокращён заново другой ссылкой
+			if r.byCanon[r.canon[shortURL]] == shortURL {
+				delete(r.byCanon, r.canon[shortURL])
+			}
+			delete(r.canon, shortURL)
+			delete(r.data, shortURL)
+			delete(r.userMap, shortURL)
+			delete(r.created, shortURL)
+			delete(r.deleted, shortURL)
+			delete(r.expires, shortURL)
+			delete(r.redirects, shortURL)
+			delete(r.clicks, shortURL)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// GetUserID возвращает владельца короткого URL
+func (r *MemoryRepository) GetUserID(_ context.Context, shortURL string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if userID, ok := r.userMap[shortURL]; ok {
+		return userID, nil
+	}
+	return "", ErrNotFound
+}
+
+// SaveClicks сохраняет пакет событий переходов
+func (r *MemoryRepository) SaveClicks(_ context.Context, events []model.ClickEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, event := range events {
+		aggregate, ok := r.clicks[event.ShortURL]
+		if !ok {
+			aggregate = newClickAggregate()
+			r.clicks[event.ShortURL] = aggregate
+		}
+		aggregate.add(event)
+	}
+	return nil
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL
+func (r *MemoryRepository) GetClickStats(_ context.Context, shortURL string) (model.ClickStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	aggregate, ok := r.clicks[shortURL]
+	if !ok {
+		aggregate = newClickAggregate()
+	}
+	return aggregate.stats(), nil
+}
+
+// canonicalHolderLocked возвращает ссылку, занимающую оригинальный URL
+// Истёкшая ссылка его не занимает: URL можно сократить заново до очистки
+func (r *MemoryRepository) canonicalHolderLocked(canonicalURL string) (string, bool) {
+	shortURL, ok := r.byCanon[canonicalURL]
+	if !ok {
+		return "", false
+	}
+	if expiresAt, ok := r.expires[shortURL]; ok && !time.Now().Before(expiresAt) {
+		return "", false
+	}
+	return shortURL, true
+}
+
+// NextSequence возвращает следующее значение счётчика, счётчик хранится только в памяти
+func (r *MemoryRepository) NextSequence(_ context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	return r.seq, nil
 }
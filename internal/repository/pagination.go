@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/entity"
+)
+
+// Ограничения размера страницы URL пользователя для API
+const (
+	DefaultUserURLsLimit = 100
+	MaxUserURLsLimit     = 1000
+)
+
+// ErrInvalidCursor ошибка, которая возникает при повреждённом курсоре страницы
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// UserURLsQuery параметры выборки URL пользователя
+type UserURLsQuery struct {
+	Limit     int    // размер страницы, 0 - без ограничения
+	Cursor    string // курсор из предыдущей страницы
+	Ascending bool   // сортировка по дате создания: по возрастанию или по убыванию
+	Contains  string // подстрока оригинального URL, без учёта регистра
+	Domain    string // домен оригинального URL, включая поддомены
+}
+
+// UserURLsPage страница URL пользователя
+type UserURLsPage struct {
+	URLs       []entity.URL
+	NextCursor string // пустой, если страница последняя
+}
+
+// pageCursor позиция в выборке: дата создания и короткий URL последней записи страницы
+type pageCursor struct {
+	createdAt time.Time
+	shortURL  string
+}
+
+// encodeCursor кодирует позицию записи в непрозрачную строку
+func encodeCursor(record entity.URL) string {
+	raw := record.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + record.ShortURL
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor разбирает курсор, пустая строка означает начало выборки
+func decodeCursor(cursor string) (*pageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, shortURL, ok := strings.Cut(string(raw), "|")
+	if !ok || shortURL == "" {
+		return nil, ErrInvalidCursor
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &pageCursor{createdAt: parsed, shortURL: shortURL}, nil
+}
+
+// after сообщает, находится ли запись за курсором в порядке сортировки
+func (c *pageCursor) after(record entity.URL, ascending bool) bool {
+	return lessURL(c.createdAt, c.shortURL, record.CreatedAt, record.ShortURL, ascending)
+}
+
+// lessURL сравнивает записи по дате создания, при равенстве - по короткому URL
+func lessURL(createdA time.Time, shortA string, createdB time.Time, shortB string, ascending bool) bool {
+	if !createdA.Equal(createdB) {
+		return createdA.Before(createdB) == ascending
+	}
+	if shortA == shortB {
+		return false
+	}
+	return (shortA < shortB) == ascending
+}
+
+// matches проверяет оригинальный URL на соответствие фильтрам выборки
+func (q UserURLsQuery) matches(originalURL string) bool {
+	if q.Contains != "" && !strings.Contains(strings.ToLower(originalURL), strings.ToLower(q.Contains)) {
+		return false
+	}
+	if q.Domain != "" && !matchesDomain(originalURL, q.Domain) {
+		return false
+	}
+	return true
+}
+
+// matchesDomain проверяет, что хост URL совпадает с доменом или является его поддоменом
+func matchesDomain(originalURL, domain string) bool {
+	parsed, err := url.Parse(originalURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// paginate сортирует отфильтрованные записи и вырезает страницу после курсора
+// Используется хранилищами, которые держат все записи в памяти
+func paginate(urls []entity.URL, query UserURLsQuery) (UserURLsPage, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+
+	sort.Slice(urls, func(i, j int) bool {
+		return lessURL(urls[i].CreatedAt, urls[i].ShortURL, urls[j].CreatedAt, urls[j].ShortURL, query.Ascending)
+	})
+
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(urls), func(i int) bool {
+			return cursor.after(urls[i], query.Ascending)
+		})
+	}
+	urls = urls[start:]
+
+	var page UserURLsPage
+	if query.Limit > 0 && len(urls) > query.Limit {
+		urls = urls[:query.Limit]
+		page.NextCursor = encodeCursor(urls[len(urls)-1])
+	}
+	page.URLs = urls
+	return page, nil
+}
+
+// pageShortURLs возвращает короткие URL записей страницы
+func pageShortURLs(page UserURLsPage) []string {
+	shortURLs := make([]string, len(page.URLs))
+	for i, url := range page.URLs {
+		shortURLs[i] = url.ShortURL
+	}
+	return shortURLs
+}
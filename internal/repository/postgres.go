@@ -5,35 +5,59 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/entity"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/lib/pq"
 )
 
+// Код ошибки PostgreSQL при нарушении уникального индекса
+const uniqueViolationCode = "23505"
+
+// shortURLIndex уникальный индекс коротких ключей, его нарушение означает занятый ключ
+const shortURLIndex = "idx_urls_short_url"
+
 // PostgreSQLRepository реализация репозитория для работы с PostgreSQL
 type PostgreSQLRepository struct {
-	pool *pgxpool.Pool
+	pool     *pgxpool.Pool
+	timeouts Timeouts
+
+	lastIdempotencyPurge atomic.Int64 // момент последнего удаления просроченных ключей идемпотентности, UnixNano
+}
+
+// Timeouts ограничения времени выполнения запросов к базе данных
+// Нулевое значение отключает ограничение
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
 }
 
 // NewPostgreSQLRepository создает новый репозиторий для работы с PostgreSQL
-func NewPostgreSQLRepository(dsn string) (URLRepository, error) {
+func NewPostgreSQLRepository(dsn string, timeouts Timeouts) (URLRepository, error) {
 	// Подключаемся к базе данных
 	pool, err := pgxpool.New(context.Background(), dsn)
 	if err != nil {
-		return nil, fmt.Errorf("unable to connect to database: %v", err)
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
 
 	repo := &PostgreSQLRepository{
-		pool: pool,
+		pool:     pool,
+		timeouts: timeouts,
 	}
 
 	// Выполняем миграции
 	if err := repo.runMigrations(dsn); err != nil {
 		pool.Close()
-		return nil, fmt.Errorf("failed to run migrations: %v", err)
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	return repo, nil
@@ -68,135 +92,568 @@ func (r *PostgreSQLRepository) runMigrations(dsn string) error {
 }
 
 // GetValue получает оригинальный URL по короткому
-func (r *PostgreSQLRepository) GetFullValue(shortURL string) (string, error) {
-	var originalURL string
-	err := r.pool.QueryRow(context.Background(),
-		"SELECT original_url FROM urls WHERE short_url = $1", shortURL).Scan(&originalURL)
+func (r *PostgreSQLRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	link, err := r.GetLink(ctx, shortURL)
+	return link.OriginalURL, err
+}
+
+// GetLink получает оригинальный URL по короткому вместе со сроком действия и параметрами перенаправления
+func (r *PostgreSQLRepository) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var link Link
+	var isDeleted bool
+	err := r.pool.QueryRow(ctx,
+		`SELECT original_url, is_deleted, expires_at, redirect_code, query_merge, path_passthrough
+		 FROM urls WHERE short_url = $1`, shortURL).
+		Scan(&link.OriginalURL, &isDeleted, &link.ExpiresAt, &link.RedirectCode, &link.QueryMerge, &link.PathPassthrough)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", errors.New("not found key in database")
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Link{}, ErrNotFound
 		}
-		return "", fmt.Errorf("failed to get value: %v", err)
+		return Link{}, fmt.Errorf("failed to get value: %w", err)
+	}
+
+	if isDeleted {
+		return Link{}, ErrURLDeleted
+	}
+	if link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt) {
+		return Link{}, ErrURLExpired
 	}
 
-	return originalURL, nil
+	return link, nil
 }
 
-// GetShortValue получает оригинальный URL по короткому
-func (r *PostgreSQLRepository) GetShortValue(originalURL string) (string, error) {
+// GetShortValue получает короткий URL действующей ссылки по канонической форме оригинального
+func (r *PostgreSQLRepository) GetShortValue(ctx context.Context, canonicalURL string) (string, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
 	var shortURL string
-	err := r.pool.QueryRow(context.Background(),
-		"SELECT short_url FROM urls WHERE original_url = $1", originalURL).Scan(&shortURL)
+	err := r.pool.QueryRow(ctx,
+		`SELECT short_url FROM urls
+		 WHERE canonical_url = $1 AND NOT is_deleted AND (expires_at IS NULL OR expires_at > NOW())`,
+		canonicalURL).Scan(&shortURL)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", errors.New("not found key in database")
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
 		}
-		return "", fmt.Errorf("failed to get value: %v", err)
+		return "", fmt.Errorf("failed to get value: %w", err)
 	}
 
 	return shortURL, nil
 }
 
+// GetShortValues получает короткие URL действующих ссылок по каноническим формам одним запросом
+func (r *PostgreSQLRepository) GetShortValues(ctx context.Context, canonicalURLs []string) (map[string]string, error) {
+	values := make(map[string]string)
+	if len(canonicalURLs) == 0 {
+		return values, nil
+	}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx,
+		`SELECT canonical_url, short_url FROM urls
+		 WHERE canonical_url = ANY($1) AND NOT is_deleted AND (expires_at IS NULL OR expires_at > NOW())`,
+		canonicalURLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get values: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var canonicalURL, shortURL string
+		if err := rows.Scan(&canonicalURL, &shortURL); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		values[canonicalURL] = shortURL
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return values, nil
+}
+
+// GetExistingKeys возвращает занятые короткие ключи из списка одним запросом
+func (r *PostgreSQLRepository) GetExistingKeys(ctx context.Context, shortURLs []string) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+	if len(shortURLs) == 0 {
+		return keys, nil
+	}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	rows, err := r.pool.Query(ctx, "SELECT short_url FROM urls WHERE short_url = ANY($1)", shortURLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		keys[shortURL] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return keys, nil
+}
+
 // SetValue сохраняет пару короткий URL - оригинальный URL с user_id
-func (r *PostgreSQLRepository) SetValue(shortURL, originalURL, userID string) error {
-	tx, err := r.pool.Begin(context.Background())
+func (r *PostgreSQLRepository) SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	return r.insertValue(ctx, shortURL, originalURL, canonicalURL, userID, nil, expiresAt, redirect)
+}
+
+// RestoreValue сохраняет ссылку с исходным моментом создания
+func (r *PostgreSQLRepository) RestoreValue(ctx context.Context, record model.URLRecord) error {
+	createdAt := createdAtOf(record)
+	return r.insertValue(ctx, record.ShortURL, record.OriginalURL, record.CanonicalURL, record.UserID, &createdAt, record.ExpiresAt, record.RedirectOptions)
+}
+
+// insertValue добавляет ссылку, без createdAt моментом создания считается текущий
+func (r *PostgreSQLRepository) insertValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, createdAt, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = releaseExpiredHolders(ctx, tx, []string{canonicalURL}); err != nil {
+		return err
 	}
-	defer tx.Rollback(context.Background())
 
 	var result string
-	err = tx.QueryRow(context.Background(),
-		`INSERT INTO urls (short_url, original_url, user_id)
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT (original_url) DO NOTHING
+	err = tx.QueryRow(ctx,
+		`INSERT INTO urls (short_url, original_url, canonical_url, user_id, expires_at, redirect_code, query_merge, path_passthrough, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9::timestamp, CURRENT_TIMESTAMP))
+		 ON CONFLICT (canonical_url) WHERE NOT is_deleted AND NOT canonical_released DO NOTHING
 		 RETURNING short_url`,
-		shortURL, originalURL, userID).Scan(&result)
+		shortURL, originalURL, canonicalURL, userID, expiresAt, redirect.RedirectCode, redirect.QueryMerge, redirect.PathPassthrough, createdAt).Scan(&result)
 
 	// Запись уже существует
-	if errors.Is(err, sql.ErrNoRows) {
+	if errors.Is(err, pgx.ErrNoRows) {
 		return ErrRowExists
 	}
+	// Короткий URL уже занят другой ссылкой
+	if isUniqueViolation(err) {
+		return conflictError(err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to insert url: %v", err)
+		return fmt.Errorf("failed to insert url: %w", err)
 	}
 
-	if err = tx.Commit(context.Background()); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
 // SetValuesBatch сохраняет пакет пар короткий URL - оригинальный URL с user_id
-func (r *PostgreSQLRepository) SetValuesBatch(pairs map[string]string, userID string) error {
+// Пакет записывается одной командой COPY, любой конфликт отменяет весь пакет
+func (r *PostgreSQLRepository) SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
 	if len(pairs) == 0 {
 		return nil
 	}
 
-	tx, err := r.pool.Begin(context.Background())
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	rows := make([][]any, 0, len(pairs))
+	canonicalURLs := make([]string, 0, len(pairs))
+	for shortURL, originalURL := range pairs {
+		// Срок действия ссылки, если он задан
+		var expires *time.Time
+		if value, ok := expiresAt[shortURL]; ok {
+			expires = &value
+		}
+		redirect := redirects[shortURL]
+		canonicalURL := canonicalOf(canonical, shortURL, originalURL)
+		rows = append(rows, []any{shortURL, originalURL, canonicalURL, userID, expires,
+			redirect.RedirectCode, redirect.QueryMerge, redirect.PathPassthrough})
+		canonicalURLs = append(canonicalURLs, canonicalURL)
+	}
+
+	tx, err := r.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer tx.Rollback(context.Background())
+	defer tx.Rollback(ctx)
 
-	// Подготавливаем пакетную вставку
-	for shortURL, originalURL := range pairs {
-		// Удаляем любую запись с таким short_url (если она не та, что будет обновлена)
-		_, err = tx.Exec(context.Background(),
-			`DELETE FROM urls WHERE short_url = $1 AND original_url != $2`,
-			shortURL, originalURL)
-		if err != nil {
-			return fmt.Errorf("failed to delete conflicting short_url: %v", err)
+	if err = releaseExpiredHolders(ctx, tx, canonicalURLs); err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"urls"},
+		[]string{"short_url", "original_url", "canonical_url", "user_id", "expires_at", "redirect_code", "query_merge", "path_passthrough"},
+		pgx.CopyFromRows(rows))
+	if isUniqueViolation(err) {
+		return conflictError(err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert urls: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// releaseExpiredHolders выводит истёкшие ссылки на те же оригинальные URL из уникального индекса,
+// чтобы их можно было сократить заново. Сами ссылки остаются до очистки устаревших
+func releaseExpiredHolders(ctx context.Context, tx pgx.Tx, canonicalURLs []string) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE urls SET canonical_released = TRUE
+		 WHERE canonical_url = ANY($1) AND NOT is_deleted AND NOT canonical_released
+		   AND expires_at IS NOT NULL AND expires_at <= NOW()`,
+		canonicalURLs)
+	if err != nil {
+		return fmt.Errorf("failed to release expired urls: %w", err)
+	}
+	return nil
+}
+
+// GetUserURLs получает страницу URL пользователя, фильтрация и пагинация выполняются в SQL
+func (r *PostgreSQLRepository) GetUserURLs(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	cursor, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	sqlQuery, args := userURLsSQL(userID, query, cursor)
+	rows, err := r.pool.Query(ctx, sqlQuery, args...)
+	if err != nil {
+		return UserURLsPage{}, fmt.Errorf("failed to query user urls: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []entity.URL
+	for rows.Next() {
+		url := entity.URL{UserID: userID}
+		if err := rows.Scan(&url.ID, &url.ShortURL, &url.OriginalURL, &url.CreatedAt,
+			&url.DeletedAt, &url.ExpiresAt); err != nil {
+			return UserURLsPage{}, fmt.Errorf("failed to scan row: %w", err)
 		}
+		url.CreatedAt = url.CreatedAt.UTC()
 
-		// Вставляем или обновляем по original_url
-		_, err = tx.Exec(context.Background(),
-			`INSERT INTO urls (short_url, original_url, user_id) 
-			 VALUES ($1, $2, $3)`,
-			shortURL, originalURL, userID)
-		if err != nil {
-			return fmt.Errorf("failed to upsert url: %v", err)
+		urls = append(urls, url)
+	}
+
+	if err = rows.Err(); err != nil {
+		return UserURLsPage{}, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	// Запрашивается на одну запись больше, чтобы узнать о наличии следующей страницы
+	var page UserURLsPage
+	if query.Limit > 0 && len(urls) > query.Limit {
+		urls = urls[:query.Limit]
+		page.NextCursor = encodeCursor(urls[len(urls)-1])
+	}
+	page.URLs = urls
+
+	clickCounts, err := r.clickCounts(ctx, pageShortURLs(page))
+	if err != nil {
+		return UserURLsPage{}, err
+	}
+	for i := range page.URLs {
+		page.URLs[i].ClickCount = clickCounts[page.URLs[i].ShortURL]
+	}
+	return page, nil
+}
+
+// clickCounts считает переходы одним запросом только по ссылкам страницы
+func (r *PostgreSQLRepository) clickCounts(ctx context.Context, shortURLs []string) (map[string]int, error) {
+	counts := make(map[string]int, len(shortURLs))
+	if len(shortURLs) == 0 {
+		return counts, nil
+	}
+
+	rows, err := r.pool.Query(ctx,
+		"SELECT short_url, COUNT(*) FROM clicks WHERE short_url = ANY($1) GROUP BY short_url", shortURLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count clicks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shortURL string
+		var count int
+		if err := rows.Scan(&shortURL, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
+		counts[shortURL] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
 	}
+	return counts, nil
+}
+
+// Хост оригинального URL, извлекается регулярным выражением для фильтра по домену
+const urlHostSQL = `lower(substring(u.original_url from '^[^:/?#]+://(?:[^@/?#]*@)?([^:/?#]+)'))`
+
+// userURLsSQL строит запрос страницы URL пользователя с keyset-пагинацией по (created_at, short_url)
+func userURLsSQL(userID string, query UserURLsQuery, cursor *pageCursor) (string, []any) {
+	args := []any{userID}
+	conditions := []string{"u.user_id = $1", "NOT u.is_deleted"}
+
+	if query.Contains != "" {
+		args = append(args, query.Contains)
+		conditions = append(conditions, fmt.Sprintf("strpos(lower(u.original_url), lower($%d)) > 0", len(args)))
+	}
+	if query.Domain != "" {
+		args = append(args, strings.ToLower(query.Domain))
+		conditions = append(conditions, fmt.Sprintf(
+			"(%[1]s = $%[2]d OR right(%[1]s, length($%[2]d) + 1) = '.' || $%[2]d)", urlHostSQL, len(args)))
+	}
+
+	direction, comparison := "DESC", "<"
+	if query.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+	if cursor != nil {
+		args = append(args, cursor.createdAt, cursor.shortURL)
+		conditions = append(conditions, fmt.Sprintf("(u.created_at, u.short_url) %s ($%d, $%d)", comparison, len(args)-1, len(args)))
+	}
+
+	sqlQuery := `SELECT u.id, u.short_url, u.original_url, u.created_at, u.deleted_at, u.expires_at
+		 FROM urls u
+		 WHERE ` + strings.Join(conditions, " AND ") + `
+		 ORDER BY u.created_at ` + direction + `, u.short_url ` + direction
+
+	if query.Limit > 0 {
+		args = append(args, query.Limit+1)
+		sqlQuery += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	return sqlQuery, args
+}
 
-	err = tx.Commit(context.Background())
+// DeleteUserURLs помечает удалёнными URL пользователя одним запросом
+func (r *PostgreSQLRepository) DeleteUserURLs(ctx context.Context, userID string, shortURLs []string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	_, err := r.pool.Exec(ctx,
+		`UPDATE urls SET is_deleted = TRUE, deleted_at = NOW()
+		 WHERE user_id = $1 AND short_url = ANY($2) AND NOT is_deleted`,
+		userID, shortURLs)
 	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return fmt.Errorf("failed to delete user urls: %w", err)
 	}
 
 	return nil
 }
 
-// GetUserURLs получает все URL пользователя
-func (r *PostgreSQLRepository) GetUserURLs(userID string) ([]map[string]string, error) {
-	rows, err := r.pool.Query(context.Background(),
-		"SELECT short_url, original_url FROM urls WHERE user_id = $1", userID)
+// CountURLs возвращает количество сохранённых URL
+func (r *PostgreSQLRepository) CountURLs(ctx context.Context) (int, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var count int
+	err := r.pool.QueryRow(ctx,
+		"SELECT COUNT(*) FROM urls WHERE NOT is_deleted").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count urls: %w", err)
+	}
+	return count, nil
+}
+
+// CountUsers возвращает количество уникальных пользователей
+func (r *PostgreSQLRepository) CountUsers(ctx context.Context) (int, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var count int
+	err := r.pool.QueryRow(ctx,
+		"SELECT COUNT(DISTINCT user_id) FROM urls WHERE NOT is_deleted AND user_id <> ''").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// PurgeExpired удаляет ссылки, срок действия которых истёк раньше указанного момента
+func (r *PostgreSQLRepository) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	tag, err := r.pool.Exec(ctx,
+		"DELETE FROM urls WHERE expires_at IS NOT NULL AND expires_at < $1", before)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query user urls: %v", err)
+		return 0, fmt.Errorf("failed to purge expired urls: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// GetUserID возвращает владельца короткого URL
+func (r *PostgreSQLRepository) GetUserID(ctx context.Context, shortURL string) (string, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var userID *string
+	err := r.pool.QueryRow(ctx,
+		"SELECT user_id FROM urls WHERE short_url = $1", shortURL).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get url owner: %w", err)
+	}
+
+	if userID == nil {
+		return "", nil
+	}
+	return *userID, nil
+}
+
+// SaveClicks сохраняет пакет событий переходов через COPY
+func (r *PostgreSQLRepository) SaveClicks(ctx context.Context, events []model.ClickEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	_, err := r.pool.CopyFrom(ctx,
+		pgx.Identifier{"clicks"},
+		[]string{"short_url", "clicked_at", "referrer", "user_agent", "ip_hash"},
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			event := events[i]
+			return []any{event.ShortURL, event.Timestamp, event.Referrer, event.UserAgent, event.IPHash}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("failed to save clicks: %w", err)
+	}
+
+	return nil
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL
+func (r *PostgreSQLRepository) GetClickStats(ctx context.Context, shortURL string) (model.ClickStats, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	var stats model.ClickStats
+	err := r.pool.QueryRow(ctx,
+		"SELECT COUNT(*), COUNT(DISTINCT ip_hash) FROM clicks WHERE short_url = $1", shortURL).
+		Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return model.ClickStats{}, fmt.Errorf("failed to count clicks: %w", err)
+	}
+
+	if stats.Hourly, err = r.clickBuckets(ctx, shortURL, "hour"); err != nil {
+		return model.ClickStats{}, err
+	}
+	if stats.Daily, err = r.clickBuckets(ctx, shortURL, "day"); err != nil {
+		return model.ClickStats{}, err
+	}
+
+	return stats, nil
+}
+
+// clickBuckets группирует переходы по интервалам заданной точности (hour, day) в UTC
+func (r *PostgreSQLRepository) clickBuckets(ctx context.Context, shortURL, precision string) ([]model.ClickBucket, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT date_trunc($2, clicked_at AT TIME ZONE 'UTC') AS bucket, COUNT(*)
+		 FROM clicks WHERE short_url = $1
+		 GROUP BY bucket ORDER BY bucket`,
+		shortURL, precision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query click buckets: %w", err)
 	}
 	defer rows.Close()
 
-	var urls []map[string]string
+	buckets := []model.ClickBucket{}
 	for rows.Next() {
-		var shortURL, originalURL string
-		if err := rows.Scan(&shortURL, &originalURL); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %v", err)
+		var bucket model.ClickBucket
+		if err := rows.Scan(&bucket.Start, &bucket.Clicks); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
-
-		urls = append(urls, map[string]string{
-			"short_url":    shortURL,
-			"original_url": originalURL,
-		})
+		buckets = append(buckets, bucket)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// readContext ограничивает контекст таймаутом на чтение
+func (r *PostgreSQLRepository) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeouts.Read)
+}
+
+// writeContext ограничивает контекст таймаутом на запись
+func (r *PostgreSQLRepository) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeouts.Write)
+}
+
+// withTimeout добавляет к контексту таймаут, если он задан
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isUniqueViolation проверяет, является ли ошибка нарушением уникального индекса
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+// conflictError различает занятый короткий ключ и уже сокращённый оригинальный URL
+func conflictError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName == shortURLIndex {
+		return ErrKeyExists
+	}
+	return ErrRowExists
+}
+
+// NextSequence возвращает следующее значение последовательности short_url_seq
+func (r *PostgreSQLRepository) NextSequence(ctx context.Context) (int64, error) {
+	ctx, cancel := r.writeContext(ctx)
+	defer cancel()
+
+	var value int64
+	if err := r.pool.QueryRow(ctx, "SELECT nextval('short_url_seq')").Scan(&value); err != nil {
+		return 0, fmt.Errorf("failed to get next sequence value: %w", err)
+	}
+	return value, nil
+}
+
+// Ping проверяет соединение с базой данных
+func (r *PostgreSQLRepository) Ping(ctx context.Context) error {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
 
-	return urls, nil
+	return r.pool.Ping(ctx)
 }
 
 // Close закрывает соединение с базой данных
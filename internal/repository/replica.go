@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+)
+
+// replicaEntry ссылка в локальной реплике
+type replicaEntry struct {
+	originalURL string
+	// canonicalURL известна только для ссылок, записанных через этот экземпляр
+	canonicalURL string
+	userID       string
+	expiresAt    *time.Time
+	redirect     model.RedirectOptions
+	deleted      bool
+}
+
+// replicaItem ссылка в реплике и её место в списке вытеснения
+type replicaItem struct {
+	entry replicaEntry
+	// element nil для закреплённой ссылки, которая не вытесняется
+	element *list.Element
+}
+
+// replica локальная копия ссылок для редиректов во время сбоя основного хранилища
+// Прочитанные и записанные ссылки вытесняются по LRU, когда их больше size,
+// а ссылки из журнала закреплены, пока журнал не перенесён в основное хранилище
+type replica struct {
+	size int
+
+	mu          sync.Mutex
+	order       *list.List // короткие URL незакреплённых ссылок, недавно использованные в начале
+	items       map[string]*replicaItem
+	byCanonical map[string]string // каноническая форма неудалённой ссылки -> короткий URL
+}
+
+// newReplica создаёт реплику на size незакреплённых ссылок
+func newReplica(size int) *replica {
+	return &replica{
+		size:        size,
+		order:       list.New(),
+		items:       make(map[string]*replicaItem),
+		byCanonical: make(map[string]string),
+	}
+}
+
+// get возвращает ссылку и поднимает её в начало списка вытеснения
+func (r *replica) get(shortURL string) (replicaEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[shortURL]
+	if !ok {
+		return replicaEntry{}, false
+	}
+	if item.element != nil {
+		r.order.MoveToFront(item.element)
+	}
+	return item.entry, true
+}
+
+// has сообщает, известна ли реплике ссылка
+func (r *replica) has(shortURL string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.items[shortURL]
+	return ok
+}
+
+// shortValue ищет короткий URL действующей ссылки по канонической форме
+func (r *replica) shortValue(canonicalURL string) (string, bool) {
+	if canonicalURL == "" {
+		return "", false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shortURL, ok := r.byCanonical[canonicalURL]
+	if !ok {
+		return "", false
+	}
+	if expiresAt := r.items[shortURL].entry.expiresAt; expiresAt != nil && !time.Now().Before(*expiresAt) {
+		return "", false
+	}
+	return shortURL, true
+}
+
+// put сохраняет ссылку, закреплённая ссылка остаётся закреплённой до unpinAll
+func (r *replica) put(shortURL string, entry replicaEntry, pinned bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[shortURL]
+	if !ok {
+		item = &replicaItem{}
+		r.items[shortURL] = item
+	} else {
+		r.unindexLocked(shortURL, item.entry)
+	}
+	item.entry = entry
+	r.indexLocked(shortURL, entry)
+
+	switch {
+	case pinned && item.element != nil:
+		r.order.Remove(item.element)
+		item.element = nil
+	case !pinned && item.element != nil:
+		r.order.MoveToFront(item.element)
+	case !pinned && !ok:
+		item.element = r.order.PushFront(shortURL)
+	}
+	r.evictLocked()
+}
+
+// update изменяет известную реплике ссылку, не меняя её места в списке вытеснения
+func (r *replica) update(shortURL string, change func(entry *replicaEntry)) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, ok := r.items[shortURL]
+	if !ok {
+		return false
+	}
+	r.unindexLocked(shortURL, item.entry)
+	change(&item.entry)
+	r.indexLocked(shortURL, item.entry)
+	return true
+}
+
+// remove удаляет ссылку из реплики
+func (r *replica) remove(shortURL string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(shortURL)
+}
+
+// purgeExpired удаляет ссылки, срок действия которых истёк раньше указанного момента
+func (r *replica) purgeExpired(before time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for shortURL, item := range r.items {
+		if item.entry.expiresAt != nil && item.entry.expiresAt.Before(before) {
+			r.removeLocked(shortURL)
+		}
+	}
+}
+
+// unpinAll возвращает закреплённые ссылки в список вытеснения после переноса журнала
+func (r *replica) unpinAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for shortURL, item := range r.items {
+		if item.element == nil {
+			item.element = r.order.PushFront(shortURL)
+		}
+	}
+	r.evictLocked()
+}
+
+// len возвращает количество ссылок в реплике
+func (r *replica) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.items)
+}
+
+// evictLocked вытесняет давно не использованные незакреплённые ссылки сверх размера
+func (r *replica) evictLocked() {
+	for r.order.Len() > r.size {
+		r.removeLocked(r.order.Back().Value.(string))
+	}
+}
+
+// removeLocked удаляет ссылку вместе с индексом и местом в списке вытеснения
+func (r *replica) removeLocked(shortURL string) {
+	item, ok := r.items[shortURL]
+	if !ok {
+		return
+	}
+	if item.element != nil {
+		r.order.Remove(item.element)
+	}
+	r.unindexLocked(shortURL, item.entry)
+	delete(r.items, shortURL)
+}
+
+// indexLocked добавляет неудалённую ссылку в индекс канонических форм
+func (r *replica) indexLocked(shortURL string, entry replicaEntry) {
+	if entry.canonicalURL != "" && !entry.deleted {
+		r.byCanonical[entry.canonicalURL] = shortURL
+	}
+}
+
+// unindexLocked убирает ссылку из индекса канонических форм
+func (r *replica) unindexLocked(shortURL string, entry replicaEntry) {
+	if r.byCanonical[entry.canonicalURL] == shortURL {
+		delete(r.byCanonical, entry.canonicalURL)
+	}
+}
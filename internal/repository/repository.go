@@ -1,17 +1,101 @@
 package repository
 
+import (
+	"context"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+)
+
 // URLRepository интерфейс для работы с URL
+// Все операции, кроме Close, принимают контекст запроса и прерываются при его отмене
 type URLRepository interface {
 	// GetFullValue получает оригинальный URL по короткому
-	GetFullValue(shortURL string) (string, error)
-	// GetShortValue получает короткий URL по оригинальному
-	GetShortValue(shortURL string) (string, error)
-	// SetValue сохраняет пару короткий URL - оригинальный URL с user_id
-	SetValue(shortURL, originalURL, userID string) error
+	GetFullValue(ctx context.Context, shortURL string) (string, error)
+	// GetLink получает оригинальный URL вместе со сроком действия и параметрами перенаправления
+	// Ошибки те же, что у GetFullValue
+	GetLink(ctx context.Context, shortURL string) (Link, error)
+	// GetShortValue получает короткий URL по канонической форме оригинального URL
+	GetShortValue(ctx context.Context, canonicalURL string) (string, error)
+	// GetShortValues получает короткие URL действующих ссылок по каноническим формам одним обращением
+	// В результате есть только найденные формы
+	GetShortValues(ctx context.Context, canonicalURLs []string) (map[string]string, error)
+	// GetExistingKeys возвращает короткие ключи из списка, занятые ссылками, в том числе удалёнными и истёкшими
+	GetExistingKeys(ctx context.Context, shortURLs []string) (map[string]struct{}, error)
+	// SetValue сохраняет пару короткий URL - оригинальный URL в присланном виде с user_id и необязательным сроком действия
+	// redirect задаёт параметры перенаправления, нулевой код означает код по умолчанию на момент перехода
+	// Повторы оригинальных URL определяются по канонической форме canonicalURL
+	// Занятый короткий ключ возвращает ErrKeyExists, уже сокращённый оригинальный URL — ErrRowExists
+	SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error
 	// SetValuesBatch сохраняет пакет пар короткий URL - оригинальный URL с user_id
-	SetValuesBatch(pairs map[string]string, userID string) error
-	// GetUserURLs получает все URL пользователя
-	GetUserURLs(userID string) ([]map[string]string, error)
+	// canonical содержит канонические формы по коротким URL, при её отсутствии канонической считается сама ссылка
+	// expiresAt содержит сроки действия по коротким URL, ссылки без срока в ней отсутствуют
+	// redirects содержит параметры перенаправления по коротким URL, ссылки с параметрами по умолчанию в ней отсутствуют
+	// Пакет сохраняется целиком или не сохраняется вовсе
+	SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error
+	// RestoreValue сохраняет ссылку, принятую раньше, с исходным моментом создания record.CreatedAt
+	// Ошибки те же, что у SetValue
+	RestoreValue(ctx context.Context, record model.URLRecord) error
+	// GetUserURLs получает страницу URL пользователя с фильтрами и сортировкой по дате создания
+	GetUserURLs(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error)
+	// DeleteUserURLs помечает удалёнными короткие URL, принадлежащие пользователю
+	DeleteUserURLs(ctx context.Context, userID string, shortURLs []string) error
+	// CountURLs возвращает количество сохранённых (не удалённых) URL
+	CountURLs(ctx context.Context) (int, error)
+	// CountUsers возвращает количество уникальных пользователей
+	CountUsers(ctx context.Context) (int, error)
+	// PurgeExpired удаляет ссылки, срок действия которых истёк раньше указанного момента
+	PurgeExpired(ctx context.Context, before time.Time) (int, error)
+	// GetUserID возвращает владельца короткого URL
+	GetUserID(ctx context.Context, shortURL string) (string, error)
+	// SaveClicks сохраняет пакет событий переходов
+	SaveClicks(ctx context.Context, events []model.ClickEvent) error
+	// GetClickStats возвращает статистику переходов по короткому URL
+	GetClickStats(ctx context.Context, shortURL string) (model.ClickStats, error)
+	// NextSequence возвращает следующее значение счётчика для последовательных коротких ключей
+	NextSequence(ctx context.Context) (int64, error)
+	// ReserveIdempotencyKey занимает ключ идемпотентности пользователя до срока record.ExpiresAt
+	// Если действующий ключ уже занят, возвращает сохранённую запись и ErrIdempotencyKeyExists
+	ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error)
+	// CompleteIdempotencyKey сохраняет ответ для занятого ключа идемпотентности
+	CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) error
+	// ReleaseIdempotencyKey освобождает ключ, ответ для которого не был сохранён
+	ReleaseIdempotencyKey(ctx context.Context, userID, key string) error
+	// Ping проверяет, что хранилище готово обслуживать запросы
+	// ErrDegraded означает, что хранилище работает в ограниченном режиме
+	Ping(ctx context.Context) error
 	// Close закрывает соединение с хранилищем
 	Close() error
 }
+
+// Link ссылка, по которой выполняется перенаправление
+type Link struct {
+	OriginalURL string
+	// ExpiresAt срок действия, nil для бессрочной ссылки. Нужен кешу, чтобы не отдавать ссылку после её истечения
+	ExpiresAt *time.Time
+	model.RedirectOptions
+}
+
+// canonicalOf возвращает каноническую форму ссылки из мапы или саму ссылку, если форма не задана
+func canonicalOf(canonical map[string]string, shortURL, originalURL string) string {
+	if value, ok := canonical[shortURL]; ok && value != "" {
+		return value
+	}
+	return originalURL
+}
+
+// createdAtOf возвращает момент создания записи или текущий момент, если он не задан
+func createdAtOf(record model.URLRecord) time.Time {
+	if record.CreatedAt != nil {
+		return record.CreatedAt.UTC()
+	}
+	return time.Now().UTC()
+}
+
+// timeRef возвращает указатель на момент из мапы или nil, если он не задан
+func timeRef(moments map[string]time.Time, key string) *time.Time {
+	if moment, ok := moments[key]; ok && !moment.IsZero() {
+		return &moment
+	}
+	return nil
+}
@@ -1,8 +1,21 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,11 +30,11 @@ func TestMemoryRepository(t *testing.T) {
 		userID := "user123"
 
 		// Записываем значение
-		err := repo.SetValue(key, value, userID)
+		err := repo.SetValue(context.Background(), key, value, value, userID, nil, model.RedirectOptions{})
 		assert.NoError(t, err)
 
 		// Получаем значение и проверяем
-		result, err := repo.GetFullValue(key)
+		result, err := repo.GetFullValue(context.Background(), key)
 		assert.NoError(t, err)
 		assert.Equal(t, value, result)
 	})
@@ -30,7 +43,7 @@ func TestMemoryRepository(t *testing.T) {
 		nonExistentKey := "nonExistentKey"
 
 		// Пытаемся получить несуществующий ключ
-		result, err := repo.GetFullValue(nonExistentKey)
+		result, err := repo.GetFullValue(context.Background(), nonExistentKey)
 		assert.Error(t, err)
 		assert.Equal(t, "", result)
 		assert.Equal(t, "not found key in database", err.Error())
@@ -43,16 +56,16 @@ func TestMemoryRepository(t *testing.T) {
 		userID := "user456"
 
 		// Первая запись
-		err := repo.SetValue(key, firstValue, userID)
+		err := repo.SetValue(context.Background(), key, firstValue, firstValue, userID, nil, model.RedirectOptions{})
 		assert.NoError(t, err)
-		firstResult, err := repo.GetFullValue(key)
+		firstResult, err := repo.GetFullValue(context.Background(), key)
 		assert.NoError(t, err)
 		assert.Equal(t, firstValue, firstResult)
 
 		// Перезаписываем
-		err = repo.SetValue(key, secondValue, userID)
+		err = repo.SetValue(context.Background(), key, secondValue, secondValue, userID, nil, model.RedirectOptions{})
 		assert.Error(t, err)
-		_, err = repo.GetFullValue(key)
+		_, err = repo.GetFullValue(context.Background(), key)
 		assert.NoError(t, err)
 	})
 
@@ -60,13 +73,1189 @@ func TestMemoryRepository(t *testing.T) {
 		userID := "user789"
 		
 		// Создаем несколько URL для пользователя
-		_ = repo.SetValue("key1", "https://example1.com", userID)
-		_ = repo.SetValue("key2", "https://example2.com", userID)
-		_ = repo.SetValue("key3", "https://example3.com", "anotherUser")
+		_ = repo.SetValue(context.Background(), "key1", "https://example1.com", "https://example1.com", userID, nil, model.RedirectOptions{})
+		_ = repo.SetValue(context.Background(), "key2", "https://example2.com", "https://example2.com", userID, nil, model.RedirectOptions{})
+		_ = repo.SetValue(context.Background(), "key3", "https://example3.com", "https://example3.com", "anotherUser", nil, model.RedirectOptions{})
 
 		// Получаем URL пользователя
-		userURLs, err := repo.GetUserURLs(userID)
+		userURLs, err := repo.GetUserURLs(context.Background(), userID, UserURLsQuery{})
 		assert.NoError(t, err)
-		assert.Equal(t, 2, len(userURLs))
+		assert.Equal(t, 2, len(userURLs.URLs))
+	})
+	t.Run("Delete user URLs", func(t *testing.T) {
+		_ = repo.SetValue(context.Background(), "del1", "https://delete1.com", "https://delete1.com", "owner", nil, model.RedirectOptions{})
+		_ = repo.SetValue(context.Background(), "del2", "https://delete2.com", "https://delete2.com", "stranger", nil, model.RedirectOptions{})
+
+		// Удаляем обе ссылки от имени владельца первой
+		err := repo.DeleteUserURLs(context.Background(), "owner", []string{"del1", "del2"})
+		assert.NoError(t, err)
+
+		// Своя ссылка удалена
+		_, err = repo.GetFullValue(context.Background(), "del1")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+
+		// Чужая ссылка не тронута
+		result, err := repo.GetFullValue(context.Background(), "del2")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://delete2.com", result)
+	})
+}
+
+func TestFileRepositoryDelete(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+
+	repo := NewFileRepository(filePath)
+	assert.NoError(t, repo.SetValue(context.Background(), "fdel1", "https://file-delete.com", "https://file-delete.com", "owner", nil, model.RedirectOptions{}))
+	assert.NoError(t, repo.DeleteUserURLs(context.Background(), "owner", []string{"fdel1"}))
+	assert.NoError(t, repo.Close())
+
+	// Признак удаления переживает перезапуск
+	reopened := NewFileRepository(filePath)
+	defer reopened.Close()
+
+	_, err := reopened.GetFullValue(context.Background(), "fdel1")
+	assert.ErrorIs(t, err, ErrURLDeleted)
+}
+
+func TestRepositoryShortKeys(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filePath),
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			assert.NoError(t, repo.SetValue(context.Background(), "taken", "https://taken.com", "https://taken.com", "user", nil, model.RedirectOptions{}))
+			assert.ErrorIs(t, repo.SetValue(context.Background(), "taken", "https://other.com", "https://other.com", "user", nil, model.RedirectOptions{}), ErrKeyExists)
+
+			// Пакет с занятым ключом не сохраняется целиком
+			err := repo.SetValuesBatch(context.Background(), map[string]string{
+				"fresh": "https://fresh.com",
+				"taken": "https://batch.com",
+			}, nil, "user", nil, nil)
+			assert.ErrorIs(t, err, ErrKeyExists)
+			_, err = repo.GetFullValue(context.Background(), "fresh")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			first, err := repo.NextSequence(context.Background())
+			assert.NoError(t, err)
+			second, err := repo.NextSequence(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, first+1, second)
+		})
+	}
+
+	t.Run("file sequence survives restart", func(t *testing.T) {
+		before, err := repos["file"].NextSequence(context.Background())
+		assert.NoError(t, err)
+
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		after, err := reopened.NextSequence(context.Background())
+		assert.NoError(t, err)
+		assert.Greater(t, after, before)
+	})
+}
+
+func TestRepositoryCanonicalURLs(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filePath),
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			// Хранится присланная запись, повтор определяется по канонической форме
+			assert.NoError(t, repo.SetValue(ctx, "canon", "HTTP://Example.com:80/a/./b", "http://example.com/a/b", "user", nil, model.RedirectOptions{}))
+			value, err := repo.GetFullValue(ctx, "canon")
+			assert.NoError(t, err)
+			assert.Equal(t, "HTTP://Example.com:80/a/./b", value)
+
+			assert.ErrorIs(t, repo.SetValue(ctx, "other", "http://EXAMPLE.com/a/b", "http://example.com/a/b", "user", nil, model.RedirectOptions{}), ErrRowExists)
+			shortURL, err := repo.GetShortValue(ctx, "http://example.com/a/b")
+			assert.NoError(t, err)
+			assert.Equal(t, "canon", shortURL)
+
+			// Пакет с уже сокращённой ссылкой или повтором внутри себя не сохраняется
+			err = repo.SetValuesBatch(ctx, map[string]string{
+				"fresh": "https://fresh.com",
+				"dup":   "http://example.com:80/a/b",
+			}, map[string]string{
+				"fresh": "https://fresh.com/",
+				"dup":   "http://example.com/a/b",
+			}, "user", nil, nil)
+			assert.ErrorIs(t, err, ErrRowExists)
+
+			err = repo.SetValuesBatch(ctx, map[string]string{
+				"twin1": "https://twin.com",
+				"twin2": "https://TWIN.com/",
+			}, map[string]string{
+				"twin1": "https://twin.com/",
+				"twin2": "https://twin.com/",
+			}, "user", nil, nil)
+			assert.ErrorIs(t, err, ErrRowExists)
+			_, err = repo.GetFullValue(ctx, "fresh")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+
+	t.Run("file keeps canonical form after restart", func(t *testing.T) {
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		shortURL, err := reopened.GetShortValue(ctx, "http://example.com/a/b")
+		assert.NoError(t, err)
+		assert.Equal(t, "canon", shortURL)
+	})
+}
+
+func TestRepositoryBulkLookups(t *testing.T) {
+	ctx := context.Background()
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filepath.Join(t.TempDir(), "urls.json")),
+	}
+	past := time.Now().Add(-time.Hour)
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			assert.NoError(t, repo.SetValue(ctx, "live", "https://live.com", "https://live.com/", "user", nil, model.RedirectOptions{}))
+			assert.NoError(t, repo.SetValue(ctx, "old", "https://old.com", "https://old.com/", "user", &past, model.RedirectOptions{}))
+			assert.NoError(t, repo.SetValue(ctx, "gone", "https://gone.com", "https://gone.com/", "user", nil, model.RedirectOptions{}))
+			assert.NoError(t, repo.DeleteUserURLs(ctx, "user", []string{"gone"}))
+
+			// Истёкшие и удалённые ссылки не занимают оригинальный URL
+			values, err := repo.GetShortValues(ctx, []string{"https://live.com/", "https://old.com/", "https://gone.com/", "https://none.com/"})
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{"https://live.com/": "live"}, values)
+
+			// Но их ключи остаются занятыми
+			keys, err := repo.GetExistingKeys(ctx, []string{"live", "old", "gone", "free"})
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]struct{}{"live": {}, "old": {}, "gone": {}}, keys)
+		})
+	}
+}
+
+func TestRepositoryReleasedCanonicalURLs(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filePath),
+	}
+	past := time.Now().Add(-2 * time.Hour)
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			// Удалённая ссылка освобождает оригинальный URL
+			assert.NoError(t, repo.SetValue(ctx, "gone", "https://gone.com", "https://gone.com/", "user", nil, model.RedirectOptions{}))
+			assert.NoError(t, repo.DeleteUserURLs(ctx, "user", []string{"gone"}))
+			_, err := repo.GetShortValue(ctx, "https://gone.com/")
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.NoError(t, repo.SetValue(ctx, "again", "https://gone.com", "https://gone.com/", "user", nil, model.RedirectOptions{}))
+
+			// Истёкшая ссылка освобождает оригинальный URL ещё до очистки
+			assert.NoError(t, repo.SetValue(ctx, "stale", "https://stale.com", "https://stale.com/", "user", &past, model.RedirectOptions{}))
+			_, err = repo.GetShortValue(ctx, "https://stale.com/")
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.NoError(t, repo.SetValuesBatch(ctx, map[string]string{"fresh": "https://stale.com"},
+				map[string]string{"fresh": "https://stale.com/"}, "user", nil, nil))
+			assert.ErrorIs(t, repo.SetValue(ctx, "third", "https://stale.com", "https://stale.com/", "user", nil, model.RedirectOptions{}), ErrRowExists)
+
+			// Истёкшая ссылка остаётся у владельца до очистки
+			_, err = repo.GetFullValue(ctx, "stale")
+			assert.ErrorIs(t, err, ErrURLExpired)
+
+			// Очистка истёкшей ссылки не освобождает URL, сокращённый заново
+			purged, err := repo.PurgeExpired(ctx, time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, 1, purged)
+			shortURL, err := repo.GetShortValue(ctx, "https://stale.com/")
+			assert.NoError(t, err)
+			assert.Equal(t, "fresh", shortURL)
+		})
+	}
+
+	t.Run("file keeps new links after restart", func(t *testing.T) {
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		shortURL, err := reopened.GetShortValue(ctx, "https://gone.com/")
+		assert.NoError(t, err)
+		assert.Equal(t, "again", shortURL)
+	})
+
+	t.Run("file snapshot prefers live link over expired one", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.json")
+		repo := NewFileRepository(path)
+		assert.NoError(t, repo.SetValue(ctx, "stale", "https://stale.com", "https://stale.com/", "user", &past, model.RedirectOptions{}))
+		assert.NoError(t, repo.SetValue(ctx, "fresh", "https://stale.com", "https://stale.com/", "user", nil, model.RedirectOptions{}))
+		assert.NoError(t, repo.Close())
+
+		// Порядок записей в снимке произвольный, поэтому проверяем несколько перезапусков
+		for i := 0; i < 5; i++ {
+			reopened := NewFileRepository(path)
+			shortURL, err := reopened.GetShortValue(ctx, "https://stale.com/")
+			assert.NoError(t, err)
+			assert.Equal(t, "fresh", shortURL)
+			assert.NoError(t, reopened.Close())
+		}
+	})
+}
+
+func TestFileRepositoryJournal(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("changes survive crash without close", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "urls.json")
+
+		repo := NewFileRepository(filePath)
+		assert.NoError(t, repo.SetValue(ctx, "j1", "https://journal1.com", "https://journal1.com", "owner", nil, model.RedirectOptions{}))
+		assert.NoError(t, repo.SetValuesBatch(ctx, map[string]string{"j2": "https://journal2.com"}, nil, "owner", nil, nil))
+		assert.NoError(t, repo.DeleteUserURLs(ctx, "owner", []string{"j2"}))
+
+		// Снимок не перезаписывается на каждую запись
+		_, err := os.Stat(filePath)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		value, err := reopened.GetFullValue(ctx, "j1")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://journal1.com", value)
+		_, err = reopened.GetFullValue(ctx, "j2")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+	})
+
+	t.Run("truncated last entry is ignored", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "urls.json")
+
+		repo := NewFileRepository(filePath)
+		assert.NoError(t, repo.SetValue(ctx, "whole", "https://whole.com", "https://whole.com", "owner", nil, model.RedirectOptions{}))
+
+		file, err := os.OpenFile(journalPath(filePath), os.O_APPEND|os.O_WRONLY, 0644)
+		assert.NoError(t, err)
+		_, err = file.WriteString(`{"op":"put","records":[{"short_url":"torn"`)
+		assert.NoError(t, err)
+		assert.NoError(t, file.Close())
+
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		_, err = reopened.GetFullValue(ctx, "whole")
+		assert.NoError(t, err)
+		_, err = reopened.GetFullValue(ctx, "torn")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("journal is compacted into snapshot", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "urls.json")
+
+		repo := NewFileRepositoryWithOptions(filePath, FileOptions{Sync: persistence.SyncNever, CompactThreshold: 3})
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, repo.SetValue(ctx, fmt.Sprintf("c%d", i), fmt.Sprintf("https://compact%d.com", i), fmt.Sprintf("https://compact%d.com", i), "owner", nil, model.RedirectOptions{}))
+		}
+
+		records, err := persistence.NewFileJSONPersistence().LoadRecords(filePath)
+		assert.NoError(t, err)
+		assert.Len(t, records, 3)
+
+		content, err := os.ReadFile(journalPath(filePath))
+		assert.NoError(t, err)
+		assert.Empty(t, content)
+
+		assert.NoError(t, repo.Ping(ctx))
+		assert.NoError(t, repo.Close())
+		assert.NoError(t, repo.Close())
+		assert.ErrorIs(t, repo.Ping(ctx), ErrClosed)
+	})
+}
+
+func TestRepositoryExpiry(t *testing.T) {
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filepath.Join(t.TempDir(), "urls.json")),
+		"cached": NewCachedRepository(NewMemoryRepository(), CacheOptions{Size: 10, TTL: time.Hour, NegativeTTL: time.Hour}),
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			past := time.Now().Add(-2 * time.Hour)
+			recent := time.Now().Add(-time.Minute)
+			future := time.Now().Add(time.Hour)
+
+			assert.NoError(t, repo.SetValue(context.Background(), "old", "https://old.com", "https://old.com", "user", &past, model.RedirectOptions{}))
+			assert.NoError(t, repo.SetValue(context.Background(), "recent", "https://recent.com", "https://recent.com", "user", &recent, model.RedirectOptions{}))
+			assert.NoError(t, repo.SetValuesBatch(context.Background(), map[string]string{"alive": "https://alive.com"}, nil, "user",
+				map[string]time.Time{"alive": future}, nil))
+
+			// Просроченные ссылки недоступны
+			_, err := repo.GetFullValue(context.Background(), "old")
+			assert.ErrorIs(t, err, ErrURLExpired)
+			_, err = repo.GetFullValue(context.Background(), "recent")
+			assert.ErrorIs(t, err, ErrURLExpired)
+
+			// Ссылка со сроком в будущем доступна
+			result, err := repo.GetFullValue(context.Background(), "alive")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://alive.com", result)
+
+			// Удаляются только ссылки, просроченные дольше часа
+			purged, err := repo.PurgeExpired(context.Background(), time.Now().Add(-time.Hour))
+			assert.NoError(t, err)
+			assert.Equal(t, 1, purged)
+
+			_, err = repo.GetFullValue(context.Background(), "old")
+			assert.EqualError(t, err, "not found key in database")
+			_, err = repo.GetFullValue(context.Background(), "recent")
+			assert.ErrorIs(t, err, ErrURLExpired)
+		})
+	}
+}
+
+func TestRepositoryRedirectOptions(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filePath),
+		"cached": NewCachedRepository(NewMemoryRepository(), CacheOptions{Size: 10, TTL: time.Hour, NegativeTTL: time.Hour}),
+	}
+
+	permanent := model.RedirectOptions{RedirectCode: http.StatusMovedPermanently}
+	passthrough := model.RedirectOptions{QueryMerge: model.QueryMergeAppend, PathPassthrough: true}
+	batch := model.RedirectOptions{RedirectCode: http.StatusPermanentRedirect, QueryMerge: model.QueryMergeKeep}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+			ctx := context.Background()
+
+			assert.NoError(t, repo.SetValue(ctx, "permanent", "https://permanent.com", "https://permanent.com", "user", nil, permanent))
+			assert.NoError(t, repo.SetValue(ctx, "passthrough", "https://passthrough.com", "https://passthrough.com", "user", nil, passthrough))
+			assert.NoError(t, repo.SetValue(ctx, "default", "https://default.com", "https://default.com", "user", nil, model.RedirectOptions{}))
+			assert.NoError(t, repo.SetValuesBatch(ctx, map[string]string{"batch": "https://batch.com"}, nil, "user",
+				nil, map[string]model.RedirectOptions{"batch": batch}))
+
+			// Параметры перенаправления сохраняются вместе со ссылкой, нулевые означают параметры по умолчанию
+			expected := map[string]model.RedirectOptions{"permanent": permanent, "passthrough": passthrough, "default": {}, "batch": batch}
+			for shortURL, options := range expected {
+				link, err := repo.GetLink(ctx, shortURL)
+				assert.NoError(t, err)
+				assert.Equal(t, options, link.RedirectOptions, shortURL)
+				assert.Nil(t, link.ExpiresAt)
+			}
+
+			_, err := repo.GetLink(ctx, "missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+
+	t.Run("file keeps options after restart", func(t *testing.T) {
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		link, err := reopened.GetLink(context.Background(), "permanent")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://permanent.com", link.OriginalURL)
+		assert.Equal(t, permanent, link.RedirectOptions)
+
+		link, err = reopened.GetLink(context.Background(), "passthrough")
+		assert.NoError(t, err)
+		assert.Equal(t, passthrough, link.RedirectOptions)
+	})
+}
+
+func TestRepositoryIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+	repos := map[string]URLRepository{
+		"memory":       NewMemoryRepository(),
+		"file":         NewFileRepository(filePath),
+		"instrumented": NewInstrumentedRepository(NewMemoryRepository(), BackendMemory),
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			record := model.IdempotencyRecord{Key: "key-1", UserID: "user", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+
+			_, err := repo.ReserveIdempotencyKey(ctx, record)
+			assert.NoError(t, err)
+
+			// Пока ответ не сохранён, повтор видит незавершённую запись
+			existing, err := repo.ReserveIdempotencyKey(ctx, record)
+			assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+			assert.False(t, existing.Completed())
+
+			// Ключи разных пользователей не пересекаются
+			other := record
+			other.UserID = "other"
+			_, err = repo.ReserveIdempotencyKey(ctx, other)
+			assert.NoError(t, err)
+
+			completed := record
+			completed.StatusCode = 201
+			completed.ContentType = "application/json"
+			completed.Body = []byte(`{"result":"abc"}`)
+			assert.NoError(t, repo.CompleteIdempotencyKey(ctx, completed))
+
+			existing, err = repo.ReserveIdempotencyKey(ctx, record)
+			assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+			assert.Equal(t, completed, existing)
+
+			// Сохранённый ответ не освобождается, незавершённый освобождается
+			assert.NoError(t, repo.ReleaseIdempotencyKey(ctx, "user", "key-1"))
+			_, err = repo.ReserveIdempotencyKey(ctx, record)
+			assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+
+			assert.NoError(t, repo.ReleaseIdempotencyKey(ctx, "other", "key-1"))
+			_, err = repo.ReserveIdempotencyKey(ctx, other)
+			assert.NoError(t, err)
+
+			// Просроченный ключ занимается заново
+			expired := model.IdempotencyRecord{Key: "key-2", UserID: "user", RequestHash: "old", ExpiresAt: time.Now().Add(-time.Second)}
+			_, err = repo.ReserveIdempotencyKey(ctx, expired)
+			assert.NoError(t, err)
+			fresh := expired
+			fresh.RequestHash = "new"
+			fresh.ExpiresAt = time.Now().Add(time.Hour)
+			_, err = repo.ReserveIdempotencyKey(ctx, fresh)
+			assert.NoError(t, err)
+
+			// Ключ запроса, который не завершился и не освободил его, занимается заново после удержания
+			stale := model.IdempotencyRecord{Key: "key-3", UserID: "user", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour),
+				LockedUntil: time.Now().Add(-time.Second)}
+			_, err = repo.ReserveIdempotencyKey(ctx, stale)
+			assert.NoError(t, err)
+			retry := stale
+			retry.LockedUntil = time.Time{}
+			_, err = repo.ReserveIdempotencyKey(ctx, retry)
+			assert.NoError(t, err)
+			existing, err = repo.ReserveIdempotencyKey(ctx, retry)
+			assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+			assert.False(t, existing.Completed())
+		})
+	}
+
+	t.Run("file keeps completed responses after restart", func(t *testing.T) {
+		reopened := NewFileRepository(filePath)
+		defer reopened.Close()
+
+		existing, err := reopened.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{Key: "key-1", UserID: "user", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour)})
+		assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+		assert.Equal(t, 201, existing.StatusCode)
+		assert.Equal(t, `{"result":"abc"}`, string(existing.Body))
+
+		// Незавершённые ключи после перезапуска не сохраняются
+		_, err = reopened.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{Key: "key-1", UserID: "other", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour)})
+		assert.NoError(t, err)
+	})
+
+	t.Run("file keeps responses from the journal after a crash", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.json")
+		repo := NewFileRepository(path)
+
+		record := model.IdempotencyRecord{Key: "crash", UserID: "user", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+		_, err := repo.ReserveIdempotencyKey(ctx, record)
+		assert.NoError(t, err)
+		record.StatusCode = 201
+		record.Body = []byte("ok")
+		assert.NoError(t, repo.CompleteIdempotencyKey(ctx, record))
+
+		// Ответ уже в журнале, снимок ещё не записан
+		_, err = os.Stat(filepath.Join(filepath.Dir(path), "urls.idempotency.json"))
+		assert.True(t, os.IsNotExist(err))
+
+		// Репозиторий не закрыт, как после падения процесса
+		reopened := NewFileRepository(path)
+		defer reopened.Close()
+		existing, err := reopened.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{Key: "crash", UserID: "user", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour)})
+		assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+		assert.Equal(t, "ok", string(existing.Body))
+	})
+}
+
+func TestRepositoryClicks(t *testing.T) {
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filepath.Join(t.TempDir(), "urls.json")),
+	}
+
+	base := time.Date(2025, 3, 10, 14, 20, 0, 0, time.UTC)
+	events := []model.ClickEvent{
+		{ShortURL: "clicked", Timestamp: base, IPHash: "a"},
+		{ShortURL: "clicked", Timestamp: base.Add(10 * time.Minute), IPHash: "a"},
+		{ShortURL: "clicked", Timestamp: base.Add(time.Hour), IPHash: "b"},
+		{ShortURL: "clicked", Timestamp: base.Add(24 * time.Hour), IPHash: "c"},
+		{ShortURL: "other", Timestamp: base, IPHash: "d"},
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			defer repo.Close()
+
+			assert.NoError(t, repo.SaveClicks(context.Background(), events))
+
+			stats, err := repo.GetClickStats(context.Background(), "clicked")
+			assert.NoError(t, err)
+			assert.Equal(t, 4, stats.TotalClicks)
+			assert.Equal(t, 3, stats.UniqueVisitors)
+
+			assert.Equal(t, []model.ClickBucket{
+				{Start: time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC), Clicks: 2},
+				{Start: time.Date(2025, 3, 10, 15, 0, 0, 0, time.UTC), Clicks: 1},
+				{Start: time.Date(2025, 3, 11, 14, 0, 0, 0, time.UTC), Clicks: 1},
+			}, stats.Hourly)
+			assert.Equal(t, []model.ClickBucket{
+				{Start: time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), Clicks: 3},
+				{Start: time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC), Clicks: 1},
+			}, stats.Daily)
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	t.Run("timeout sets deadline", func(t *testing.T) {
+		ctx, cancel := withTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+	})
+
+	t.Run("zero timeout keeps parent deadline", func(t *testing.T) {
+		ctx, cancel := withTimeout(context.Background(), 0)
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+
+		// Отмена по-прежнему доступна
+		cancel()
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	})
+}
+
+func TestRepositoryUserURLDetails(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "urls.json")
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filePath),
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			expiresAt := time.Now().Add(time.Hour).UTC()
+			before := time.Now().UTC().Add(-time.Second)
+
+			assert.NoError(t, repo.SetValue(ctx, "detail1", "https://details.com", "https://details.com", "owner", &expiresAt, model.RedirectOptions{}))
+			assert.NoError(t, repo.SaveClicks(ctx, []model.ClickEvent{
+				{ShortURL: "detail1", Timestamp: time.Now()},
+				{ShortURL: "detail1", Timestamp: time.Now()},
+			}))
+
+			page, err := repo.GetUserURLs(ctx, "owner", UserURLsQuery{})
+			assert.NoError(t, err)
+			if assert.Len(t, page.URLs, 1) {
+				url := page.URLs[0]
+				assert.Equal(t, "detail1", url.ShortURL)
+				assert.Equal(t, "https://details.com", url.OriginalURL)
+				assert.Equal(t, "owner", url.UserID)
+				assert.True(t, url.CreatedAt.After(before))
+				assert.Nil(t, url.DeletedAt)
+				if assert.NotNil(t, url.ExpiresAt) {
+					assert.True(t, expiresAt.Equal(*url.ExpiresAt))
+				}
+				assert.Equal(t, 2, url.ClickCount)
+			}
+
+			// Переходы, сохранённые после первого подсчёта, тоже учитываются
+			assert.NoError(t, repo.SaveClicks(ctx, []model.ClickEvent{{ShortURL: "detail1", Timestamp: time.Now()}}))
+			page, err = repo.GetUserURLs(ctx, "owner", UserURLsQuery{})
+			assert.NoError(t, err)
+			if assert.Len(t, page.URLs, 1) {
+				assert.Equal(t, 3, page.URLs[0].ClickCount)
+			}
+		})
+	}
+
+	// Даты создания и удаления переживают перезапуск файлового хранилища
+	t.Run("file persists dates", func(t *testing.T) {
+		ctx := context.Background()
+		repo := repos["file"]
+		assert.NoError(t, repo.DeleteUserURLs(ctx, "owner", []string{"detail1"}))
+		assert.NoError(t, repo.Close())
+
+		records, err := persistence.NewFileJSONPersistence().LoadRecords(filePath)
+		assert.NoError(t, err)
+		if assert.Len(t, records, 1) {
+			assert.NotNil(t, records[0].CreatedAt)
+			assert.NotNil(t, records[0].DeletedAt)
+			assert.True(t, records[0].IsDeleted)
+		}
+	})
+}
+
+func TestRepositoryUserURLsPagination(t *testing.T) {
+	repos := map[string]URLRepository{
+		"memory": NewMemoryRepository(),
+		"file":   NewFileRepository(filepath.Join(t.TempDir(), "urls.json")),
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			originals := []string{
+				"https://go.dev/doc",
+				"https://blog.go.dev/post",
+				"https://example.com/Go-Tour",
+				"https://example.org/other",
+				"https://notgo.dev/page",
+			}
+			for i, originalURL := range originals {
+				assert.NoError(t, repo.SetValue(ctx, fmt.Sprintf("page%d", i), originalURL, originalURL, "pager", nil, model.RedirectOptions{}))
+				time.Sleep(time.Millisecond)
+			}
+
+			// Обходим все страницы по две записи, новые записи первыми
+			var collected []string
+			query := UserURLsQuery{Limit: 2}
+			for pages := 0; pages < 10; pages++ {
+				page, err := repo.GetUserURLs(ctx, "pager", query)
+				assert.NoError(t, err)
+				assert.LessOrEqual(t, len(page.URLs), 2)
+				for _, url := range page.URLs {
+					collected = append(collected, url.ShortURL)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			assert.Equal(t, []string{"page4", "page3", "page2", "page1", "page0"}, collected)
+
+			page, err := repo.GetUserURLs(ctx, "pager", UserURLsQuery{Ascending: true, Limit: 1})
+			assert.NoError(t, err)
+			if assert.Len(t, page.URLs, 1) {
+				assert.Equal(t, "page0", page.URLs[0].ShortURL)
+			}
+
+			page, err = repo.GetUserURLs(ctx, "pager", UserURLsQuery{Contains: "go-tour"})
+			assert.NoError(t, err)
+			if assert.Len(t, page.URLs, 1) {
+				assert.Equal(t, "page2", page.URLs[0].ShortURL)
+			}
+
+			// Фильтр по домену учитывает поддомены, но не совпадения по суффиксу
+			page, err = repo.GetUserURLs(ctx, "pager", UserURLsQuery{Domain: "GO.dev", Ascending: true})
+			assert.NoError(t, err)
+			if assert.Len(t, page.URLs, 2) {
+				assert.Equal(t, "page0", page.URLs[0].ShortURL)
+				assert.Equal(t, "page1", page.URLs[1].ShortURL)
+			}
+
+			_, err = repo.GetUserURLs(ctx, "pager", UserURLsQuery{Cursor: "%%%"})
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestUserURLsSQL(t *testing.T) {
+	cursor := &pageCursor{createdAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), shortURL: "abc"}
+
+	query, args := userURLsSQL("user", UserURLsQuery{Limit: 10, Contains: "go", Domain: "go.dev"}, cursor)
+	assert.Contains(t, query, "strpos(lower(u.original_url), lower($2)) > 0")
+	assert.Contains(t, query, "(u.created_at, u.short_url) < ($4, $5)")
+	assert.Contains(t, query, "ORDER BY u.created_at DESC, u.short_url DESC LIMIT $6")
+	assert.NotContains(t, query, "clicks")
+	assert.Equal(t, []any{"user", "go", "go.dev", cursor.createdAt, "abc", 11}, args)
+
+	query, args = userURLsSQL("user", UserURLsQuery{Ascending: true}, nil)
+	assert.Contains(t, query, "ORDER BY u.created_at ASC, u.short_url ASC")
+	assert.NotContains(t, query, "LIMIT")
+	assert.Equal(t, []any{"user"}, args)
+}
+
+func TestInstrumentedRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInstrumentedRepository(NewMemoryRepository(), BackendMemory)
+
+	assert.NoError(t, repo.SetValue(ctx, "abc", "https://instrumented.com", "https://instrumented.com", "user1", nil, model.RedirectOptions{}))
+	assert.ErrorIs(t, repo.SetValue(ctx, "abc", "https://other.com", "https://other.com", "user1", nil, model.RedirectOptions{}), ErrKeyExists)
+
+	value, err := repo.GetFullValue(ctx, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://instrumented.com", value)
+
+	page, err := repo.GetUserURLs(ctx, "user1", UserURLsQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, page.URLs, 1)
+
+	t.Run("expected outcomes are not failures", func(t *testing.T) {
+		assert.False(t, isFailure(nil))
+		assert.False(t, isFailure(ErrNotFound))
+		assert.False(t, isFailure(fmt.Errorf("wrapped: %w", ErrRowExists)))
+		assert.False(t, isFailure(ErrURLDeleted))
+		assert.False(t, isFailure(ErrURLExpired))
+		assert.True(t, isFailure(context.DeadlineExceeded))
+	})
+}
+
+// countingRepository считает обращения к хранилищу за оригинальным URL
+type countingRepository struct {
+	URLRepository
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (r *countingRepository) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	r.calls.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	return r.URLRepository.GetLink(ctx, shortURL)
+}
+
+func TestCachedRepository(t *testing.T) {
+	ctx := context.Background()
+	options := CacheOptions{Size: 2, TTL: time.Hour, NegativeTTL: time.Minute}
+
+	t.Run("hits and negative caching", func(t *testing.T) {
+		inner := &countingRepository{URLRepository: NewMemoryRepository()}
+		repo := NewCachedRepository(inner, options)
+		assert.NoError(t, inner.SetValue(ctx, "abc", "https://cached.com", "https://cached.com", "user", nil, model.RedirectOptions{}))
+
+		for range 3 {
+			value, err := repo.GetFullValue(ctx, "abc")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://cached.com", value)
+
+			_, err = repo.GetFullValue(ctx, "missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+		}
+		assert.Equal(t, int32(2), inner.calls.Load())
+
+		// Запись сбрасывает закешированное отсутствие
+		assert.NoError(t, repo.SetValue(ctx, "missing", "https://created.com", "https://created.com", "user", nil, model.RedirectOptions{}))
+		value, err := repo.GetFullValue(ctx, "missing")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://created.com", value)
+
+		// Удаление сбрасывает найденную ссылку
+		assert.NoError(t, repo.DeleteUserURLs(ctx, "user", []string{"abc"}))
+		_, err = repo.GetFullValue(ctx, "abc")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+	})
+
+	t.Run("ttl and link expiry", func(t *testing.T) {
+		inner := &countingRepository{URLRepository: NewMemoryRepository()}
+		repo := NewCachedRepository(inner, options)
+		now := time.Now()
+		repo.now = func() time.Time { return now }
+
+		expiresAt := now.Add(time.Minute)
+		assert.NoError(t, repo.SetValue(ctx, "short", "https://short.com", "https://short.com", "user", &expiresAt, model.RedirectOptions{}))
+		assert.NoError(t, repo.SetValue(ctx, "long", "https://long.com", "https://long.com", "user", nil, model.RedirectOptions{}))
+
+		link, err := repo.GetLink(ctx, "short")
+		assert.NoError(t, err)
+		assert.WithinDuration(t, expiresAt, *link.ExpiresAt, 0)
+		_, err = repo.GetFullValue(ctx, "long")
+		assert.NoError(t, err)
+
+		// Запись о ссылке живёт не дольше самой ссылки, остальные до TTL
+		// Хранилище живёт по реальным часам, поэтому ссылка перечитывается и ещё доступна
+		now = now.Add(2 * time.Minute)
+		_, err = repo.GetLink(ctx, "short")
+		assert.NoError(t, err)
+		_, err = repo.GetFullValue(ctx, "long")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), inner.calls.Load())
+
+		now = now.Add(time.Hour)
+		_, err = repo.GetFullValue(ctx, "long")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(4), inner.calls.Load())
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		inner := &countingRepository{URLRepository: NewMemoryRepository()}
+		repo := NewCachedRepository(inner, options)
+		for _, key := range []string{"a", "b", "c"} {
+			assert.NoError(t, inner.SetValue(ctx, key, "https://"+key+".com", "https://"+key+".com", "user", nil, model.RedirectOptions{}))
+		}
+
+		_, _ = repo.GetFullValue(ctx, "a")
+		_, _ = repo.GetFullValue(ctx, "b")
+		_, _ = repo.GetFullValue(ctx, "a") // a становится недавно использованной
+		_, _ = repo.GetFullValue(ctx, "c") // вытесняет b
+		assert.Equal(t, 2, repo.Len())
+		assert.Equal(t, int32(3), inner.calls.Load())
+
+		_, _ = repo.GetFullValue(ctx, "a")
+		assert.Equal(t, int32(3), inner.calls.Load())
+		_, _ = repo.GetFullValue(ctx, "b")
+		assert.Equal(t, int32(4), inner.calls.Load())
+	})
+
+	t.Run("concurrent misses are collapsed", func(t *testing.T) {
+		inner := &countingRepository{URLRepository: NewMemoryRepository(), release: make(chan struct{})}
+		repo := NewCachedRepository(inner, options)
+		assert.NoError(t, inner.SetValue(ctx, "hot", "https://hot.com", "https://hot.com", "user", nil, model.RedirectOptions{}))
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := repo.GetFullValue(ctx, "hot")
+				assert.NoError(t, err)
+				assert.Equal(t, "https://hot.com", value)
+			}()
+		}
+
+		// Даём запросам дождаться общей загрузки
+		time.Sleep(50 * time.Millisecond)
+		close(inner.release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), inner.calls.Load())
+	})
+
+	t.Run("canceled request does not wait for load", func(t *testing.T) {
+		inner := &countingRepository{URLRepository: NewMemoryRepository(), release: make(chan struct{})}
+		repo := NewCachedRepository(inner, options)
+		assert.NoError(t, inner.SetValue(ctx, "slow", "https://slow.com", "https://slow.com", "user", nil, model.RedirectOptions{}))
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := repo.GetFullValue(canceled, "slow")
+		assert.ErrorIs(t, err, context.Canceled)
+
+		// Загрузка завершается и кеширует результат для следующих запросов
+		close(inner.release)
+		assert.Eventually(t, func() bool { return repo.Len() == 1 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+// errDatabaseDown имитирует недоступность базы данных
+var errDatabaseDown = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// flakyRepository хранилище, которое можно выключить
+type flakyRepository struct {
+	URLRepository
+	down atomic.Bool
+}
+
+func (r *flakyRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	if r.down.Load() {
+		return "", errDatabaseDown
+	}
+	return r.URLRepository.GetFullValue(ctx, shortURL)
+}
+
+func (r *flakyRepository) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	if r.down.Load() {
+		return Link{}, errDatabaseDown
+	}
+	return r.URLRepository.GetLink(ctx, shortURL)
+}
+
+func (r *flakyRepository) SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	if r.down.Load() {
+		return errDatabaseDown
+	}
+	return r.URLRepository.SetValue(ctx, shortURL, originalURL, canonicalURL, userID, expiresAt, redirect)
+}
+
+func (r *flakyRepository) SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
+	if r.down.Load() {
+		return errDatabaseDown
+	}
+	return r.URLRepository.SetValuesBatch(ctx, pairs, canonical, userID, expiresAt, redirects)
+}
+
+func (r *flakyRepository) DeleteUserURLs(ctx context.Context, userID string, shortURLs []string) error {
+	if r.down.Load() {
+		return errDatabaseDown
+	}
+	return r.URLRepository.DeleteUserURLs(ctx, userID, shortURLs)
+}
+
+func (r *flakyRepository) Ping(_ context.Context) error {
+	if r.down.Load() {
+		return errDatabaseDown
+	}
+	return nil
+}
+
+// failingRepository хранилище, запись в которое всегда завершается заданной ошибкой
+type failingRepository struct {
+	URLRepository
+	err error
+}
+
+func (r *failingRepository) SetValue(context.Context, string, string, string, string, *time.Time, model.RedirectOptions) error {
+	return r.err
+}
+
+func TestResilientRepository(t *testing.T) {
+	ctx := context.Background()
+	options := func(t *testing.T) ResilientOptions {
+		return ResilientOptions{SpoolPath: filepath.Join(t.TempDir(), "spool.jsonl"), ProbeInterval: 10 * time.Millisecond}
+	}
+
+	t.Run("failover and replay", func(t *testing.T) {
+		primary := &flakyRepository{URLRepository: NewMemoryRepository()}
+		repo, err := NewResilientRepository(primary, options(t))
+		assert.NoError(t, err)
+		defer repo.Close()
+
+		assert.NoError(t, repo.SetValue(ctx, "known", "https://known.com", "https://known.com", "user", nil, model.RedirectOptions{}))
+
+		primary.down.Store(true)
+
+		// Известная ссылка отдаётся из реплики
+		value, err := repo.GetFullValue(ctx, "known")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://known.com", value)
+		assert.False(t, repo.Healthy())
+
+		_, err = repo.GetFullValue(ctx, "unknown")
+		assert.ErrorIs(t, err, ErrUnavailable)
+		_, err = repo.GetUserURLs(ctx, "user", UserURLsQuery{})
+		assert.ErrorIs(t, err, ErrUnavailable)
+		_, err = repo.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{Key: "key", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)})
+		assert.ErrorIs(t, err, ErrUnavailable)
+
+		// Записи копятся в журнале
+		assert.NoError(t, repo.SetValue(ctx, "spooled", "https://spooled.com", "https://spooled.com", "user", nil, model.RedirectOptions{}))
+		assert.NoError(t, repo.SetValuesBatch(ctx, map[string]string{"batch": "https://batch.com"}, nil, "user", nil, nil))
+		assert.ErrorIs(t, repo.SetValue(ctx, "known", "https://other.com", "https://other.com", "user", nil, model.RedirectOptions{}), ErrKeyExists)
+
+		// Повтор ссылки из журнала находится по канонической форме
+		assert.ErrorIs(t, repo.SetValue(ctx, "again", "HTTPS://Spooled.com", "https://spooled.com", "user", nil, model.RedirectOptions{}), ErrRowExists)
+		shortURL, err := repo.GetShortValue(ctx, "https://spooled.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "spooled", shortURL)
+
+		assert.NoError(t, repo.DeleteUserURLs(ctx, "user", []string{"known"}))
+
+		value, err = repo.GetFullValue(ctx, "spooled")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://spooled.com", value)
+		_, err = repo.GetFullValue(ctx, "known")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+
+		_, err = primary.URLRepository.GetFullValue(ctx, "spooled")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, repo.Ping(ctx), ErrDegraded)
+
+		// После восстановления журнал переносится в базу
+		primary.down.Store(false)
+		assert.Eventually(t, repo.Healthy, time.Second, 5*time.Millisecond)
+		assert.NoError(t, repo.Ping(ctx))
+
+		value, err = primary.URLRepository.GetFullValue(ctx, "spooled")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://spooled.com", value)
+		_, err = primary.URLRepository.GetFullValue(ctx, "batch")
+		assert.NoError(t, err)
+		_, err = primary.URLRepository.GetFullValue(ctx, "known")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+	})
+
+	t.Run("spool survives restart", func(t *testing.T) {
+		primary := &flakyRepository{URLRepository: NewMemoryRepository()}
+		primary.down.Store(true)
+		opts := options(t)
+
+		repo, err := NewResilientRepository(primary, opts)
+		assert.NoError(t, err)
+		assert.NoError(t, repo.SetValue(ctx, "restart", "https://restart.com", "https://restart.com", "user", nil,
+			model.RedirectOptions{RedirectCode: http.StatusMovedPermanently, PathPassthrough: true}))
+		assert.NoError(t, repo.Close())
+
+		reopened, err := NewResilientRepository(primary, opts)
+		assert.NoError(t, err)
+		defer reopened.Close()
+
+		assert.False(t, reopened.Healthy())
+		link, err := reopened.GetLink(ctx, "restart")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://restart.com", link.OriginalURL)
+		assert.Equal(t, http.StatusMovedPermanently, link.RedirectCode)
+		assert.True(t, link.PathPassthrough)
+
+		// Параметры перенаправления переносятся в базу вместе со ссылкой
+		primary.down.Store(false)
+		assert.Eventually(t, reopened.Healthy, time.Second, 5*time.Millisecond)
+		link, err = primary.URLRepository.GetLink(ctx, "restart")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://restart.com", link.OriginalURL)
+		assert.Equal(t, http.StatusMovedPermanently, link.RedirectCode)
+		assert.True(t, link.PathPassthrough)
+	})
+
+	t.Run("replica evicts least recently used links", func(t *testing.T) {
+		primary := &flakyRepository{URLRepository: NewMemoryRepository()}
+		opts := options(t)
+		opts.ReplicaSize = 2
+		repo, err := NewResilientRepository(primary, opts)
+		assert.NoError(t, err)
+		defer repo.Close()
+
+		for _, key := range []string{"first", "second", "third"} {
+			assert.NoError(t, repo.SetValue(ctx, key, "https://"+key+".com", "https://"+key+".com", "user", nil, model.RedirectOptions{}))
+		}
+		// Чтение поднимает ссылку, поэтому вытесняется second, а не first
+		_, err = repo.GetFullValue(ctx, "first")
+		assert.NoError(t, err)
+		assert.NoError(t, repo.SetValue(ctx, "fourth", "https://fourth.com", "https://fourth.com", "user", nil, model.RedirectOptions{}))
+
+		primary.down.Store(true)
+		for _, key := range []string{"first", "fourth"} {
+			value, err := repo.GetFullValue(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, "https://"+key+".com", value)
+		}
+		for _, key := range []string{"second", "third"} {
+			_, err := repo.GetFullValue(ctx, key)
+			assert.ErrorIs(t, err, ErrUnavailable, key)
+		}
+
+		// Ссылки из журнала не вытесняются, пока журнал не перенесён
+		for _, key := range []string{"fifth", "sixth", "seventh"} {
+			assert.NoError(t, repo.SetValue(ctx, key, "https://"+key+".com", "https://"+key+".com", "user", nil, model.RedirectOptions{}))
+		}
+		assert.Equal(t, 5, repo.replica.len())
+		for _, key := range []string{"fifth", "sixth", "seventh"} {
+			_, err := repo.GetFullValue(ctx, key)
+			assert.NoError(t, err, key)
+		}
+		shortURL, err := repo.GetShortValue(ctx, "https://sixth.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "sixth", shortURL)
+
+		// После переноса журнала реплика снова ограничена размером
+		primary.down.Store(false)
+		assert.Eventually(t, repo.Healthy, time.Second, 5*time.Millisecond)
+		assert.Equal(t, 2, repo.replica.len())
+	})
+
+	t.Run("conflicts are resolved in favour of database", func(t *testing.T) {
+		primary := &flakyRepository{URLRepository: NewMemoryRepository()}
+		opts := options(t)
+		repo, err := NewResilientRepository(primary, opts)
+		assert.NoError(t, err)
+		defer repo.Close()
+
+		primary.down.Store(true)
+		assert.NoError(t, repo.SetValue(ctx, "taken", "https://spooled.com", "https://spooled.com", "user", nil, model.RedirectOptions{}))
+		assert.NoError(t, repo.SetValue(ctx, "free", "https://free.com", "https://free.com", "user", nil, model.RedirectOptions{}))
+
+		// Пока приложение работало на реплике, ключ занял другой экземпляр
+		assert.NoError(t, primary.URLRepository.SetValue(ctx, "taken", "https://database.com", "https://database.com", "other", nil, model.RedirectOptions{}))
+
+		time.Sleep(10 * time.Millisecond)
+		recoveredAt := time.Now()
+		primary.down.Store(false)
+		assert.Eventually(t, repo.Healthy, time.Second, 5*time.Millisecond)
+
+		// Перенесённая ссылка сохраняет момент создания из журнала
+		page, err := primary.URLRepository.GetUserURLs(ctx, "user", UserURLsQuery{})
+		assert.NoError(t, err)
+		if assert.Len(t, page.URLs, 1) {
+			assert.Equal(t, "free", page.URLs[0].ShortURL)
+			assert.True(t, page.URLs[0].CreatedAt.Before(recoveredAt))
+		}
+
+		// Отклонённая ссылка не теряется, а попадает в файл отклонённых записей
+		rejected, entries, err := persistence.OpenJournal(opts.SpoolPath+rejectedSuffix, persistence.JournalOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, rejected.Close())
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "taken", entries[0].Records[0].ShortURL)
+			assert.Equal(t, "https://spooled.com", entries[0].Records[0].OriginalURL)
+		}
+
+		value, err := repo.GetFullValue(ctx, "taken")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://database.com", value)
+		_, err = repo.GetFullValue(ctx, "free")
+		assert.NoError(t, err)
+	})
+
+	t.Run("expected errors do not trigger failover", func(t *testing.T) {
+		repo, err := NewResilientRepository(&flakyRepository{URLRepository: NewMemoryRepository()}, options(t))
+		assert.NoError(t, err)
+		defer repo.Close()
+
+		_, err = repo.GetFullValue(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		record := model.IdempotencyRecord{Key: "key", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+		_, err = repo.ReserveIdempotencyKey(ctx, record)
+		assert.NoError(t, err)
+		_, err = repo.ReserveIdempotencyKey(ctx, record)
+		assert.ErrorIs(t, err, ErrIdempotencyKeyExists)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = repo.GetUserURLs(canceled, "user", UserURLsQuery{})
+		assert.True(t, repo.Healthy())
+
+		// Повреждённый курсор от клиента не переводит хранилище в ограниченный режим
+		_, err = repo.GetUserURLs(ctx, "user", UserURLsQuery{Cursor: "garbage"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		assert.True(t, repo.Healthy())
+		assert.NoError(t, repo.Ping(ctx))
+	})
+
+	t.Run("constraint violations do not trigger failover", func(t *testing.T) {
+		for _, code := range []string{uniqueViolationCode, "23514"} {
+			primary := &failingRepository{
+				URLRepository: NewMemoryRepository(),
+				err:           fmt.Errorf("failed to insert url: %w", &pgconn.PgError{Code: code}),
+			}
+			repo, err := NewResilientRepository(primary, options(t))
+			assert.NoError(t, err)
+
+			err = repo.SetValue(ctx, "short", "https://short.com", "https://short.com", "user", nil, model.RedirectOptions{})
+			assert.Error(t, err)
+			assert.True(t, repo.Healthy(), code)
+			assert.NoError(t, repo.Close())
+		}
+	})
+
+	t.Run("connection errors trigger failover", func(t *testing.T) {
+		outages := []error{
+			errDatabaseDown,
+			fmt.Errorf("failed to insert url: %w", &pgconn.PgError{Code: "57P01"}),
+			fmt.Errorf("failed to insert url: %w", context.DeadlineExceeded),
+		}
+		for _, outage := range outages {
+			repo, err := NewResilientRepository(&failingRepository{URLRepository: NewMemoryRepository(), err: outage}, options(t))
+			assert.NoError(t, err)
+
+			// Запись уходит в журнал
+			assert.NoError(t, repo.SetValue(ctx, "short", "https://short.com", "https://short.com", "user", nil, model.RedirectOptions{}))
+			assert.False(t, repo.Healthy(), outage.Error())
+			assert.NoError(t, repo.Close())
+		}
 	})
 }
@@ -0,0 +1,713 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/persistence"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Параметры отказоустойчивого хранилища по умолчанию
+const (
+	defaultProbeInterval = 5 * time.Second
+	defaultReplicaSize   = 100000
+	// replayTimeout ограничение времени переноса одной записи журнала
+	replayTimeout = 10 * time.Second
+	// rejectedSuffix суффикс файла отклонённых записей рядом с журналом
+	rejectedSuffix = ".rejected"
+)
+
+// ResilientOptions параметры отказоустойчивого хранилища
+type ResilientOptions struct {
+	// SpoolPath путь к журналу записей, накопленных во время недоступности основного хранилища
+	SpoolPath string
+	// RejectedPath путь к файлу ссылок из журнала, которые не удалось перенести из-за конфликта с основным хранилищем
+	// По умолчанию файл лежит рядом с журналом
+	RejectedPath string
+	// ProbeInterval период проверки доступности основного хранилища
+	ProbeInterval time.Duration
+	// ReplicaSize максимальное количество прочитанных и записанных ссылок в реплике, лишние вытесняются по LRU
+	// Ссылки, созданные во время сбоя, хранятся в реплике независимо от ограничения
+	ReplicaSize int
+}
+
+// ResilientRepository декоратор, который продолжает обслуживать редиректы и создание ссылок при сбое основного хранилища
+// Прочитанные и записанные ссылки запоминаются в локальной реплике, из которой отдаются редиректы во время сбоя
+// Записи во время сбоя сохраняются в локальный журнал и переносятся в основное хранилище после его восстановления
+// Списки ссылок, статистика и переходы требуют основного хранилища: во время сбоя они возвращают ErrUnavailable
+// Ссылка, принятая во время сбоя, может не попасть в основное хранилище, если её ключ или URL успели занять
+// другие экземпляры. Такие ссылки перестают открываться и сохраняются в файл отклонённых записей для разбора
+type ResilientRepository struct {
+	primary URLRepository
+	options ResilientOptions
+	healthy atomic.Bool
+
+	// mu защищает журнал, очередь непереданных записей и переход в рабочее состояние
+	mu      sync.Mutex
+	spool   *persistence.Journal
+	pending []persistence.JournalEntry
+
+	// rejected ссылки, отклонённые основным хранилищем при переносе журнала, пишется только при переносе
+	rejected *persistence.Journal
+
+	replica *replica
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewResilientRepository оборачивает основное хранилище и запускает проверку его доступности
+// Записи, оставшиеся в журнале после прошлого запуска, переносятся в основное хранилище при первой проверке
+func NewResilientRepository(primary URLRepository, options ResilientOptions) (*ResilientRepository, error) {
+	if options.ProbeInterval <= 0 {
+		options.ProbeInterval = defaultProbeInterval
+	}
+	if options.ReplicaSize <= 0 {
+		options.ReplicaSize = defaultReplicaSize
+	}
+	if options.RejectedPath == "" {
+		options.RejectedPath = options.SpoolPath + rejectedSuffix
+	}
+
+	spool, pending, err := persistence.OpenJournal(options.SpoolPath, persistence.JournalOptions{Sync: persistence.SyncAlways})
+	if err != nil {
+		return nil, fmt.Errorf("failover spool: %w", err)
+	}
+	rejected, _, err := persistence.OpenJournal(options.RejectedPath, persistence.JournalOptions{Sync: persistence.SyncAlways})
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failover rejected records: %w", err)
+	}
+
+	repo := &ResilientRepository{
+		primary:  primary,
+		options:  options,
+		spool:    spool,
+		pending:  pending,
+		rejected: rejected,
+		replica:  newReplica(options.ReplicaSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, entry := range pending {
+		repo.applyToReplica(entry)
+	}
+	repo.healthy.Store(len(pending) == 0)
+
+	go repo.probe()
+	return repo, nil
+}
+
+// Healthy сообщает, обслуживает ли основное хранилище запросы
+func (r *ResilientRepository) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// failed проверяет, является ли ошибка сбоем основного хранилища, и переключает декоратор на реплику
+// Отмена запроса клиентом сбоем не считается
+func (r *ResilientRepository) failed(ctx context.Context, err error) bool {
+	if !isOutage(err) || ctx.Err() != nil {
+		return false
+	}
+	if r.healthy.CompareAndSwap(true, false) {
+		log.Printf("Основное хранилище недоступно: %v. Переходим на локальную реплику", err)
+	}
+	return true
+}
+
+// isOutage отделяет недоступность основного хранилища от ошибок самого запроса
+// На реплику переключают только ошибки соединения и таймауты: повреждённый курсор или нарушение ограничения
+// повторятся при любом состоянии базы, и по ним клиент не должен переводить сервис в ограниченный режим
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// 08 — ошибки соединения, 53 — нехватка ресурсов, 57P — остановка или перезапуск сервера
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "53") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+
+	var connectErr *pgconn.ConnectError
+	var opErr *net.OpError
+	return errors.As(err, &connectErr) || errors.As(err, &opErr) ||
+		pgconn.Timeout(err) || pgconn.SafeToRetry(err) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// GetFullValue получает оригинальный URL по короткому
+func (r *ResilientRepository) GetFullValue(ctx context.Context, shortURL string) (string, error) {
+	link, err := r.GetLink(ctx, shortURL)
+	return link.OriginalURL, err
+}
+
+// GetLink получает ссылку для перенаправления
+// Во время сбоя ссылка ищется в реплике, а неизвестная реплике ссылка возвращает ErrUnavailable
+func (r *ResilientRepository) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	if r.healthy.Load() {
+		link, err := r.primary.GetLink(ctx, shortURL)
+		if !r.failed(ctx, err) {
+			r.rememberRead(shortURL, link, err)
+			return link, err
+		}
+	}
+
+	entry, ok := r.replica.get(shortURL)
+
+	switch {
+	case !ok:
+		return Link{}, ErrUnavailable
+	case entry.deleted:
+		return Link{}, ErrURLDeleted
+	case entry.expiresAt != nil && !time.Now().Before(*entry.expiresAt):
+		return Link{}, ErrURLExpired
+	}
+	return Link{OriginalURL: entry.originalURL, ExpiresAt: entry.expiresAt, RedirectOptions: entry.redirect}, nil
+}
+
+// GetShortValue получает короткий URL по канонической форме, во время сбоя ищет его в реплике
+func (r *ResilientRepository) GetShortValue(ctx context.Context, canonicalURL string) (string, error) {
+	if !r.healthy.Load() {
+		if shortURL, ok := r.replica.shortValue(canonicalURL); ok {
+			return shortURL, nil
+		}
+		return "", ErrUnavailable
+	}
+
+	value, err := r.primary.GetShortValue(ctx, canonicalURL)
+	r.failed(ctx, err)
+	return value, err
+}
+
+// GetShortValues получает короткие URL по каноническим формам, во время сбоя ищет их в реплике
+func (r *ResilientRepository) GetShortValues(ctx context.Context, canonicalURLs []string) (map[string]string, error) {
+	if r.healthy.Load() {
+		values, err := r.primary.GetShortValues(ctx, canonicalURLs)
+		if !r.failed(ctx, err) {
+			return values, err
+		}
+	}
+
+	values := make(map[string]string)
+	for _, canonicalURL := range canonicalURLs {
+		if shortURL, ok := r.replica.shortValue(canonicalURL); ok {
+			values[canonicalURL] = shortURL
+		}
+	}
+	return values, nil
+}
+
+// GetExistingKeys возвращает занятые короткие ключи, во время сбоя известные реплике
+func (r *ResilientRepository) GetExistingKeys(ctx context.Context, shortURLs []string) (map[string]struct{}, error) {
+	if r.healthy.Load() {
+		keys, err := r.primary.GetExistingKeys(ctx, shortURLs)
+		if !r.failed(ctx, err) {
+			return keys, err
+		}
+	}
+
+	keys := make(map[string]struct{})
+	for _, shortURL := range shortURLs {
+		if r.replica.has(shortURL) {
+			keys[shortURL] = struct{}{}
+		}
+	}
+	return keys, nil
+}
+
+// SetValue сохраняет ссылку в основное хранилище, а во время сбоя в журнал
+func (r *ResilientRepository) SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	record := model.URLRecord{
+		ShortURL:        shortURL,
+		OriginalURL:     originalURL,
+		CanonicalURL:    canonicalURL,
+		UserID:          userID,
+		ExpiresAt:       expiresAt,
+		RedirectOptions: redirect,
+	}
+
+	if r.healthy.Load() {
+		err := r.primary.SetValue(ctx, shortURL, originalURL, canonicalURL, userID, expiresAt, redirect)
+		if !r.failed(ctx, err) {
+			if err == nil {
+				r.rememberWrite(record)
+			}
+			return err
+		}
+	}
+
+	return r.spoolRecords(ctx, []model.URLRecord{record})
+}
+
+// SetValuesBatch сохраняет пакет ссылок в основное хранилище, а во время сбоя в журнал
+func (r *ResilientRepository) SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
+	records := make([]model.URLRecord, 0, len(pairs))
+	for shortURL, originalURL := range pairs {
+		record := model.URLRecord{
+			ShortURL:        shortURL,
+			OriginalURL:     originalURL,
+			CanonicalURL:    canonicalOf(canonical, shortURL, originalURL),
+			UserID:          userID,
+			RedirectOptions: redirects[shortURL],
+		}
+		if expires, ok := expiresAt[shortURL]; ok {
+			record.ExpiresAt = &expires
+		}
+		records = append(records, record)
+	}
+
+	if r.healthy.Load() {
+		err := r.primary.SetValuesBatch(ctx, pairs, canonical, userID, expiresAt, redirects)
+		if !r.failed(ctx, err) {
+			if err == nil {
+				for _, record := range records {
+					r.rememberWrite(record)
+				}
+			}
+			return err
+		}
+	}
+
+	return r.spoolRecords(ctx, records)
+}
+
+// RestoreValue сохраняет ссылку с исходным моментом создания в основное хранилище, а во время сбоя в журнал
+func (r *ResilientRepository) RestoreValue(ctx context.Context, record model.URLRecord) error {
+	if r.healthy.Load() {
+		err := r.primary.RestoreValue(ctx, record)
+		if !r.failed(ctx, err) {
+			if err == nil {
+				r.rememberWrite(record)
+			}
+			return err
+		}
+	}
+
+	return r.spoolRecords(ctx, []model.URLRecord{record})
+}
+
+// spoolRecords сохраняет ссылки в журнал, занятость ключей и канонических форм проверяется по реплике
+// Совпадения с основным хранилищем, неизвестные реплике, обнаруживаются при переносе
+func (r *ResilientRepository) spoolRecords(ctx context.Context, records []model.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, record := range records {
+		if r.replica.has(record.ShortURL) {
+			return ErrKeyExists
+		}
+	}
+	for _, record := range records {
+		if _, ok := r.replica.shortValue(record.CanonicalURL); ok {
+			return ErrRowExists
+		}
+	}
+
+	now := time.Now().UTC()
+	for i := range records {
+		if records[i].CreatedAt == nil {
+			records[i].CreatedAt = &now
+		}
+	}
+	return r.spoolEntry(persistence.JournalEntry{Op: persistence.OpPut, Records: records})
+}
+
+// spoolEntry дописывает запись в журнал и применяет её к реплике, вызывается под блокировкой mu
+func (r *ResilientRepository) spoolEntry(entry persistence.JournalEntry) error {
+	if err := r.spool.Append(entry); err != nil {
+		return fmt.Errorf("failover spool: %w", err)
+	}
+	r.pending = append(r.pending, entry)
+	// Пока журнал не перенесён, запросы не должны обходить его и попадать в основное хранилище
+	r.healthy.Store(false)
+	r.applyToReplica(entry)
+	return nil
+}
+
+// GetUserURLs получает страницу URL пользователя
+func (r *ResilientRepository) GetUserURLs(ctx context.Context, userID string, query UserURLsQuery) (UserURLsPage, error) {
+	if !r.healthy.Load() {
+		return UserURLsPage{}, ErrUnavailable
+	}
+
+	page, err := r.primary.GetUserURLs(ctx, userID, query)
+	r.failed(ctx, err)
+	return page, err
+}
+
+// DeleteUserURLs помечает удалёнными URL пользователя, во время сбоя удаление сохраняется в журнал
+func (r *ResilientRepository) DeleteUserURLs(ctx context.Context, userID string, shortURLs []string) error {
+	entry := persistence.JournalEntry{Op: persistence.OpDelete, UserID: userID, ShortURLs: shortURLs}
+
+	if r.healthy.Load() {
+		err := r.primary.DeleteUserURLs(ctx, userID, shortURLs)
+		if !r.failed(ctx, err) {
+			if err == nil {
+				r.applyToReplica(entry)
+			}
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.spoolEntry(entry)
+}
+
+// CountURLs возвращает количество сохранённых URL
+func (r *ResilientRepository) CountURLs(ctx context.Context) (int, error) {
+	if !r.healthy.Load() {
+		return 0, ErrUnavailable
+	}
+
+	count, err := r.primary.CountURLs(ctx)
+	r.failed(ctx, err)
+	return count, err
+}
+
+// CountUsers возвращает количество уникальных пользователей
+func (r *ResilientRepository) CountUsers(ctx context.Context) (int, error) {
+	if !r.healthy.Load() {
+		return 0, ErrUnavailable
+	}
+
+	count, err := r.primary.CountUsers(ctx)
+	r.failed(ctx, err)
+	return count, err
+}
+
+// PurgeExpired удаляет просроченные ссылки из основного хранилища и реплики
+func (r *ResilientRepository) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	if !r.healthy.Load() {
+		return 0, ErrUnavailable
+	}
+
+	purged, err := r.primary.PurgeExpired(ctx, before)
+	if err != nil {
+		r.failed(ctx, err)
+		return purged, err
+	}
+
+	r.replica.purgeExpired(before)
+	return purged, nil
+}
+
+// GetUserID возвращает владельца короткого URL, во время сбоя владелец берётся из реплики
+func (r *ResilientRepository) GetUserID(ctx context.Context, shortURL string) (string, error) {
+	if r.healthy.Load() {
+		userID, err := r.primary.GetUserID(ctx, shortURL)
+		if !r.failed(ctx, err) {
+			return userID, err
+		}
+	}
+
+	entry, ok := r.replica.get(shortURL)
+
+	if !ok || entry.userID == "" {
+		return "", ErrUnavailable
+	}
+	return entry.userID, nil
+}
+
+// SaveClicks сохраняет события переходов, во время сбоя они не сохраняются
+func (r *ResilientRepository) SaveClicks(ctx context.Context, events []model.ClickEvent) error {
+	if !r.healthy.Load() {
+		return ErrUnavailable
+	}
+
+	err := r.primary.SaveClicks(ctx, events)
+	r.failed(ctx, err)
+	return err
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL
+func (r *ResilientRepository) GetClickStats(ctx context.Context, shortURL string) (model.ClickStats, error) {
+	if !r.healthy.Load() {
+		return model.ClickStats{}, ErrUnavailable
+	}
+
+	stats, err := r.primary.GetClickStats(ctx, shortURL)
+	r.failed(ctx, err)
+	return stats, err
+}
+
+// NextSequence возвращает следующее значение счётчика основного хранилища
+// Во время сбоя последовательные ключи не выдаются, чтобы не повторить значения счётчика
+func (r *ResilientRepository) NextSequence(ctx context.Context) (int64, error) {
+	if !r.healthy.Load() {
+		return 0, ErrUnavailable
+	}
+
+	value, err := r.primary.NextSequence(ctx)
+	r.failed(ctx, err)
+	return value, err
+}
+
+// ReserveIdempotencyKey занимает ключ идемпотентности в основном хранилище
+// Во время сбоя ключи не выдаются: сохранённый ответ нельзя сверить с локальной репликой
+func (r *ResilientRepository) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, error) {
+	if !r.healthy.Load() {
+		return model.IdempotencyRecord{}, ErrUnavailable
+	}
+
+	existing, err := r.primary.ReserveIdempotencyKey(ctx, record)
+	r.failed(ctx, err)
+	return existing, err
+}
+
+// CompleteIdempotencyKey сохраняет ответ для ключа идемпотентности в основном хранилище
+func (r *ResilientRepository) CompleteIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) error {
+	if !r.healthy.Load() {
+		return ErrUnavailable
+	}
+
+	err := r.primary.CompleteIdempotencyKey(ctx, record)
+	r.failed(ctx, err)
+	return err
+}
+
+// ReleaseIdempotencyKey освобождает ключ идемпотентности в основном хранилище
+func (r *ResilientRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	if !r.healthy.Load() {
+		return ErrUnavailable
+	}
+
+	err := r.primary.ReleaseIdempotencyKey(ctx, userID, key)
+	r.failed(ctx, err)
+	return err
+}
+
+// Ping проверяет основное хранилище, во время сбоя возвращает ErrDegraded
+// Неудачная проверка переключает декоратор на реплику так же, как сбой операции
+func (r *ResilientRepository) Ping(ctx context.Context) error {
+	if !r.healthy.Load() {
+		return ErrDegraded
+	}
+
+	err := r.primary.Ping(ctx)
+	if r.failed(ctx, err) {
+		return fmt.Errorf("%w: %v", ErrDegraded, err)
+	}
+	return err
+}
+
+// Close останавливает проверку доступности и закрывает журнал и основное хранилище
+// Непереданные записи остаются в журнале до следующего запуска
+func (r *ResilientRepository) Close() error {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+
+	r.mu.Lock()
+	spoolErr := r.spool.Close()
+	r.mu.Unlock()
+
+	// Перенос журнала уже остановлен, поэтому файл отклонённых записей больше никто не пишет
+	return errors.Join(spoolErr, r.rejected.Close(), r.primary.Close())
+}
+
+// probe периодически проверяет основное хранилище и переносит в него журнал после восстановления
+func (r *ResilientRepository) probe() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.options.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if !r.healthy.Load() {
+				r.recover()
+			}
+		}
+	}
+}
+
+// recover проверяет доступность основного хранилища и переносит в него записи журнала
+// Записи переносятся по порядку и без блокировки mu, чтобы запросы во время переноса продолжали уходить в журнал
+// При новом сбое или остановке оставшиеся записи ждут следующей проверки
+func (r *ResilientRepository) recover() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.ProbeInterval)
+	defer cancel()
+
+	if err := r.primary.Ping(ctx); err != nil {
+		return
+	}
+
+	replayed := 0
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		r.mu.Lock()
+		if len(r.pending) == 0 {
+			// Журнал очищается под блокировкой: новые записи не могут появиться между переносом и переключением
+			defer r.mu.Unlock()
+			if err := r.spool.Reset(); err != nil {
+				log.Printf("Ошибка очистки журнала %s: %v", r.options.SpoolPath, err)
+				return
+			}
+			r.pending = nil
+			r.replica.unpinAll()
+			r.healthy.Store(true)
+			log.Printf("Основное хранилище восстановлено, перенесено записей журнала: %d", replayed)
+			return
+		}
+		entry := r.pending[0]
+		r.mu.Unlock()
+
+		replayCtx, cancelReplay := context.WithTimeout(context.Background(), replayTimeout)
+		err := r.replay(replayCtx, entry)
+		cancelReplay()
+		if err != nil {
+			log.Printf("Перенос журнала в основное хранилище прерван: %v", err)
+			return
+		}
+
+		// Новые записи только дописываются в конец, поэтому первая запись очереди — перенесённая
+		r.mu.Lock()
+		r.pending = r.pending[1:]
+		r.mu.Unlock()
+		replayed++
+	}
+}
+
+// replay переносит запись журнала в основное хранилище
+// Запись может быть перенесена повторно, если прошлый перенос прервался, поэтому уже сохранённые ссылки пропускаются
+func (r *ResilientRepository) replay(ctx context.Context, entry persistence.JournalEntry) error {
+	switch entry.Op {
+	case persistence.OpPut:
+		for _, record := range entry.Records {
+			if err := r.replayRecord(ctx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case persistence.OpDelete:
+		return r.primary.DeleteUserURLs(ctx, entry.UserID, entry.ShortURLs)
+	}
+	return nil
+}
+
+// replayRecord переносит одну ссылку с исходным моментом создания, конфликты с основным хранилищем разрешаются в его пользу
+func (r *ResilientRepository) replayRecord(ctx context.Context, record model.URLRecord) error {
+	if record.CanonicalURL == "" {
+		record.CanonicalURL = record.OriginalURL
+	}
+	canonicalURL := record.CanonicalURL
+
+	err := r.primary.RestoreValue(ctx, record)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrKeyExists):
+		existing, getErr := r.primary.GetFullValue(ctx, record.ShortURL)
+		if isFailure(getErr) {
+			return getErr
+		}
+		if getErr == nil && existing == record.OriginalURL {
+			return nil
+		}
+	case errors.Is(err, ErrRowExists):
+		existing, getErr := r.primary.GetShortValue(ctx, canonicalURL)
+		if isFailure(getErr) {
+			return getErr
+		}
+		if getErr == nil && existing == record.ShortURL {
+			return nil
+		}
+	default:
+		return err
+	}
+
+	// Ключ занят другой ссылкой или URL уже сокращён под другим ключом: запись уходит в файл отклонённых записей
+	// Если её не удалось сохранить, перенос прерывается и запись остаётся в журнале
+	now := time.Now().UTC()
+	if appendErr := r.rejected.Append(persistence.JournalEntry{Op: persistence.OpPut, Records: []model.URLRecord{record}, At: &now}); appendErr != nil {
+		return fmt.Errorf("failover rejected records: %w", appendErr)
+	}
+	log.Printf("Ссылка %s -> %s пользователя %q не перенесена из журнала и сохранена в %s: %v",
+		record.ShortURL, record.OriginalURL, record.UserID, r.options.RejectedPath, err)
+	r.replica.remove(record.ShortURL)
+	return nil
+}
+
+// rememberRead обновляет реплику по результату чтения из основного хранилища
+func (r *ResilientRepository) rememberRead(shortURL string, link Link, err error) {
+	switch {
+	case err == nil:
+		entry, _ := r.replica.get(shortURL)
+		entry.originalURL = link.OriginalURL
+		entry.expiresAt = link.ExpiresAt
+		entry.redirect = link.RedirectOptions
+		entry.deleted = false
+		r.replica.put(shortURL, entry, false)
+	case errors.Is(err, ErrURLDeleted):
+		r.replica.update(shortURL, func(entry *replicaEntry) { entry.deleted = true })
+	case errors.Is(err, ErrNotFound):
+		r.replica.remove(shortURL)
+	}
+}
+
+// rememberWrite запоминает ссылку, сохранённую в основное хранилище
+func (r *ResilientRepository) rememberWrite(record model.URLRecord) {
+	r.replica.put(record.ShortURL, replicaEntryOf(record), false)
+}
+
+// applyToReplica применяет запись журнала к реплике
+// Ссылки из журнала закрепляются в реплике до его переноса в основное хранилище
+// Удаление применяется только к ссылкам, владелец которых известен реплике и совпадает с пользователем
+func (r *ResilientRepository) applyToReplica(entry persistence.JournalEntry) {
+	switch entry.Op {
+	case persistence.OpPut:
+		for _, record := range entry.Records {
+			r.replica.put(record.ShortURL, replicaEntryOf(record), true)
+		}
+	case persistence.OpDelete:
+		for _, shortURL := range entry.ShortURLs {
+			r.replica.update(shortURL, func(replicated *replicaEntry) {
+				if replicated.userID == entry.UserID {
+					replicated.deleted = true
+				}
+			})
+		}
+	}
+}
+
+// replicaEntryOf создаёт запись реплики по записи о ссылке
+func replicaEntryOf(record model.URLRecord) replicaEntry {
+	return replicaEntry{
+		originalURL:  record.OriginalURL,
+		canonicalURL: record.CanonicalURL,
+		userID:       record.UserID,
+		expiresAt:    record.ExpiresAt,
+		redirect:     record.RedirectOptions,
+	}
+}
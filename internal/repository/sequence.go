@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sequenceBlockSize количество значений счётчика, резервируемых одной записью в файл
+// После перезапуска недоиспользованный остаток блока пропускается
+const sequenceBlockSize = 100
+
+// sequenceFile счётчик последовательных ключей, сохраняемый рядом с основным хранилищем
+// В файле хранится граница зарезервированного блока, поэтому значения не повторяются после перезапуска
+type sequenceFile struct {
+	path     string
+	mu       sync.Mutex
+	next     int64
+	reserved int64
+}
+
+// newSequenceFile создаёт счётчик для файла с урлами: data/urls.json -> data/urls.seq
+func newSequenceFile(filePath string) *sequenceFile {
+	base := strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	return &sequenceFile{path: base + ".seq"}
+}
+
+// nextValue возвращает следующее значение, при исчерпании блока резервирует новый
+func (s *sequenceFile) nextValue() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == 0 || s.next > s.reserved {
+		start, err := s.load()
+		if err != nil {
+			return 0, err
+		}
+		if err := s.store(start + sequenceBlockSize); err != nil {
+			return 0, err
+		}
+		s.next = start + 1
+		s.reserved = start + sequenceBlockSize
+	}
+
+	value := s.next
+	s.next++
+	return value, nil
+}
+
+// load читает границу последнего зарезервированного блока
+func (s *sequenceFile) load() (int64, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("sequence file %s: %w", s.path, err)
+	}
+	return value, nil
+}
+
+// store атомарно записывает границу блока через временный файл
+func (s *sequenceFile) store(value int64) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(value, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// NextSequence возвращает следующее значение счётчика из файла
+func (r *FileRepository) NextSequence(_ context.Context) (int64, error) {
+	return r.sequence.nextValue()
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsureSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls", "cert.pem")
+	keyFile := filepath.Join(dir, "tls", "key.pem")
+
+	t.Run("generates loadable key pair", func(t *testing.T) {
+		err := ensureSelfSignedCert(certFile, keyFile)
+		assert.NoError(t, err)
+
+		_, err = tls.LoadX509KeyPair(certFile, keyFile)
+		assert.NoError(t, err)
+	})
+
+	t.Run("reuses cached certificate", func(t *testing.T) {
+		first, err := tls.LoadX509KeyPair(certFile, keyFile)
+		assert.NoError(t, err)
+
+		err = ensureSelfSignedCert(certFile, keyFile)
+		assert.NoError(t, err)
+
+		second, err := tls.LoadX509KeyPair(certFile, keyFile)
+		assert.NoError(t, err)
+		assert.Equal(t, first.Certificate, second.Certificate)
+	})
+}
@@ -2,30 +2,61 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/grpcserver"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Представляет HTTP сервер с graceful shutdown
 type Server struct {
-	httpServer *http.Server
-	service    *service.URLShortnerService
+	httpServer  *http.Server
+	service     *service.URLShortnerService
+	enableHTTPS bool
+	certFile    string
+	keyFile     string
+	sweeper     *expiredSweeper
+	// Конфигурация и gRPC API на отдельном адресе, grpcServer nil если он отключён
+	configuration *config.ConfigStruct
+	grpcServer    *grpc.Server
+	// Базовый контекст запросов, отменяется при остановке сервера
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
 }
 
 // Создаёт новый сервер
-func NewServer(addr string, handler http.Handler, service *service.URLShortnerService) *Server {
+func NewServer(configuration *config.ConfigStruct, handler http.Handler, service *service.URLShortnerService) *Server {
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+
 	return &Server{
 		httpServer: &http.Server{
-			Addr:    addr,
+			Addr:    configuration.Port,
 			Handler: handler,
+			// Контексты запросов наследуются от базового, чтобы остановка прерывала запросы к БД
+			BaseContext: func(net.Listener) context.Context {
+				return baseCtx
+			},
 		},
-		service: service,
+		service:     service,
+		enableHTTPS: configuration.EnableHTTPS,
+		certFile:    configuration.TLSCertFile,
+		keyFile:     configuration.TLSKeyFile,
+		sweeper: newExpiredSweeper(service,
+			time.Duration(configuration.ExpiredSweepInterval),
+			time.Duration(configuration.ExpiredRetention)),
+		baseCtx:       baseCtx,
+		cancelBase:    cancelBase,
+		configuration: configuration,
 	}
 }
 
@@ -35,10 +66,34 @@ func (s *Server) Start() error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	// Подготавливаем сертификат до запуска, чтобы ошибка вернулась вызывающему
+	if s.enableHTTPS {
+		if err := s.prepareTLS(); err != nil {
+			return err
+		}
+	}
+
+	// Запускаем gRPC API на отдельном адресе
+	if s.configuration.GRPCAddress != "" {
+		if err := s.startGRPC(); err != nil {
+			return err
+		}
+	}
+
+	// Запускаем фоновую очистку просроченных ссылок
+	s.sweeper.start()
+
 	// Запускаем сервер в отдельной горутине
 	go func() {
-		log.Printf("Сервер запущен на %s", s.httpServer.Addr)
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if s.enableHTTPS {
+			log.Printf("HTTPS сервер запущен на %s", s.httpServer.Addr)
+			err = s.httpServer.ListenAndServeTLS(s.certFile, s.keyFile)
+		} else {
+			log.Printf("Сервер запущен на %s", s.httpServer.Addr)
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Ошибка запуска сервера: %v", err)
 		}
 	}()
@@ -50,20 +105,99 @@ func (s *Server) Start() error {
 	return s.shutdown()
 }
 
+// prepareTLS определяет пути к сертификату и при необходимости генерирует самоподписанный
+func (s *Server) prepareTLS() error {
+	if s.certFile != "" || s.keyFile != "" {
+		return nil
+	}
+
+	s.certFile = config.DefaultTLSCertFile
+	s.keyFile = config.DefaultTLSKeyFile
+
+	log.Printf("Сертификат не задан, используем самоподписанный: %s", s.certFile)
+	return ensureSelfSignedCert(s.certFile, s.keyFile)
+}
+
+// startGRPC создаёт gRPC сервер и начинает принимать соединения
+func (s *Server) startGRPC() error {
+	var opts []grpc.ServerOption
+	if s.enableHTTPS {
+		creds, err := credentials.NewServerTLSFromFile(s.certFile, s.keyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	listener, err := net.Listen("tcp", s.configuration.GRPCAddress)
+	if err != nil {
+		return err
+	}
+
+	s.grpcServer = grpcserver.New(s.service, s.configuration, opts...)
+	go func() {
+		log.Printf("gRPC сервер запущен на %s", listener.Addr())
+		if err := s.grpcServer.Serve(listener); err != nil {
+			log.Fatalf("Ошибка запуска gRPC сервера: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopGRPC дожидается завершения gRPC вызовов, по истечении контекста прерывает их
+func (s *Server) stopGRPC(ctx context.Context) {
+	if s.grpcServer == nil {
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+	}
+}
+
 // graceful shutdown
 func (s *Server) shutdown() error {
+	// Снимаем готовность и даём балансировщику время перестать направлять к нам запросы
+	s.service.StartDraining()
+	if delay := time.Duration(s.configuration.ShutdownDrainDelay); delay > 0 {
+		log.Printf("Ожидание отключения от балансировщика: %s", delay)
+		time.Sleep(delay)
+	}
+
 	// Создаём контекст с таймаутом для shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Завершаем HTTP сервер
-	if err := s.httpServer.Shutdown(ctx); err != nil {
-		log.Printf("Ошибка при завершении сервера: %v", err)
-		return err
+	// Завершаем HTTP и gRPC серверы, после таймаута отменяем оставшиеся запросы к БД
+	// Ошибка завершения не прерывает остановку: накопленные данные всё равно сохраняются
+	s.stopGRPC(ctx)
+	shutdownErr := s.httpServer.Shutdown(ctx)
+	s.cancelBase()
+	if shutdownErr != nil {
+		log.Printf("Ошибка при завершении сервера: %v", shutdownErr)
 	}
 
+	// Останавливаем очистку просроченных ссылок
+	s.sweeper.stop()
+
+	// Сбрасываем накопленные удаления до закрытия репозитория
+	log.Println("Сохранение отложенных удалений...")
+	s.service.FlushDeletions()
+
+	// Сохраняем буфер событий переходов
+	log.Println("Сохранение событий переходов...")
+	s.service.FlushClicks()
+
 	// Закрываем соединение с репозиторием
-	return s.closeRepository()
+	return errors.Join(shutdownErr, s.closeRepository())
 }
 
 // Закрывает соединение с репозиторием
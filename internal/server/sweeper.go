@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
+)
+
+// expiredSweeper периодически удаляет ссылки, просроченные дольше срока хранения
+type expiredSweeper struct {
+	service   *service.URLShortnerService
+	interval  time.Duration
+	retention time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// newExpiredSweeper создаёт очистку просроченных ссылок, нулевой интервал её отключает
+func newExpiredSweeper(service *service.URLShortnerService, interval, retention time.Duration) *expiredSweeper {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &expiredSweeper{
+		service:   service,
+		interval:  interval,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+// start запускает фоновую очистку
+func (s *expiredSweeper) start() {
+	if s.interval <= 0 {
+		close(s.done)
+		return
+	}
+
+	go s.run()
+}
+
+// stop останавливает очистку, прерывая текущий проход, и дожидается его завершения
+func (s *expiredSweeper) stop() {
+	s.cancel()
+	<-s.done
+}
+
+// run основной цикл очистки
+func (s *expiredSweeper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep выполняет один проход очистки
+func (s *expiredSweeper) sweep() {
+	purged, err := s.service.PurgeExpiredURLs(s.ctx, s.retention)
+	if err != nil {
+		log.Printf("Ошибка очистки просроченных ссылок: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Удалено просроченных ссылок: %d", purged)
+	}
+}
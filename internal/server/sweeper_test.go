@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiredSweeper(t *testing.T) {
+	svc := service.NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+	defer svc.Close()
+
+	shortURL, err := svc.CreateShortURLWithOptions(context.Background(), "https://sweep.com", "user", model.LinkOptions{ExpiresIn: time.Millisecond})
+	assert.NoError(t, err)
+
+	sweeper := newExpiredSweeper(svc, 10*time.Millisecond, 0)
+	sweeper.start()
+
+	// Ждём, пока ссылка будет удалена фоновой очисткой
+	assert.Eventually(t, func() bool {
+		_, err := svc.GetFullURL(context.Background(), shortURL)
+		return err != nil && err.Error() == "not found"
+	}, time.Second, 10*time.Millisecond)
+
+	sweeper.stop()
+}
+
+func TestExpiredSweeperDisabled(t *testing.T) {
+	sweeper := newExpiredSweeper(nil, 0, 0)
+	sweeper.start()
+
+	// Остановка отключённой очистки не должна блокироваться
+	sweeper.stop()
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+)
+
+// aliasPolicy ограничения для пользовательских алиасов
+type aliasPolicy struct {
+	charset   string
+	minLength int
+	maxLength int
+	reserved  map[string]struct{}
+}
+
+// newAliasPolicy создаёт ограничения из конфигурации, незаданные значения берутся по умолчанию
+func newAliasPolicy(configuration *config.ConfigStruct) *aliasPolicy {
+	policy := &aliasPolicy{
+		charset:   config.DefaultAliasCharset,
+		minLength: config.DefaultAliasMinLength,
+		maxLength: config.DefaultAliasMaxLength,
+		reserved:  make(map[string]struct{}),
+	}
+
+	reserved := config.DefaultReservedAliases
+	if configuration != nil {
+		if configuration.AliasCharset != "" {
+			policy.charset = configuration.AliasCharset
+		}
+		if configuration.AliasMinLength > 0 {
+			policy.minLength = configuration.AliasMinLength
+		}
+		if configuration.AliasMaxLength > 0 {
+			policy.maxLength = configuration.AliasMaxLength
+		}
+		if configuration.ReservedAliases != nil {
+			reserved = configuration.ReservedAliases
+		}
+	}
+
+	for _, word := range reserved {
+		policy.reserved[strings.ToLower(word)] = struct{}{}
+	}
+
+	return policy
+}
+
+// validate проверяет алиас на длину, набор символов и зарезервированные слова
+func (p *aliasPolicy) validate(alias string) error {
+	length := utf8.RuneCountInString(alias)
+	if length < p.minLength || length > p.maxLength {
+		return fmt.Errorf("%w: length must be between %d and %d", ErrInvalidAlias, p.minLength, p.maxLength)
+	}
+
+	for _, r := range alias {
+		if !strings.ContainsRune(p.charset, r) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidAlias, r)
+		}
+	}
+
+	if p.isReserved(alias) {
+		// Имя занято маршрутом сервиса, поэтому ответ такой же, как для занятого алиаса
+		return fmt.Errorf("%w: %q is reserved", ErrAliasTaken, alias)
+	}
+
+	return nil
+}
+
+// isReserved проверяет, совпадает ли ключ с зарезервированным словом
+func (p *aliasPolicy) isReserved(key string) bool {
+	_, ok := p.reserved[strings.ToLower(key)]
+	return ok
+}
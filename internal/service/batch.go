@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+)
+
+// batchItem проверенный элемент пакета, ожидающий сохранения
+type batchItem struct {
+	index        int
+	originalURL  string
+	canonicalURL string
+	alias        string
+	expiresAt    *time.Time
+	redirect     model.RedirectOptions
+}
+
+// batch состояние пакетного создания ссылок
+type batch struct {
+	mode    model.BatchMode
+	results []model.BatchItemResult
+}
+
+// reject отмечает элемент некорректным, в режиме atomic ошибка отменяет весь пакет
+func (b *batch) reject(index int, err error) error {
+	if b.mode != model.BatchModeBestEffort {
+		return fmt.Errorf("item %d: %w", index, err)
+	}
+	b.results[index] = model.BatchItemResult{Status: model.BatchStatusInvalid, Err: err}
+	return nil
+}
+
+// CreateShortURLsBatch создает сокращенные URL для пакета URL для пользователя
+// Результаты возвращаются в порядке элементов пакета. Уже сокращённые ссылки, в том числе
+// повторы внутри пакета, получают статус exists с существующим ключом и пакет не отменяют
+func (u *URLShortnerService) CreateShortURLsBatch(ctx context.Context, items []model.ShortenItem, userID string, mode model.BatchMode) ([]model.BatchItemResult, error) {
+	b := &batch{mode: mode, results: make([]model.BatchItemResult, len(items))}
+	if len(items) == 0 {
+		return b.results, nil
+	}
+
+	// Ссылки, сроки действия и алиасы проверяем до генерации ключей, чтобы в режиме atomic не сохранять пакет частично
+	pending := make([]*batchItem, 0, len(items))
+	firstByCanonical := make(map[string]int)
+	duplicates := make(map[int]int)
+	for i, item := range items {
+		prepared, err := u.prepareBatchItem(ctx, i, item)
+		if err != nil {
+			if err := b.reject(i, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Повтор ссылки внутри пакета получает ключ первого вхождения
+		if first, ok := firstByCanonical[prepared.canonicalURL]; ok {
+			duplicates[i] = first
+			continue
+		}
+		firstByCanonical[prepared.canonicalURL] = i
+		pending = append(pending, prepared)
+	}
+
+	// Алиасы не должны повторяться внутри пакета. Занятые в хранилище алиасы и уже сокращённые ссылки
+	// определяются при записи, чтобы не проверять каждый элемент отдельным запросом
+	aliases := make(map[string]struct{})
+	free := pending[:0]
+	for _, item := range pending {
+		if item.alias != "" {
+			if _, repeated := aliases[item.alias]; repeated {
+				if err := b.reject(item.index, fmt.Errorf("%w: %q", ErrAliasTaken, item.alias)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			aliases[item.alias] = struct{}{}
+		}
+		free = append(free, item)
+	}
+	pending = free
+
+	if err := u.saveBatch(ctx, b, pending, userID); err != nil {
+		return nil, err
+	}
+
+	for i, first := range duplicates {
+		result := b.results[first]
+		if result.Status == model.BatchStatusCreated {
+			result.Status = model.BatchStatusExists
+		}
+		b.results[i] = result
+	}
+
+	return b.results, nil
+}
+
+// prepareBatchItem проверяет ссылку, срок действия и алиас элемента пакета
+func (u *URLShortnerService) prepareBatchItem(ctx context.Context, index int, item model.ShortenItem) (*batchItem, error) {
+	canonicalURL, err := u.urls.canonicalize(ctx, item.OriginalURL)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, err := resolveExpiry(item.Options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRedirect(item.Options.RedirectOptions); err != nil {
+		return nil, err
+	}
+	if alias := item.Options.Alias; alias != "" {
+		if err := u.aliases.validate(alias); err != nil {
+			return nil, err
+		}
+	}
+
+	return &batchItem{
+		index:        index,
+		originalURL:  item.OriginalURL,
+		canonicalURL: canonicalURL,
+		alias:        item.Options.Alias,
+		expiresAt:    expiresAt,
+		redirect:     item.Options.RedirectOptions,
+	}, nil
+}
+
+// skipExisting отмечает уже сокращённые ссылки и возвращает оставшиеся, хранилище опрашивается одним запросом
+func (u *URLShortnerService) skipExisting(ctx context.Context, b *batch, pending []*batchItem) ([]*batchItem, error) {
+	canonicalURLs := make([]string, len(pending))
+	for i, item := range pending {
+		canonicalURLs[i] = item.canonicalURL
+	}
+	existing, err := u.Repository.GetShortValues(ctx, canonicalURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := pending[:0]
+	for _, item := range pending {
+		if shortURL, ok := existing[item.canonicalURL]; ok {
+			b.results[item.index] = model.BatchItemResult{Status: model.BatchStatusExists, ShortURL: shortURL}
+			continue
+		}
+		rest = append(rest, item)
+	}
+	return rest, nil
+}
+
+// saveBatch сохраняет элементы пакета одной записью
+// Занятые ключи и уже сокращённые ссылки определяются по ошибке записи: ключи генерируются заново,
+// а уже сокращённые ссылки и занятые алиасы исключаются
+func (u *URLShortnerService) saveBatch(ctx context.Context, b *batch, pending []*batchItem, userID string) error {
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == maxKeyAttempts {
+			return ErrKeyGeneration
+		}
+
+		keys, err := u.batchKeys(ctx, pending)
+		if err != nil {
+			return err
+		}
+
+		pairs := make(map[string]string, len(pending))
+		canonical := make(map[string]string, len(pending))
+		expires := make(map[string]time.Time)
+		redirects := make(map[string]model.RedirectOptions)
+		for i, item := range pending {
+			pairs[keys[i]] = item.originalURL
+			canonical[keys[i]] = item.canonicalURL
+			if item.expiresAt != nil {
+				expires[keys[i]] = *item.expiresAt
+			}
+			if item.redirect != (model.RedirectOptions{}) {
+				redirects[keys[i]] = item.redirect
+			}
+		}
+
+		err = u.Repository.SetValuesBatch(ctx, pairs, canonical, userID, expires, redirects)
+		switch {
+		case err == nil:
+			for i, item := range pending {
+				b.results[item.index] = model.BatchItemResult{Status: model.BatchStatusCreated, ShortURL: keys[i]}
+			}
+			metrics.AddLinksCreated(metrics.KindBatch, len(pending))
+			return nil
+		case errors.Is(err, repository.ErrKeyExists):
+			pending, err = u.dropTakenAliases(ctx, b, pending)
+		case errors.Is(err, repository.ErrRowExists):
+			before := len(pending)
+			pending, err = u.skipExisting(ctx, b, pending)
+			if err == nil && len(pending) == before {
+				// Ссылку, с которой конфликтует пакет, не удалось найти: её заняли и освободили параллельно
+				return u.resolveConflicts(ctx, b, pending, userID)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveConflicts сохраняет элементы по одному, когда конфликт пакета не удалось связать с элементом
+// В режиме atomic пакет отменяется, в режиме best_effort конфликтующие элементы получают статус conflict
+func (u *URLShortnerService) resolveConflicts(ctx context.Context, b *batch, pending []*batchItem, userID string) error {
+	if b.mode != model.BatchModeBestEffort {
+		return ErrBatchConflict
+	}
+
+	for _, item := range pending {
+		result, err := u.saveItem(ctx, item, userID)
+		if err != nil {
+			return err
+		}
+		b.results[item.index] = result
+	}
+	return nil
+}
+
+// saveItem сохраняет элемент пакета отдельной записью, конфликт определяется по ошибке записи
+func (u *URLShortnerService) saveItem(ctx context.Context, item *batchItem, userID string) (model.BatchItemResult, error) {
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		key := item.alias
+		if key == "" {
+			var err error
+			if key, err = u.nextKey(ctx); err != nil {
+				return model.BatchItemResult{}, err
+			}
+		}
+
+		err := u.Repository.SetValue(ctx, key, item.originalURL, item.canonicalURL, userID, item.expiresAt, item.redirect)
+		switch {
+		case err == nil:
+			metrics.AddLinksCreated(metrics.KindBatch, 1)
+			return model.BatchItemResult{Status: model.BatchStatusCreated, ShortURL: key}, nil
+		case errors.Is(err, repository.ErrKeyExists) && item.alias != "":
+			return model.BatchItemResult{Status: model.BatchStatusInvalid, Err: fmt.Errorf("%w: %q", ErrAliasTaken, item.alias)}, nil
+		case errors.Is(err, repository.ErrKeyExists):
+			continue
+		case errors.Is(err, repository.ErrRowExists):
+			if shortURL, getErr := u.Repository.GetShortValue(ctx, item.canonicalURL); getErr == nil {
+				return model.BatchItemResult{Status: model.BatchStatusExists, ShortURL: shortURL}, nil
+			}
+			return model.BatchItemResult{Status: model.BatchStatusConflict, Err: ErrBatchConflict}, nil
+		default:
+			return model.BatchItemResult{}, err
+		}
+	}
+	return model.BatchItemResult{}, ErrKeyGeneration
+}
+
+// batchKeys подбирает ключи для элементов пакета, сгенерированные ключи не повторяются внутри пакета
+func (u *URLShortnerService) batchKeys(ctx context.Context, pending []*batchItem) ([]string, error) {
+	keys := make([]string, len(pending))
+	used := make(map[string]struct{}, len(pending))
+	for i, item := range pending {
+		if item.alias != "" {
+			keys[i] = item.alias
+			used[item.alias] = struct{}{}
+		}
+	}
+
+	for i, item := range pending {
+		if item.alias != "" {
+			continue
+		}
+		for {
+			key, err := u.nextKey(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := used[key]; !exists {
+				keys[i] = key
+				used[key] = struct{}{}
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// dropTakenAliases исключает элементы с занятыми алиасами, хранилище опрашивается одним запросом
+// Если все алиасы свободны, конфликт вызвал сгенерированный ключ, и он будет сгенерирован заново
+func (u *URLShortnerService) dropTakenAliases(ctx context.Context, b *batch, pending []*batchItem) ([]*batchItem, error) {
+	var aliases []string
+	for _, item := range pending {
+		if item.alias != "" {
+			aliases = append(aliases, item.alias)
+		}
+	}
+	taken, err := u.Repository.GetExistingKeys(ctx, aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := pending[:0]
+	for _, item := range pending {
+		if _, ok := taken[item.alias]; ok && item.alias != "" {
+			if err := b.reject(item.index, fmt.Errorf("%w: %q", ErrAliasTaken, item.alias)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rest = append(rest, item)
+	}
+	return rest, nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+)
+
+// Количество событий, при накоплении которого пакет сбрасывается досрочно
+const clickBatchSize = 500
+
+// clickRecorder буферизует события переходов и сохраняет их пакетами в фоне
+type clickRecorder struct {
+	repo     repository.URLRepository
+	events   chan model.ClickEvent
+	interval time.Duration
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+	once     sync.Once
+	dropped  atomic.Int64
+}
+
+// newClickRecorder создаёт и запускает фоновую запись событий
+func newClickRecorder(repo repository.URLRepository, bufferSize int, interval time.Duration) *clickRecorder {
+	r := &clickRecorder{
+		repo:     repo,
+		events:   make(chan model.ClickEvent, bufferSize),
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// record ставит событие в буфер без ожидания, при переполнении событие отбрасывается
+func (r *clickRecorder) record(event model.ClickEvent) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return
+	}
+
+	select {
+	case r.events <- event:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// stop закрывает буфер и дожидается сохранения накопленных событий
+func (r *clickRecorder) stop() {
+	r.once.Do(func() {
+		r.mu.Lock()
+		r.closed = true
+		close(r.events)
+		r.mu.Unlock()
+	})
+	<-r.done
+}
+
+// run основной цикл записи
+func (r *clickRecorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	batch := make([]model.ClickEvent, 0, clickBatchSize)
+	for {
+		select {
+		case event, ok := <-r.events:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= clickBatchSize {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush сохраняет пакет событий в репозиторий
+func (r *clickRecorder) flush(batch []model.ClickEvent) {
+	if dropped := r.dropped.Swap(0); dropped > 0 {
+		log.Printf("Буфер переходов переполнен, отброшено событий: %d", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := r.repo.SaveClicks(context.Background(), batch); err != nil {
+		log.Printf("Ошибка сохранения переходов: %v", err)
+	}
+}
+
+// hashIP возвращает HMAC адреса клиента, чтобы не хранить IP в открытом виде
+func hashIP(secret, ip string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ip))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+)
+
+const (
+	// Размер буфера очереди задач на удаление
+	deleteQueueSize = 1024
+	// Количество ID, при накоплении которого пакет сбрасывается досрочно
+	deleteBatchSize = 100
+	// Период принудительного сброса накопленных удалений
+	deleteFlushInterval = time.Second
+)
+
+// deleteTask задача на удаление URL пользователя
+type deleteTask struct {
+	userID    string
+	shortURLs []string
+}
+
+// deleteWorker накапливает задачи на удаление и сбрасывает их в репозиторий пакетами
+type deleteWorker struct {
+	repo   repository.URLRepository
+	tasks  chan deleteTask
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+	once   sync.Once
+}
+
+// newDeleteWorker создаёт и запускает фоновый обработчик удалений
+func newDeleteWorker(repo repository.URLRepository) *deleteWorker {
+	w := &deleteWorker{
+		repo:  repo,
+		tasks: make(chan deleteTask, deleteQueueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue ставит задачу в очередь без ожидания
+// Блокировка нужна только против отправки в закрытый канал, поэтому при полной очереди задача сразу отклоняется
+func (w *deleteWorker) enqueue(task deleteTask) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrDeleteQueueClosed
+	}
+	select {
+	case w.tasks <- task:
+		return nil
+	default:
+		return ErrDeleteQueueFull
+	}
+}
+
+// stop закрывает очередь и дожидается сброса всех накопленных удалений
+func (w *deleteWorker) stop() {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.tasks)
+		w.mu.Unlock()
+	})
+	<-w.done
+}
+
+// run основной цикл обработчика
+func (w *deleteWorker) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(deleteFlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string][]string) // userID -> shortURLs
+	count := 0
+
+	for {
+		select {
+		case task, ok := <-w.tasks:
+			if !ok {
+				w.flush(pending)
+				return
+			}
+			pending[task.userID] = append(pending[task.userID], task.shortURLs...)
+			count += len(task.shortURLs)
+			if count >= deleteBatchSize {
+				w.flush(pending)
+				pending = make(map[string][]string)
+				count = 0
+			}
+		case <-ticker.C:
+			if count > 0 {
+				w.flush(pending)
+				pending = make(map[string][]string)
+				count = 0
+			}
+		}
+	}
+}
+
+// flush сохраняет накопленные удаления, по одному запросу на пользователя
+func (w *deleteWorker) flush(pending map[string][]string) {
+	for userID, shortURLs := range pending {
+		if err := w.repo.DeleteUserURLs(context.Background(), userID, shortURLs); err != nil {
+			log.Printf("Ошибка удаления URL пользователя %s: %v", userID, err)
+		}
+	}
+}
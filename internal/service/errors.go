@@ -0,0 +1,39 @@
+package service
+
+import "errors"
+
+// ErrInvalidAlias ошибка, которая возникает при недопустимом алиасе
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// ErrAliasTaken ошибка, которая возникает, когда алиас уже занят
+var ErrAliasTaken = errors.New("alias is already taken")
+
+// ErrInvalidExpiry ошибка, которая возникает при некорректном сроке действия ссылки
+var ErrInvalidExpiry = errors.New("invalid expiry")
+
+// ErrInvalidRedirectCode ошибка, которая возникает при недопустимом коде перенаправления ссылки
+var ErrInvalidRedirectCode = errors.New("invalid redirect code")
+
+// ErrInvalidQueryMerge ошибка, которая возникает при неизвестном способе передачи параметров запроса
+var ErrInvalidQueryMerge = errors.New("invalid query merge mode")
+
+// ErrURLRejected ошибка, которая возникает, когда ссылку запрещено сокращать
+var ErrURLRejected = errors.New("url is not allowed")
+
+// ErrNotOwner ошибка, которая возникает при доступе к чужой ссылке
+var ErrNotOwner = errors.New("short URL belongs to another user")
+
+// ErrKeyGeneration ошибка, которая возникает, когда не удалось подобрать свободный короткий ключ
+var ErrKeyGeneration = errors.New("failed to generate a free short key")
+
+// ErrNotFound ошибка, которая возникает, когда короткая ссылка не найдена
+var ErrNotFound = errors.New("not found")
+
+// ErrDeleteQueueFull ошибка, которая возникает, когда очередь удалений переполнена
+var ErrDeleteQueueFull = errors.New("delete queue is full")
+
+// ErrDeleteQueueClosed ошибка, которая возникает, когда приём удалений остановлен
+var ErrDeleteQueueClosed = errors.New("delete queue is closed")
+
+// ErrBatchConflict ошибка, которая возникает, когда пакет конфликтует с параллельно сохранённой ссылкой
+var ErrBatchConflict = errors.New("batch conflicts with a concurrently saved link")
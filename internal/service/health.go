@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+)
+
+// Компоненты в ответе проверки готовности
+const (
+	componentServer  = "server"
+	componentStorage = "storage"
+)
+
+// StartDraining переводит сервис в состояние остановки: проверка готовности начинает возвращать отказ
+func (u *URLShortnerService) StartDraining() {
+	u.draining.Store(true)
+}
+
+// Readiness проверяет готовность сервиса и хранилища принимать запросы
+// Хранилище в ограниченном режиме не снимает готовность: редиректы и создание ссылок продолжают работать
+func (u *URLShortnerService) Readiness(ctx context.Context) model.Readiness {
+	readiness := model.Readiness{
+		Status:     model.StatusReady,
+		Components: make(map[string]model.ComponentHealth, 2),
+	}
+
+	if u.draining.Load() {
+		readiness.Status = model.StatusNotReady
+		readiness.Components[componentServer] = model.ComponentHealth{Status: model.ComponentDraining}
+	} else {
+		readiness.Components[componentServer] = model.ComponentHealth{Status: model.ComponentUp}
+	}
+
+	err := u.Repository.Ping(ctx)
+	switch {
+	case err == nil:
+		readiness.Components[componentStorage] = model.ComponentHealth{Status: model.ComponentUp}
+	case errors.Is(err, repository.ErrDegraded):
+		readiness.Components[componentStorage] = model.ComponentHealth{Status: model.ComponentDegraded, Error: err.Error()}
+	default:
+		readiness.Status = model.StatusNotReady
+		readiness.Components[componentStorage] = model.ComponentHealth{Status: model.ComponentDown, Error: err.Error()}
+	}
+
+	return readiness
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/keygen"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+)
+
+// maxKeyAttempts количество попыток вставки со сгенерированным ключом до отказа
+const maxKeyAttempts = 10
+
+// newKeyGenerator создаёт генератор ключей из конфигурации, незаданные значения берутся по умолчанию
+func newKeyGenerator(repo repository.URLRepository, configuration *config.ConfigStruct) keygen.Generator {
+	strategy, length, alphabet, poolSize := config.DefaultKeyStrategy, config.DefaultKeyLength, config.DefaultKeyAlphabet, 0
+	if configuration != nil {
+		if configuration.KeyStrategy != "" {
+			strategy = configuration.KeyStrategy
+		}
+		if configuration.KeyLength > 0 {
+			length = configuration.KeyLength
+		}
+		if configuration.KeyAlphabet != "" {
+			alphabet = configuration.KeyAlphabet
+		}
+		poolSize = configuration.KeyPoolSize
+	}
+
+	var generator keygen.Generator
+	var err error
+	if strategy == config.KeyStrategySequence {
+		generator, err = keygen.NewSequence(repo.NextSequence, alphabet)
+	} else {
+		generator, err = keygen.NewRandom(length, alphabet)
+	}
+	if err != nil {
+		// Конфигурация проверяется при загрузке, сюда попадают только собранные вручную значения
+		log.Printf("Ошибка настройки генератора ключей: %v. Используем случайные ключи по умолчанию", err)
+		generator, _ = keygen.NewRandom(config.DefaultKeyLength, config.DefaultKeyAlphabet)
+	}
+
+	if poolSize > 0 {
+		return keygen.NewPool(generator, poolSize)
+	}
+	return generator
+}
+
+// nextKey возвращает сгенерированный ключ, не совпадающий с зарезервированными словами
+func (u *URLShortnerService) nextKey(ctx context.Context) (string, error) {
+	for {
+		key, err := u.keys.Next(ctx)
+		if err != nil || !u.aliases.isReserved(key) {
+			return key, err
+		}
+	}
+}
+
+// closeKeys останавливает фоновую генерацию ключей, если она есть
+func (u *URLShortnerService) closeKeys() error {
+	if closer, ok := u.keys.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
+)
+
+// redirectPolicy параметры перенаправления по ссылкам
+type redirectPolicy struct {
+	code   int
+	maxAge time.Duration
+}
+
+// newRedirectPolicy создаёт параметры из конфигурации, незаданные значения берутся по умолчанию
+func newRedirectPolicy(configuration *config.ConfigStruct) *redirectPolicy {
+	policy := &redirectPolicy{
+		code:   config.DefaultRedirectCode,
+		maxAge: config.DefaultRedirectMaxAge,
+	}
+
+	if configuration != nil {
+		if configuration.RedirectCode != 0 {
+			policy.code = configuration.RedirectCode
+		}
+		if configuration.RedirectMaxAge > 0 {
+			policy.maxAge = time.Duration(configuration.RedirectMaxAge)
+		}
+	}
+	return policy
+}
+
+// redirect выбирает адрес и код перенаправления по ссылке и время его кеширования
+// Временные перенаправления не кешируются, чтобы каждый переход попадал в статистику,
+// а постоянные кешируются не дольше срока действия ссылки
+func (p *redirectPolicy) redirect(link repository.Link, request model.RedirectRequest, now time.Time) (model.Redirect, error) {
+	target, err := passthrough(link, request)
+	if err != nil {
+		return model.Redirect{}, err
+	}
+
+	redirect := model.Redirect{URL: target, StatusCode: p.code}
+	if link.RedirectCode != 0 {
+		redirect.StatusCode = link.RedirectCode
+	}
+
+	if model.PermanentRedirect(redirect.StatusCode) {
+		redirect.CacheFor = p.maxAge
+		if link.ExpiresAt != nil {
+			redirect.CacheFor = min(redirect.CacheFor, link.ExpiresAt.Sub(now).Truncate(time.Second))
+		}
+	}
+	return redirect, nil
+}
+
+// passthrough передаёт путь и параметры запроса перехода в оригинальный URL, если это включено для ссылки
+// Путь после ключа ссылки без передачи пути считается несуществующей ссылкой, а параметры в этом случае отбрасываются
+func passthrough(link repository.Link, request model.RedirectRequest) (string, error) {
+	if request.Path != "" && !link.PathPassthrough {
+		return "", ErrNotFound
+	}
+	withQuery := link.QueryMerge != "" && request.Query != ""
+	if request.Path == "" && !withQuery {
+		return link.OriginalURL, nil
+	}
+
+	target, err := url.Parse(link.OriginalURL)
+	if err != nil {
+		return "", ErrNotFound
+	}
+	if request.Path != "" {
+		if err := appendPath(target, request.Path); err != nil {
+			return "", err
+		}
+	}
+	if withQuery {
+		target.RawQuery = mergeQuery(target.RawQuery, request.Query, link.QueryMerge)
+	}
+	// Схема и хост берутся только из оригинального URL, поэтому перенаправить на другой сайт переход не может
+	return target.String(), nil
+}
+
+// appendPath дописывает сегменты пути перехода к пути оригинального URL
+// Каждый сегмент экранируется заново, поэтому закодированные "/", "?" и "#" не меняют структуру URL,
+// а сегменты "." и ".." запрещены, чтобы путь не выходил за пределы пути ссылки
+func appendPath(target *url.URL, suffix string) error {
+	segments := make([]string, 0, strings.Count(suffix, "/")+1)
+	for _, segment := range strings.Split(suffix, "/") {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return ErrNotFound
+		}
+		switch decoded {
+		case "":
+			continue
+		case ".", "..":
+			return ErrNotFound
+		}
+		segments = append(segments, url.PathEscape(decoded))
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	escaped := strings.TrimSuffix(target.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+	if strings.HasSuffix(suffix, "/") {
+		escaped += "/"
+	}
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return ErrNotFound
+	}
+	target.Path, target.RawPath = path, escaped
+	return nil
+}
+
+// mergeQuery объединяет параметры оригинального URL с параметрами перехода
+// Параметры ссылки остаются в исходном виде и порядке, а параметры перехода кодируются заново
+// Некорректные пары в параметрах перехода отбрасываются
+func mergeQuery(original, incoming, mode string) string {
+	values, _ := url.ParseQuery(incoming)
+	if len(values) == 0 {
+		return original
+	}
+
+	pairs := make([]string, 0)
+	names := make(map[string]struct{})
+	for _, pair := range strings.Split(original, "&") {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if _, ok := values[name]; ok && mode == model.QueryMergeOverride {
+			continue
+		}
+		names[name] = struct{}{}
+		pairs = append(pairs, pair)
+	}
+
+	if mode == model.QueryMergeKeep {
+		for name := range names {
+			delete(values, name)
+		}
+	}
+	if encoded := values.Encode(); encoded != "" {
+		pairs = append(pairs, encoded)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// validateRedirect проверяет параметры перенаправления, выбранные для ссылки, 0 означает код по умолчанию
+func validateRedirect(options model.RedirectOptions) error {
+	if options.RedirectCode != 0 && !model.ValidRedirectCode(options.RedirectCode) {
+		return fmt.Errorf("%w: %d, allowed 301, 302, 307 and 308", ErrInvalidRedirectCode, options.RedirectCode)
+	}
+	if !model.ValidQueryMerge(options.QueryMerge) {
+		return fmt.Errorf("%w: %q, allowed %q, %q and %q", ErrInvalidQueryMerge, options.QueryMerge,
+			model.QueryMergeKeep, model.QueryMergeOverride, model.QueryMergeAppend)
+	}
+	return nil
+}
@@ -1,121 +1,272 @@
 package service
 
 import (
-	"database/sql"
+	"context"
 	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/keygen"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/metrics"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
-	"github.com/Ilya-c4talyst/go-advanced-shortner/pkg/utils"
-	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 // Структура для сервиса сокращения ссылок
 type URLShortnerService struct {
 	Repository    repository.URLRepository
 	Configuration *config.ConfigStruct
+	deleter       *deleteWorker
+	aliases       *aliasPolicy
+	urls          *urlPolicy
+	clicks        *clickRecorder
+	keys          keygen.Generator
+	redirects     *redirectPolicy
+	// draining выставляется при остановке, чтобы балансировщик перестал направлять запросы
+	draining atomic.Bool
 }
 
 // Конструктор для сервиса
 func NewURLShortnerService(repo repository.URLRepository, configuration *config.ConfigStruct) *URLShortnerService {
+	// Параметры буфера переходов, незаданные значения берутся по умолчанию
+	clickBufferSize := configuration.ClickBufferSize
+	if clickBufferSize <= 0 {
+		clickBufferSize = config.DefaultClickBufferSize
+	}
+	clickFlushInterval := time.Duration(configuration.ClickFlushInterval)
+	if clickFlushInterval <= 0 {
+		clickFlushInterval = config.DefaultClickFlushInterval
+	}
+
 	return &URLShortnerService{
 		Repository:    repo,
 		Configuration: configuration,
+		deleter:       newDeleteWorker(repo),
+		aliases:       newAliasPolicy(configuration),
+		urls:          newURLPolicy(configuration),
+		clicks:        newClickRecorder(repo, clickBufferSize, clickFlushInterval),
+		keys:          newKeyGenerator(repo, configuration),
+		redirects:     newRedirectPolicy(configuration),
 	}
 }
 
 // Создание сокращенного URL для пользователя
-func (u *URLShortnerService) CreateShortURL(url, userID string) (string, error) {
-	// Инициализация результата
-	var shortURL string
-
-	// Генерируем сокращенную уникальную ссылку
-	for {
-		shortURL = utils.GenerateShortKey()
-		if _, err := u.Repository.GetFullValue(shortURL); err == nil {
+func (u *URLShortnerService) CreateShortURL(ctx context.Context, url, userID string) (string, error) {
+	return u.CreateShortURLWithOptions(ctx, url, userID, model.LinkOptions{})
+}
+
+// CreateShortURLWithOptions создаёт сокращенный URL с дополнительными параметрами ссылки
+func (u *URLShortnerService) CreateShortURLWithOptions(ctx context.Context, url, userID string, opts model.LinkOptions) (string, error) {
+	canonicalURL, err := u.urls.canonicalize(ctx, url)
+	if err != nil {
+		return "", err
+	}
+
+	// Срок действия ссылки
+	expiresAt, err := resolveExpiry(opts)
+	if err != nil {
+		return "", err
+	}
+	if err := validateRedirect(opts.RedirectOptions); err != nil {
+		return "", err
+	}
+
+	// Пользовательский алиас вместо сгенерированного ключа
+	if opts.Alias != "" {
+		return u.createAlias(ctx, url, canonicalURL, userID, opts.Alias, expiresAt, opts.RedirectOptions)
+	}
+
+	// Сохраняем со сгенерированным ключом, занятость ключа проверяет само хранилище при вставке
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		shortURL, err := u.nextKey(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		err = u.Repository.SetValue(ctx, shortURL, url, canonicalURL, userID, expiresAt, opts.RedirectOptions)
+		switch {
+		case err == nil:
+			metrics.AddLinksCreated(metrics.KindSingle, 1)
+			return shortURL, nil
+		case errors.Is(err, repository.ErrKeyExists):
+			// Ключ занят, пробуем следующий
 			continue
+		case errors.Is(err, repository.ErrRowExists):
+			// Если ссылка уже существует, в том числе в другой записи
+			existing, getErr := u.Repository.GetShortValue(ctx, canonicalURL)
+			if getErr != nil {
+				return "", getErr
+			}
+			return existing, repository.ErrRowExists
+		default:
+			return "", err
 		}
-		break
 	}
 
-	// Сохраняем в репозитории
-	if err := u.Repository.SetValue(shortURL, url, userID); err != nil {
+	return "", ErrKeyGeneration
+}
+
+// createAlias сохраняет ссылку под выбранным пользователем алиасом
+func (u *URLShortnerService) createAlias(ctx context.Context, url, canonicalURL, userID, alias string, expiresAt *time.Time, redirect model.RedirectOptions) (string, error) {
+	if err := u.aliases.validate(alias); err != nil {
+		return "", err
+	}
+
+	// Алиас занят, в том числе удалённой или просроченной ссылкой
+	if err := u.Repository.SetValue(ctx, alias, url, canonicalURL, userID, expiresAt, redirect); err != nil {
+		if errors.Is(err, repository.ErrKeyExists) {
+			return "", fmt.Errorf("%w: %q", ErrAliasTaken, alias)
+		}
 		if errors.Is(err, repository.ErrRowExists) {
-			// Если ссылка уже существует
-			if shortURL, err = u.Repository.GetShortValue(url); err == nil {
+			// Конфликт по оригинальному URL: ссылка уже сокращена под другим ключом
+			if shortURL, getErr := u.Repository.GetShortValue(ctx, canonicalURL); getErr == nil && shortURL != alias {
 				return shortURL, repository.ErrRowExists
 			}
+			return "", fmt.Errorf("%w: %q", ErrAliasTaken, alias)
 		}
 		return "", err
 	}
 
-	return shortURL, nil
+	metrics.AddLinksCreated(metrics.KindSingle, 1)
+	return alias, nil
 }
 
-// CreateShortURLsBatch создает сокращенные URL для пакета URL для пользователя
-func (u *URLShortnerService) CreateShortURLsBatch(urls []string, userID string) (map[string]string, error) {
-	if len(urls) == 0 {
-		return make(map[string]string), nil
+// resolveExpiry вычисляет момент истечения ссылки из относительного или абсолютного срока
+func resolveExpiry(opts model.LinkOptions) (*time.Time, error) {
+	if opts.ExpiresIn != 0 && opts.ExpiresAt != nil {
+		return nil, fmt.Errorf("%w: expires_in and expires_at are mutually exclusive", ErrInvalidExpiry)
 	}
 
-	result := make(map[string]string)
-	pairs := make(map[string]string)
+	now := time.Now()
+	switch {
+	case opts.ExpiresIn < 0:
+		return nil, fmt.Errorf("%w: expires_in must be positive", ErrInvalidExpiry)
+	case opts.ExpiresIn > model.MaxExpiresIn:
+		return nil, fmt.Errorf("%w: expires_in must not exceed %d seconds", ErrInvalidExpiry, int64(model.MaxExpiresIn/time.Second))
+	case opts.ExpiresIn > 0:
+		expiresAt := now.Add(opts.ExpiresIn).UTC()
+		return &expiresAt, nil
+	case opts.ExpiresAt != nil:
+		if !opts.ExpiresAt.After(now) {
+			return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidExpiry)
+		}
+		expiresAt := opts.ExpiresAt.UTC()
+		return &expiresAt, nil
+	}
 
-	// Генерируем короткие URL для каждого исходного URL
-	for _, originalURL := range urls {
-		var shortURL string
+	return nil, nil
+}
 
-		// Генерируем уникальную короткую ссылку
-		for {
-			shortURL = utils.GenerateShortKey()
-			if _, err := u.Repository.GetFullValue(shortURL); err == nil {
-				continue
-			}
-			// Проверяем также, что этот ключ не используется в текущем пакете
-			if _, exists := pairs[shortURL]; exists {
-				continue
-			}
-			break
-		}
+// Получение полного URL
+func (u *URLShortnerService) GetFullURL(ctx context.Context, shortURL string) (string, error) {
+	redirect, err := u.GetRedirect(ctx, shortURL, model.RedirectRequest{})
+	return redirect.URL, err
+}
 
-		pairs[shortURL] = originalURL
-		result[originalURL] = shortURL
+// GetRedirect получает адрес перенаправления, его код и время кеширования
+// Путь и параметры запроса перехода передаются в оригинальный URL, если это включено для ссылки
+func (u *URLShortnerService) GetRedirect(ctx context.Context, shortURL string, request model.RedirectRequest) (model.Redirect, error) {
+	// Ищем ссылку в репозитории, или выдаем ошибку
+	if link, err := u.Repository.GetLink(ctx, shortURL); err == nil {
+		redirect, err := u.redirects.redirect(link, request, time.Now())
+		metrics.ObserveRedirect(err == nil)
+		return redirect, err
+	} else if errors.Is(err, repository.ErrURLDeleted) || errors.Is(err, repository.ErrURLExpired) {
+		metrics.ObserveRedirect(false)
+		return model.Redirect{}, err
+	} else if ctxErr := ctx.Err(); ctxErr != nil {
+		// Запрос отменён клиентом или остановкой сервера, промахом не считается
+		return model.Redirect{}, ctxErr
+	} else if errors.Is(err, repository.ErrUnavailable) {
+		// Хранилище недоступно, и ссылки нет в локальной реплике: это не промах
+		return model.Redirect{}, err
+	} else {
+		metrics.ObserveRedirect(false)
+		return model.Redirect{}, ErrNotFound
 	}
+}
 
-	// Сохраняем пакет в репозитории
-	if err := u.Repository.SetValuesBatch(pairs, userID); err != nil {
-		return nil, err
+// GetUserURLs получает страницу URL пользователя
+func (u *URLShortnerService) GetUserURLs(ctx context.Context, userID string, query repository.UserURLsQuery) (repository.UserURLsPage, error) {
+	return u.Repository.GetUserURLs(ctx, userID, query)
+}
+
+// DeleteUserURLs ставит в очередь асинхронное удаление URL пользователя
+// При переполненной очереди возвращает ErrDeleteQueueFull, после остановки — ErrDeleteQueueClosed
+func (u *URLShortnerService) DeleteUserURLs(userID string, shortURLs []string) error {
+	if len(shortURLs) == 0 {
+		return nil
 	}
+	return u.deleter.enqueue(deleteTask{userID: userID, shortURLs: shortURLs})
+}
 
-	return result, nil
+// FlushDeletions останавливает приём удалений и дожидается сохранения накопленных
+func (u *URLShortnerService) FlushDeletions() {
+	u.deleter.stop()
 }
 
-// Получение полного URL
-func (u *URLShortnerService) GetFullURL(shortURL string) (string, error) {
-	// Ищем полный URL в репозитории, или выдаем ошибку
-	if url, err := u.Repository.GetFullValue(shortURL); err == nil {
-		return url, nil
-	} else {
-		return "", errors.New("not found")
+// GetStats возвращает общее количество URL и пользователей
+func (u *URLShortnerService) GetStats(ctx context.Context) (model.Stats, error) {
+	urls, err := u.Repository.CountURLs(ctx)
+	if err != nil {
+		return model.Stats{}, err
+	}
+
+	users, err := u.Repository.CountUsers(ctx)
+	if err != nil {
+		return model.Stats{}, err
 	}
+
+	return model.Stats{URLs: urls, Users: users}, nil
 }
 
-// GetUserURLs получает все URL пользователя
-func (u *URLShortnerService) GetUserURLs(userID string) ([]map[string]string, error) {
-	return u.Repository.GetUserURLs(userID)
+// PurgeExpiredURLs удаляет ссылки, которые просрочены дольше срока хранения
+func (u *URLShortnerService) PurgeExpiredURLs(ctx context.Context, retention time.Duration) (int, error) {
+	return u.Repository.PurgeExpired(ctx, time.Now().Add(-retention))
 }
 
-// Ping DB
-func (u *URLShortnerService) PingPostgreSQL() error {
-	db, err := sql.Open("pgx", u.Configuration.AddressDB)
+// RecordClick асинхронно регистрирует переход по короткой ссылке
+func (u *URLShortnerService) RecordClick(shortURL, referrer, userAgent, clientIP string) {
+	u.clicks.record(model.ClickEvent{
+		ShortURL:  shortURL,
+		Timestamp: time.Now().UTC(),
+		Referrer:  referrer,
+		UserAgent: userAgent,
+		IPHash:    hashIP(u.Configuration.AuthSecretKey, clientIP),
+	})
+}
+
+// GetClickStats возвращает статистику переходов, доступную только владельцу ссылки
+func (u *URLShortnerService) GetClickStats(ctx context.Context, shortURL, userID string) (model.ClickStats, error) {
+	owner, err := u.Repository.GetUserID(ctx, shortURL)
 	if err != nil {
-		return err
+		return model.ClickStats{}, err
+	}
+	if owner != userID {
+		return model.ClickStats{}, ErrNotOwner
 	}
-	defer db.Close()
-	return db.Ping()
+
+	return u.Repository.GetClickStats(ctx, shortURL)
+}
+
+// FlushClicks останавливает приём переходов и дожидается сохранения накопленных
+func (u *URLShortnerService) FlushClicks() {
+	u.clicks.stop()
+}
+
+// PingPostgreSQL проверяет хранилище через уже открытое соединение репозитория
+func (u *URLShortnerService) PingPostgreSQL(ctx context.Context) error {
+	return u.Repository.Ping(ctx)
 }
 
 // Close закрывает соединение с репозиторием
 func (u *URLShortnerService) Close() error {
+	u.FlushDeletions()
+	u.FlushClicks()
+	if err := u.closeKeys(); err != nil {
+		return err
+	}
 	return u.Repository.Close()
 }
@@ -1,9 +1,16 @@
 package service
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/model"
 	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/repository"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,13 +26,13 @@ func TestURLShortnerService(t *testing.T) {
 		originalURL := "https://example.com/very/long/url"
 
 		// Создаем короткую ссылку
-		shortURL, err := service.CreateShortURL(originalURL, "")
+		shortURL, err := service.CreateShortURL(context.Background(), originalURL, "")
 		assert.NoError(t, err)
 		assert.NotEmpty(t, shortURL)
 		assert.Len(t, shortURL, 6)
 
 		// Получаем оригинальную ссылку
-		fullURL, err := service.GetFullURL(shortURL)
+		fullURL, err := service.GetFullURL(context.Background(), shortURL)
 		assert.NoError(t, err)
 		assert.Equal(t, originalURL, fullURL)
 	})
@@ -34,7 +41,7 @@ func TestURLShortnerService(t *testing.T) {
 		nonExistentKey := "nonexist"
 
 		// Пытаемся получить несуществующую ссылку
-		_, err := service.GetFullURL(nonExistentKey)
+		_, err := service.GetFullURL(context.Background(), nonExistentKey)
 		assert.Error(t, err)
 		assert.Equal(t, "not found", err.Error())
 	})
@@ -44,16 +51,16 @@ func TestURLShortnerService(t *testing.T) {
 		url2 := "https://second.com"
 
 		// Генерируем две короткие ссылки
-		short1, err1 := service.CreateShortURL(url1, "")
-		short2, err2 := service.CreateShortURL(url2, "")
+		short1, err1 := service.CreateShortURL(context.Background(), url1, "")
+		short2, err2 := service.CreateShortURL(context.Background(), url2, "")
 
 		assert.NoError(t, err1)
 		assert.NoError(t, err2)
 		assert.NotEqual(t, short1, short2)
 
 		// Проверяем, что они ведут на разные URL
-		full1, _ := service.GetFullURL(short1)
-		full2, _ := service.GetFullURL(short2)
+		full1, _ := service.GetFullURL(context.Background(), short1)
+		full2, _ := service.GetFullURL(context.Background(), short2)
 
 		assert.Equal(t, url1, full1)
 		assert.Equal(t, url2, full2)
@@ -62,18 +69,789 @@ func TestURLShortnerService(t *testing.T) {
 	t.Run("Empty URL handling", func(t *testing.T) {
 		emptyURL := ""
 
-		// Не должно паниковать при пустом URL
-		shortURL, err := service.CreateShortURL(emptyURL, "")
+		// Не должно паниковать при пустом URL, но и сокращать его нельзя
+		shortURL, err := service.CreateShortURL(context.Background(), emptyURL, "")
+		assert.ErrorIs(t, err, ErrURLRejected)
+		assert.Empty(t, shortURL)
+	})
+
+	t.Run("Ping checks repository", func(t *testing.T) {
+		assert.NoError(t, service.PingPostgreSQL(context.Background()))
+
+		closed := NewURLShortnerService(&pingRepository{URLRepository: repository.NewMemoryRepository(), err: repository.ErrClosed}, &config.ConfigStruct{})
+		defer closed.Close()
+		assert.ErrorIs(t, closed.PingPostgreSQL(context.Background()), repository.ErrClosed)
+	})
+}
+
+func TestCreateShortURLWithAlias(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	configuration := config.ConfigStruct{
+		AliasCharset:    "abcdefghijklmnopqrstuvwxyz-",
+		AliasMinLength:  3,
+		AliasMaxLength:  12,
+		ReservedAliases: []string{"ping", "api"},
+	}
+	service := NewURLShortnerService(repo, &configuration)
+	defer service.Close()
+
+	t.Run("Alias is used as short URL", func(t *testing.T) {
+		shortURL, err := service.CreateShortURLWithOptions(context.Background(), "https://example.com/spring", "user", model.LinkOptions{Alias: "spring-sale"})
 		assert.NoError(t, err)
-		assert.NotEmpty(t, shortURL)
+		assert.Equal(t, "spring-sale", shortURL)
+
+		fullURL, err := service.GetFullURL(context.Background(), "spring-sale")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/spring", fullURL)
+	})
+
+	t.Run("Taken alias", func(t *testing.T) {
+		_, err := service.CreateShortURLWithOptions(context.Background(), "https://example.com/other", "user", model.LinkOptions{Alias: "spring-sale"})
+		assert.ErrorIs(t, err, ErrAliasTaken)
+	})
+
+	t.Run("Invalid aliases", func(t *testing.T) {
+		for _, alias := range []string{"ab", "way-too-long-alias", "Upper", "PING", "with space"} {
+			_, err := service.CreateShortURLWithOptions(context.Background(), "https://example.com/"+alias, "user", model.LinkOptions{Alias: alias})
+			assert.ErrorIs(t, err, ErrInvalidAlias, alias)
+		}
+	})
+
+	t.Run("Reserved aliases", func(t *testing.T) {
+		for _, alias := range []string{"api", "ping"} {
+			_, err := service.CreateShortURLWithOptions(context.Background(), "https://example.com/"+alias, "user", model.LinkOptions{Alias: alias})
+			assert.ErrorIs(t, err, ErrAliasTaken, alias)
+		}
+	})
+
+	t.Run("Batch with aliases", func(t *testing.T) {
+		result, err := service.CreateShortURLsBatch(context.Background(), []model.ShortenItem{
+			{OriginalURL: "https://batch1.com", Options: model.LinkOptions{Alias: "batch-one"}},
+			{OriginalURL: "https://batch2.com"},
+		}, "user", model.BatchModeAtomic)
+		assert.NoError(t, err)
+		assert.Equal(t, "batch-one", result[0].ShortURL)
+		assert.Len(t, result[1].ShortURL, 6)
+	})
+
+	t.Run("Batch with duplicate aliases", func(t *testing.T) {
+		_, err := service.CreateShortURLsBatch(context.Background(), []model.ShortenItem{
+			{OriginalURL: "https://dup1.com", Options: model.LinkOptions{Alias: "dup-alias"}},
+			{OriginalURL: "https://dup2.com", Options: model.LinkOptions{Alias: "dup-alias"}},
+		}, "user", model.BatchModeAtomic)
+		assert.ErrorIs(t, err, ErrAliasTaken)
+	})
+}
+
+func TestCreateShortURLWithExpiry(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	service := NewURLShortnerService(repo, &config.ConfigStruct{})
+	defer service.Close()
+
+	t.Run("Link with TTL is available", func(t *testing.T) {
+		shortURL, err := service.CreateShortURLWithOptions(context.Background(), "https://ttl.com", "user", model.LinkOptions{ExpiresIn: time.Hour})
+		assert.NoError(t, err)
+
+		fullURL, err := service.GetFullURL(context.Background(), shortURL)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://ttl.com", fullURL)
+	})
+
+	t.Run("Expired link", func(t *testing.T) {
+		shortURL, err := service.CreateShortURLWithOptions(context.Background(), "https://short-ttl.com", "user", model.LinkOptions{ExpiresIn: time.Millisecond})
+		assert.NoError(t, err)
+
+		time.Sleep(5 * time.Millisecond)
+		_, err = service.GetFullURL(context.Background(), shortURL)
+		assert.ErrorIs(t, err, repository.ErrURLExpired)
+	})
+
+	t.Run("Invalid expiry", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		future := time.Now().Add(time.Hour)
+
+		invalid := []model.LinkOptions{
+			{ExpiresIn: -time.Second},
+			{ExpiresAt: &past},
+			{ExpiresIn: time.Hour, ExpiresAt: &future},
+			{ExpiresIn: model.MaxExpiresIn + time.Second},
+		}
+		for _, opts := range invalid {
+			_, err := service.CreateShortURLWithOptions(context.Background(), "https://invalid-ttl.com", "user", opts)
+			assert.ErrorIs(t, err, ErrInvalidExpiry)
+		}
+	})
+
+	t.Run("Purge expired links", func(t *testing.T) {
+		shortURL, err := service.CreateShortURLWithOptions(context.Background(), "https://purge.com", "user", model.LinkOptions{ExpiresIn: time.Millisecond})
+		assert.NoError(t, err)
+
+		time.Sleep(5 * time.Millisecond)
+		purged, err := service.PurgeExpiredURLs(context.Background(), 0)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, 1)
+
+		_, err = service.GetFullURL(context.Background(), shortURL)
+		assert.EqualError(t, err, "not found")
+	})
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Default code is temporary and not cached", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		defer service.Close()
+
+		shortURL, err := service.CreateShortURL(ctx, "https://default-redirect.com", "user")
+		assert.NoError(t, err)
+
+		redirect, err := service.GetRedirect(ctx, shortURL, model.RedirectRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://default-redirect.com", redirect.URL)
+		assert.Equal(t, http.StatusTemporaryRedirect, redirect.StatusCode)
+		assert.Zero(t, redirect.CacheFor)
+	})
+
+	t.Run("Server default comes from config", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{
+			RedirectCode:   http.StatusMovedPermanently,
+			RedirectMaxAge: config.Duration(time.Hour),
+		})
+		defer service.Close()
+
+		shortURL, err := service.CreateShortURL(ctx, "https://server-default.com", "user")
+		assert.NoError(t, err)
+
+		redirect, err := service.GetRedirect(ctx, shortURL, model.RedirectRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusMovedPermanently, redirect.StatusCode)
+		assert.Equal(t, time.Hour, redirect.CacheFor)
+	})
+
+	t.Run("Per-link code overrides default", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		defer service.Close()
+
+		shortURL, err := service.CreateShortURLWithOptions(ctx, "https://permanent-redirect.com", "user", model.LinkOptions{RedirectOptions: model.RedirectOptions{RedirectCode: http.StatusPermanentRedirect}})
+		assert.NoError(t, err)
+
+		redirect, err := service.GetRedirect(ctx, shortURL, model.RedirectRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPermanentRedirect, redirect.StatusCode)
+		assert.Equal(t, config.DefaultRedirectMaxAge, redirect.CacheFor)
+
+		// Временный код ссылки не кешируется
+		shortURL, err = service.CreateShortURLWithOptions(ctx, "https://found-redirect.com", "user", model.LinkOptions{RedirectOptions: model.RedirectOptions{RedirectCode: http.StatusFound}})
+		assert.NoError(t, err)
+
+		redirect, err = service.GetRedirect(ctx, shortURL, model.RedirectRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusFound, redirect.StatusCode)
+		assert.Zero(t, redirect.CacheFor)
+	})
+
+	t.Run("Cache lifetime is capped by link expiry", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		defer service.Close()
+
+		shortURL, err := service.CreateShortURLWithOptions(ctx, "https://expiring-redirect.com", "user",
+			model.LinkOptions{ExpiresIn: time.Minute, RedirectOptions: model.RedirectOptions{RedirectCode: http.StatusMovedPermanently}})
+		assert.NoError(t, err)
+
+		redirect, err := service.GetRedirect(ctx, shortURL, model.RedirectRequest{})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, redirect.CacheFor, time.Minute)
+		assert.Greater(t, redirect.CacheFor, 50*time.Second)
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		defer service.Close()
+
+		for _, code := range []int{http.StatusOK, http.StatusSeeOther, 399} {
+			_, err := service.CreateShortURLWithOptions(ctx, "https://invalid-redirect.com", "user", model.LinkOptions{RedirectOptions: model.RedirectOptions{RedirectCode: code}})
+			assert.ErrorIs(t, err, ErrInvalidRedirectCode)
+		}
+
+		result, err := service.CreateShortURLsBatch(ctx, []model.ShortenItem{
+			{OriginalURL: "https://batch-invalid-redirect.com", Options: model.LinkOptions{RedirectOptions: model.RedirectOptions{RedirectCode: http.StatusSeeOther}}},
+		}, "user", model.BatchModeAtomic)
+		assert.ErrorIs(t, err, ErrInvalidRedirectCode)
+		assert.Nil(t, result)
+	})
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	ctx := context.Background()
+	service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+	defer service.Close()
+
+	create := func(url string, options model.RedirectOptions) string {
+		shortURL, err := service.CreateShortURLWithOptions(ctx, url, "user", model.LinkOptions{RedirectOptions: options})
+		assert.NoError(t, err)
+		return shortURL
+	}
+
+	plain := create("https://plain.com/landing?ref=site", model.RedirectOptions{})
+	keep := create("https://keep.com/landing?utm_source=site&ref=1", model.RedirectOptions{QueryMerge: model.QueryMergeKeep})
+	override := create("https://override.com/landing?utm_source=site&ref=1", model.RedirectOptions{QueryMerge: model.QueryMergeOverride})
+	appendQuery := create("https://append.com/landing?tag=a#top", model.RedirectOptions{QueryMerge: model.QueryMergeAppend})
+	docs := create("https://docs.com/base/", model.RedirectOptions{PathPassthrough: true, QueryMerge: model.QueryMergeAppend})
+
+	cases := []struct {
+		name     string
+		shortURL string
+		request  model.RedirectRequest
+		want     string
+		err      error
+	}{
+		{name: "query is dropped by default", shortURL: plain, request: model.RedirectRequest{Query: "utm_source=mail"},
+			want: "https://plain.com/landing?ref=site"},
+		{name: "keep original values", shortURL: keep, request: model.RedirectRequest{Query: "utm_source=mail&utm_medium=email"},
+			want: "https://keep.com/landing?utm_source=site&ref=1&utm_medium=email"},
+		{name: "override original values", shortURL: override, request: model.RedirectRequest{Query: "utm_source=mail"},
+			want: "https://override.com/landing?ref=1&utm_source=mail"},
+		{name: "append values and keep fragment", shortURL: appendQuery, request: model.RedirectRequest{Query: "tag=b"},
+			want: "https://append.com/landing?tag=a&tag=b#top"},
+		{name: "incoming values are re-encoded", shortURL: appendQuery, request: model.RedirectRequest{Query: "next=%23evil%26x%3D1"},
+			want: "https://append.com/landing?tag=a&next=%23evil%26x%3D1#top"},
+		{name: "path is appended", shortURL: docs, request: model.RedirectRequest{Path: "docs/x", Query: "v=2"},
+			want: "https://docs.com/base/docs/x?v=2"},
+		{name: "trailing slash is kept", shortURL: docs, request: model.RedirectRequest{Path: "docs/"},
+			want: "https://docs.com/base/docs/"},
+		{name: "empty segments are dropped", shortURL: docs, request: model.RedirectRequest{Path: "/evil.com//x"},
+			want: "https://docs.com/base/evil.com/x"},
+		{name: "encoded separators stay in segment", shortURL: docs, request: model.RedirectRequest{Path: "%2F%2Fevil.com%3Fa%23b"},
+			want: "https://docs.com/base/%2F%2Fevil.com%3Fa%23b"},
+		{name: "backslash and userinfo are escaped", shortURL: docs, request: model.RedirectRequest{Path: "%5C%5Cevil.com@x"},
+			want: "https://docs.com/base/%5C%5Cevil.com@x"},
+		{name: "dot segments are rejected", shortURL: docs, request: model.RedirectRequest{Path: "docs/%2E%2E/admin"}, err: ErrNotFound},
+		{name: "invalid escape is rejected", shortURL: docs, request: model.RedirectRequest{Path: "docs/%zz"}, err: ErrNotFound},
+		{name: "path without passthrough", shortURL: plain, request: model.RedirectRequest{Path: "docs"}, err: ErrNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			redirect, err := service.GetRedirect(ctx, tc.shortURL, tc.request)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, redirect.URL)
+		})
+	}
+
+	t.Run("Invalid query merge mode", func(t *testing.T) {
+		_, err := service.CreateShortURLWithOptions(ctx, "https://invalid-merge.com", "user",
+			model.LinkOptions{RedirectOptions: model.RedirectOptions{QueryMerge: "replace"}})
+		assert.ErrorIs(t, err, ErrInvalidQueryMerge)
+	})
+}
+
+func TestCanceledContext(t *testing.T) {
+	service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+	defer service.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Отменённый запрос не должен выдаваться за отсутствующую ссылку
+	_, err := service.GetFullURL(ctx, "missing")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// stubKeys выдаёт заранее заданные ключи по порядку
+type stubKeys struct {
+	keys []string
+}
+
+func (s *stubKeys) Next(_ context.Context) (string, error) {
+	key := s.keys[0]
+	if len(s.keys) > 1 {
+		s.keys = s.keys[1:]
+	}
+	return key, nil
+}
+
+func TestShortKeyGeneration(t *testing.T) {
+	configuration := config.ConfigStruct{ReservedAliases: []string{"api"}}
+
+	t.Run("Taken key is regenerated", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &configuration)
+		defer service.Close()
+
+		service.keys = &stubKeys{keys: []string{"aaa", "aaa", "bbb"}}
+		first, err := service.CreateShortURLWithOptions(context.Background(), "https://first.com", "user", model.LinkOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "aaa", first)
+
+		second, err := service.CreateShortURLWithOptions(context.Background(), "https://second.com", "user", model.LinkOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "bbb", second)
+	})
+
+	t.Run("Reserved key is skipped", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &configuration)
+		defer service.Close()
+
+		service.keys = &stubKeys{keys: []string{"API", "ccc"}}
+		shortURL, err := service.CreateShortURLWithOptions(context.Background(), "https://reserved.com", "user", model.LinkOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "ccc", shortURL)
+	})
+
+	t.Run("Probe routes are never generated", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		defer service.Close()
+
+		service.keys = &stubKeys{keys: []string{"readyz", "healthz", "ddd"}}
+		shortURL, err := service.CreateShortURLWithOptions(context.Background(), "https://probes.com", "user", model.LinkOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "ddd", shortURL)
+	})
+
+	t.Run("Attempts are limited", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &configuration)
+		defer service.Close()
 
-		fullURL, err := service.GetFullURL(shortURL)
+		service.keys = &stubKeys{keys: []string{"ddd"}}
+		_, err := service.CreateShortURLWithOptions(context.Background(), "https://one.com", "user", model.LinkOptions{})
 		assert.NoError(t, err)
-		assert.Equal(t, emptyURL, fullURL)
+
+		_, err = service.CreateShortURLWithOptions(context.Background(), "https://two.com", "user", model.LinkOptions{})
+		assert.ErrorIs(t, err, ErrKeyGeneration)
+
+		_, err = service.CreateShortURLsBatch(context.Background(), []model.ShortenItem{{OriginalURL: "https://three.com"}}, "user", model.BatchModeAtomic)
+		assert.ErrorIs(t, err, ErrKeyGeneration)
+	})
+
+	t.Run("Batch repeats within itself are regenerated", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &configuration)
+		defer service.Close()
+
+		service.keys = &stubKeys{keys: []string{"eee", "eee", "fff"}}
+		result, err := service.CreateShortURLsBatch(context.Background(), []model.ShortenItem{
+			{OriginalURL: "https://batch1.com"},
+			{OriginalURL: "https://batch2.com"},
+		}, "user", model.BatchModeAtomic)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"eee", "fff"}, []string{result[0].ShortURL, result[1].ShortURL})
 	})
 
-	t.Run("DB error", func(t *testing.T) {
-		err := service.PingPostgreSQL()
+	t.Run("Sequence strategy", func(t *testing.T) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{
+			KeyStrategy: config.KeyStrategySequence,
+			KeyAlphabet: "0123456789",
+		})
+		defer service.Close()
+
+		first, err := service.CreateShortURLWithOptions(context.Background(), "https://seq1.com", "user", model.LinkOptions{})
+		assert.NoError(t, err)
+		second, err := service.CreateShortURLWithOptions(context.Background(), "https://seq2.com", "user", model.LinkOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"1", "2"}, []string{first, second})
+	})
+}
+
+// pingRepository хранилище с заданным результатом проверки готовности
+type pingRepository struct {
+	repository.URLRepository
+	err error
+}
+
+func (r *pingRepository) Ping(_ context.Context) error {
+	return r.err
+}
+
+func TestReadiness(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		ready   bool
+		storage string
+	}{
+		{name: "storage up", ready: true, storage: model.ComponentUp},
+		{name: "storage degraded", err: repository.ErrDegraded, ready: true, storage: model.ComponentDegraded},
+		{name: "storage down", err: repository.ErrClosed, ready: false, storage: model.ComponentDown},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewURLShortnerService(&pingRepository{URLRepository: repository.NewMemoryRepository(), err: tc.err}, &config.ConfigStruct{})
+			defer service.Close()
+
+			readiness := service.Readiness(context.Background())
+			assert.Equal(t, tc.ready, readiness.Ready())
+			assert.Equal(t, tc.storage, readiness.Components["storage"].Status)
+			if tc.err != nil {
+				assert.Equal(t, tc.err.Error(), readiness.Components["storage"].Error)
+			}
+		})
+	}
+}
+
+func TestDeleteQueue(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	assert.NoError(t, repo.SetValue(ctx, "first", "https://first.com", "https://first.com", "user", nil, model.RedirectOptions{}))
+
+	// Обработчик ещё не запущен, поэтому очередь из одной задачи сразу заполняется
+	worker := &deleteWorker{repo: repo, tasks: make(chan deleteTask, 1), done: make(chan struct{})}
+	assert.NoError(t, worker.enqueue(deleteTask{userID: "user", shortURLs: []string{"first"}}))
+	assert.ErrorIs(t, worker.enqueue(deleteTask{userID: "user", shortURLs: []string{"second"}}), ErrDeleteQueueFull)
+
+	go worker.run()
+	worker.stop()
+	assert.ErrorIs(t, worker.enqueue(deleteTask{userID: "user", shortURLs: []string{"first"}}), ErrDeleteQueueClosed)
+
+	_, err := repo.GetFullValue(ctx, "first")
+	assert.ErrorIs(t, err, repository.ErrURLDeleted)
+}
+
+// stubResolver разрешает имена по заданной таблице
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if host == "dns-failure.com" {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]net.IPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.IPAddr{IP: net.ParseIP(ip)}
+	}
+	return addrs, nil
+}
+
+func TestCreateShortURLsBatchModes(t *testing.T) {
+	ctx := context.Background()
+	items := []model.ShortenItem{
+		{OriginalURL: "https://existing.com/"},
+		{OriginalURL: "https://new.com/"},
+		{OriginalURL: "javascript:alert(1)"},
+		{OriginalURL: "https://NEW.com"},
+		{OriginalURL: "https://alias.com/", Options: model.LinkOptions{Alias: "taken-alias"}},
+	}
+
+	setup := func(t *testing.T) (*URLShortnerService, string) {
+		service := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		t.Cleanup(func() { service.Close() })
+
+		existing, err := service.CreateShortURL(ctx, "https://existing.com/", "user")
+		assert.NoError(t, err)
+		_, err = service.CreateShortURLWithOptions(ctx, "https://other.com/", "user", model.LinkOptions{Alias: "taken-alias"})
+		assert.NoError(t, err)
+		return service, existing
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		service, _ := setup(t)
+
+		_, err := service.CreateShortURLsBatch(ctx, items, "user", model.BatchModeAtomic)
+		assert.ErrorIs(t, err, ErrURLRejected)
+		assert.ErrorContains(t, err, "item 2")
+
+		_, err = service.Repository.GetShortValue(ctx, "https://new.com/")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		service, existing := setup(t)
+
+		results, err := service.CreateShortURLsBatch(ctx, items, "user", model.BatchModeBestEffort)
+		assert.NoError(t, err)
+		assert.Len(t, results, len(items))
+
+		assert.Equal(t, model.BatchItemResult{Status: model.BatchStatusExists, ShortURL: existing}, results[0])
+		assert.Equal(t, model.BatchStatusCreated, results[1].Status)
+		assert.Equal(t, model.BatchStatusInvalid, results[2].Status)
+		assert.ErrorIs(t, results[2].Err, ErrURLRejected)
+		assert.Equal(t, model.BatchItemResult{Status: model.BatchStatusExists, ShortURL: results[1].ShortURL}, results[3])
+		assert.Equal(t, model.BatchStatusInvalid, results[4].Status)
+		assert.ErrorIs(t, results[4].Err, ErrAliasTaken)
+
+		fullURL, err := service.GetFullURL(ctx, results[1].ShortURL)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://new.com/", fullURL)
+	})
+}
+
+// racingRepository хранилище, в котором пакет конфликтует со ссылкой, удалённой до повторного поиска
+type racingRepository struct {
+	repository.URLRepository
+	raced        string
+	singleLookup int
+}
+
+func (r *racingRepository) GetShortValue(ctx context.Context, canonicalURL string) (string, error) {
+	r.singleLookup++
+	return r.URLRepository.GetShortValue(ctx, canonicalURL)
+}
+
+func (r *racingRepository) SetValue(ctx context.Context, shortURL, originalURL, canonicalURL, userID string, expiresAt *time.Time, redirect model.RedirectOptions) error {
+	if canonicalURL == r.raced {
+		return repository.ErrRowExists
+	}
+	return r.URLRepository.SetValue(ctx, shortURL, originalURL, canonicalURL, userID, expiresAt, redirect)
+}
+
+func (r *racingRepository) SetValuesBatch(ctx context.Context, pairs map[string]string, canonical map[string]string, userID string, expiresAt map[string]time.Time, redirects map[string]model.RedirectOptions) error {
+	for shortURL := range pairs {
+		if canonical[shortURL] == r.raced {
+			return repository.ErrRowExists
+		}
+	}
+	return r.URLRepository.SetValuesBatch(ctx, pairs, canonical, userID, expiresAt, redirects)
+}
+
+func TestCreateShortURLsBatchConflicts(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("items sharing a canonical URL", func(t *testing.T) {
+		for _, mode := range []model.BatchMode{model.BatchModeAtomic, model.BatchModeBestEffort} {
+			repo := &racingRepository{URLRepository: repository.NewMemoryRepository()}
+			service := NewURLShortnerService(repo, &config.ConfigStruct{})
+
+			_, err := service.CreateShortURL(ctx, "https://existing.com/", "user")
+			assert.NoError(t, err)
+
+			results, err := service.CreateShortURLsBatch(ctx, []model.ShortenItem{
+				{OriginalURL: "https://shared.com/a"},
+				{OriginalURL: "https://SHARED.com/a"},
+				{OriginalURL: "https://existing.com"},
+			}, "user", mode)
+			assert.NoError(t, err, mode)
+			if assert.Len(t, results, 3) {
+				assert.Equal(t, model.BatchStatusCreated, results[0].Status, mode)
+				assert.Equal(t, model.BatchItemResult{Status: model.BatchStatusExists, ShortURL: results[0].ShortURL}, results[1], mode)
+				assert.Equal(t, model.BatchStatusExists, results[2].Status, mode)
+			}
+
+			// Уже сокращённые ссылки определяются при записи, а не запросом на каждый элемент
+			assert.Zero(t, repo.singleLookup, mode)
+			service.Close()
+		}
+	})
+
+	t.Run("unresolved conflict", func(t *testing.T) {
+		repo := &racingRepository{URLRepository: repository.NewMemoryRepository(), raced: "https://raced.com/"}
+		service := NewURLShortnerService(repo, &config.ConfigStruct{})
+		defer service.Close()
+
+		items := []model.ShortenItem{
+			{OriginalURL: "https://raced.com/"},
+			{OriginalURL: "https://calm.com/"},
+		}
+
+		_, err := service.CreateShortURLsBatch(ctx, items, "user", model.BatchModeAtomic)
+		assert.ErrorIs(t, err, ErrBatchConflict)
+
+		results, err := service.CreateShortURLsBatch(ctx, items, "user", model.BatchModeBestEffort)
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.Equal(t, model.BatchStatusConflict, results[0].Status)
+			assert.ErrorIs(t, results[0].Err, ErrBatchConflict)
+			assert.Equal(t, model.BatchStatusCreated, results[1].Status)
+		}
+	})
+}
+
+func TestURLCanonicalization(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	service := NewURLShortnerService(repo, &config.ConfigStruct{URLSortQuery: true})
+	defer service.Close()
+
+	t.Run("equivalent links share a key", func(t *testing.T) {
+		first, err := service.CreateShortURL(ctx, "HTTP://Example.COM:80/docs/./guide/../intro%7e?b=2&a=1", "user")
+		assert.NoError(t, err)
+
+		for _, url := range []string{
+			"http://example.com/docs/intro~?a=1&b=2",
+			"http://example.com:80/docs/intro%7E?b=2&a=1",
+		} {
+			second, err := service.CreateShortURL(ctx, url, "user")
+			assert.ErrorIs(t, err, repository.ErrRowExists, url)
+			assert.Equal(t, first, second, url)
+		}
+
+		// Переход ведёт на ссылку в том виде, в котором её прислали первой
+		fullURL, err := service.GetFullURL(ctx, first)
+		assert.NoError(t, err)
+		assert.Equal(t, "HTTP://Example.COM:80/docs/./guide/../intro%7e?b=2&a=1", fullURL)
+	})
+
+	t.Run("international domain", func(t *testing.T) {
+		first, err := service.CreateShortURL(ctx, "https://пример.рф/путь", "user")
+		assert.NoError(t, err)
+
+		second, err := service.CreateShortURL(ctx, "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C", "user")
+		assert.ErrorIs(t, err, repository.ErrRowExists)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("alias for an already shortened link", func(t *testing.T) {
+		first, err := service.CreateShortURL(ctx, "https://alias.com/", "user")
+		assert.NoError(t, err)
+
+		shortURL, err := service.CreateShortURLWithOptions(ctx, "https://ALIAS.com", "user", model.LinkOptions{Alias: "my-alias"})
+		assert.ErrorIs(t, err, repository.ErrRowExists)
+		assert.Equal(t, first, shortURL)
+	})
+
+	t.Run("batch uses canonical form", func(t *testing.T) {
+		result, err := service.CreateShortURLsBatch(ctx, []model.ShortenItem{
+			{OriginalURL: "https://batch.com/x"},
+			{OriginalURL: "HTTPS://batch.com:443/x"},
+		}, "user", model.BatchModeAtomic)
+		assert.NoError(t, err)
+		assert.Equal(t, model.BatchStatusCreated, result[0].Status)
+		assert.Equal(t, model.BatchStatusExists, result[1].Status)
+		assert.Equal(t, result[0].ShortURL, result[1].ShortURL)
+
+		again, err := service.CreateShortURLsBatch(ctx, []model.ShortenItem{{OriginalURL: "https://Batch.com/x"}}, "user", model.BatchModeAtomic)
+		assert.NoError(t, err)
+		assert.Equal(t, model.BatchStatusExists, again[0].Status)
+		shortURL, err := repo.GetShortValue(ctx, "https://batch.com/x")
+		assert.NoError(t, err)
+		assert.Equal(t, again[0].ShortURL, shortURL)
+	})
+
+	t.Run("malformed link is rejected", func(t *testing.T) {
+		_, err := service.CreateShortURL(ctx, "http://exa mple.com/", "user")
+		assert.ErrorIs(t, err, ErrURLRejected)
+	})
+}
+
+func TestURLPolicy(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(blocklist, []byte("# фишинг\nEvil.com\n\nspam.org # рассылки\n"), 0644))
+
+	repo := repository.NewMemoryRepository()
+	service := NewURLShortnerService(repo, &config.ConfigStruct{
+		ShortAddress:      "https://sho.rt",
+		URLAllowedSchemes: []string{"http", "https", "mailto"},
+		URLResolveHosts:   true,
+		URLBlocklistPath:  blocklist,
+	})
+	defer service.Close()
+	service.urls.resolver = stubResolver{
+		"example.com":  {"93.184.216.34", "2606:2800:220:1::"},
+		"internal.com": {"93.184.216.34", "10.1.2.3"},
+		"metadata.com": {"169.254.169.254"},
+		"evil.com":     {"104.16.0.10"},
+		"bench.com":    {"93.184.216.34", "198.18.0.5"},
+	}
+
+	t.Run("allowed URLs", func(t *testing.T) {
+		for _, url := range []string{
+			"https://example.com/path?q=1",
+			"HTTP://Example.COM./",
+			"https://93.184.216.34/",
+			"mailto:owner@example.com",
+		} {
+			_, err := service.CreateShortURL(context.Background(), url, "user")
+			assert.NoError(t, err, url)
+		}
+	})
+
+	t.Run("rejected URLs", func(t *testing.T) {
+		for _, url := range []string{
+			"example.com/no-scheme",
+			"javascript:alert(1)",
+			"file:///etc/passwd",
+			"ftp://example.com/file",
+			"https:///no-host",
+			"http://127.0.0.1:8080/admin",
+			"http://[::1]/",
+			"http://[fe80::1%25eth0]/",
+			"http://10.0.0.1/",
+			"http://192.168.1.1/",
+			"http://169.254.169.254/latest/meta-data",
+			"http://100.64.0.1/",
+			"http://0.0.0.0/",
+			"http://0.1.2.3/",
+			"http://198.18.0.1/",
+			"http://192.0.0.1/",
+			"http://203.0.113.1/",
+			"http://240.0.0.1/",
+			"http://255.255.255.255/",
+			"http://[::]/",
+			"http://[fc00::1]/",
+			"http://[2001:db8::1]/",
+			"http://[64:ff9b::7f00:1]/",
+			"http://[2002:7f00:1::]/",
+			"http://[::ffff:127.0.0.1]/",
+			"http://2130706433/",
+			"http://0x7f.1/",
+			"http://localhost/",
+			"http://app.localhost/",
+			"https://example.com@127.0.0.1/",
+			"https://sho.rt/abc",
+			"https://SHO.RT./abc",
+			"https://evil.com/",
+			"https://login.evil.com/",
+			"https://spam.org/",
+			"https://internal.com/",
+			"https://metadata.com/",
+			"https://bench.com/",
+			"https://unknown-host.com/",
+		} {
+			shortURL, err := service.CreateShortURL(context.Background(), url, "user")
+			assert.ErrorIs(t, err, ErrURLRejected, url)
+			assert.Empty(t, shortURL, url)
+		}
+	})
+
+	t.Run("resolver failure is a rejection", func(t *testing.T) {
+		_, err := service.CreateShortURL(context.Background(), "https://dns-failure.com/", "user")
+		assert.ErrorIs(t, err, ErrURLRejected)
+		assert.ErrorContains(t, err, "could not be resolved")
+	})
+
+	t.Run("batch is rejected as a whole", func(t *testing.T) {
+		_, err := service.CreateShortURLsBatch(context.Background(), []model.ShortenItem{
+			{OriginalURL: "https://example.com/batch"},
+			{OriginalURL: "http://127.0.0.1/"},
+		}, "user", model.BatchModeAtomic)
+		assert.ErrorIs(t, err, ErrURLRejected)
+		assert.ErrorContains(t, err, "item 1")
+
+		_, err = repo.GetShortValue(context.Background(), "https://example.com/batch")
 		assert.Error(t, err)
 	})
+
+	t.Run("hosts are not resolved by default", func(t *testing.T) {
+		offline := NewURLShortnerService(repository.NewMemoryRepository(), &config.ConfigStruct{})
+		defer offline.Close()
+		offline.urls.resolver = stubResolver{}
+
+		_, err := offline.CreateShortURL(context.Background(), "https://unknown-host.com/", "user")
+		assert.NoError(t, err)
+
+		// Адреса, указанные явно, проверяются всегда
+		_, err = offline.CreateShortURL(context.Background(), "http://198.18.0.1/", "user")
+		assert.ErrorIs(t, err, ErrURLRejected)
+	})
+
+	t.Run("blocklist is reloaded on change", func(t *testing.T) {
+		service.urls.blocklist.checkInterval = 0
+
+		assert.NoError(t, os.WriteFile(blocklist, []byte("example.com\n"), 0644))
+		_, err := service.CreateShortURL(context.Background(), "https://example.com/reloaded", "user")
+		assert.ErrorIs(t, err, ErrURLRejected)
+
+		// Домен, убранный из списка, снова можно сокращать
+		_, err = service.CreateShortURL(context.Background(), "https://evil.com/reloaded", "user")
+		assert.NoError(t, err)
+
+		assert.NoError(t, os.Remove(blocklist))
+		_, err = service.CreateShortURL(context.Background(), "https://example.com/unblocked", "user")
+		assert.NoError(t, err)
+	})
 }
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/config"
+	"github.com/Ilya-c4talyst/go-advanced-shortner/internal/urlnorm"
+)
+
+// resolveTimeout ограничение времени разрешения имени хоста при проверке ссылки
+const resolveTimeout = 2 * time.Second
+
+// blocklistCheckInterval как часто проверяется изменение файла заблокированных доменов
+const blocklistCheckInterval = 5 * time.Second
+
+// resolver разрешает имена хостов, в тестах подменяется заглушкой
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// urlPolicy ограничения для сокращаемых ссылок
+type urlPolicy struct {
+	schemes   map[string]struct{}
+	selfHost  string
+	resolve   bool
+	resolver  resolver
+	blocklist *domainBlocklist
+	normalize urlnorm.Options
+}
+
+// newURLPolicy создаёт ограничения из конфигурации, незаданные значения берутся по умолчанию
+func newURLPolicy(configuration *config.ConfigStruct) *urlPolicy {
+	policy := &urlPolicy{
+		schemes:  make(map[string]struct{}),
+		resolver: net.DefaultResolver,
+	}
+
+	schemes := config.DefaultURLAllowedSchemes
+	if configuration != nil {
+		if configuration.URLAllowedSchemes != nil {
+			schemes = configuration.URLAllowedSchemes
+		}
+		if short, err := url.Parse(configuration.ShortAddress); err == nil {
+			policy.selfHost = normalizeHost(short.Hostname())
+		}
+		policy.resolve = configuration.URLResolveHosts
+		policy.normalize.SortQuery = configuration.URLSortQuery
+		if configuration.URLBlocklistPath != "" {
+			policy.blocklist = newDomainBlocklist(configuration.URLBlocklistPath)
+		}
+	}
+
+	for _, scheme := range schemes {
+		policy.schemes[strings.ToLower(scheme)] = struct{}{}
+	}
+
+	return policy
+}
+
+// canonicalize приводит ссылку к канонической форме и проверяет её
+// Проверяется каноническая форма, чтобы запись хоста в другом регистре или в юникоде не обходила ограничения
+func (p *urlPolicy) canonicalize(ctx context.Context, rawURL string) (string, error) {
+	canonical, err := urlnorm.Canonicalize(rawURL, p.normalize)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrURLRejected, err)
+	}
+	if err := p.validate(ctx, canonical); err != nil {
+		return "", err
+	}
+	return canonical, nil
+}
+
+// validate проверяет схему ссылки, адрес назначения, петлю на сам сервис и список заблокированных доменов
+func (p *urlPolicy) validate(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: malformed URL", ErrURLRejected)
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	if _, ok := p.schemes[scheme]; !ok {
+		if scheme == "" {
+			return fmt.Errorf("%w: scheme is missing", ErrURLRejected)
+		}
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrURLRejected, scheme)
+	}
+
+	// Ссылки без хоста, например mailto:, проверяются только по схеме
+	if parsed.Opaque != "" {
+		return nil
+	}
+	host := normalizeHost(parsed.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: host is missing", ErrURLRejected)
+	}
+
+	if p.selfHost != "" && host == p.selfHost {
+		return fmt.Errorf("%w: link points back to this service", ErrURLRejected)
+	}
+
+	if p.blocklist != nil && p.blocklist.contains(host) {
+		return fmt.Errorf("%w: domain %q is blocked", ErrURLRejected, host)
+	}
+
+	return p.validateHost(ctx, host)
+}
+
+// validateHost запрещает адреса внутренних сетей, указанные явно или полученные через DNS
+func (p *urlPolicy) validateHost(ctx context.Context, host string) error {
+	// Зона IPv6-адреса вроде fe80::1%eth0 на проверку не влияет
+	literal, _, _ := strings.Cut(host, "%")
+	if ip := net.ParseIP(literal); ip != nil {
+		if isInternalIP(ip) {
+			return fmt.Errorf("%w: address %s is not public", ErrURLRejected, ip)
+		}
+		return nil
+	}
+
+	// Браузеры считают адресом и числовые формы вроде 2130706433 или 0x7f.1
+	if isNumericHost(host) {
+		return fmt.Errorf("%w: non-canonical IP address %q", ErrURLRejected, host)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: host %q is not public", ErrURLRejected, host)
+	}
+
+	if !p.resolve {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return fmt.Errorf("%w: host %q does not resolve", ErrURLRejected, host)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: host %q could not be resolved: %v", ErrURLRejected, host, err)
+	}
+
+	// Достаточно одного внутреннего адреса: клиент может выбрать любой из них
+	for _, addr := range addrs {
+		if isInternalIP(addr.IP) {
+			return fmt.Errorf("%w: host %q resolves to non-public address %s", ErrURLRejected, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// reservedNetworks служебные диапазоны, не покрытые проверками из пакета net
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // «эта» сеть, RFC 791
+	"100.64.0.0/10",   // операторский NAT, RFC 6598
+	"192.0.0.0/24",    // служебные назначения IETF, RFC 6890
+	"192.0.2.0/24",    // документация, RFC 5737
+	"198.18.0.0/15",   // тестирование производительности, RFC 2544
+	"198.51.100.0/24", // документация, RFC 5737
+	"203.0.113.0/24",  // документация, RFC 5737
+	"240.0.0.0/4",     // зарезервировано и широковещательный адрес, RFC 1112
+	"64:ff9b::/96",    // трансляция NAT64, RFC 6052
+	"64:ff9b:1::/48",  // локальная трансляция NAT64, RFC 8215
+	"100::/64",        // сброс трафика, RFC 6666
+	"2001::/23",       // служебные назначения IETF, включая Teredo, RFC 2928
+	"2001:db8::/32",   // документация, RFC 3849
+	"2002::/16",       // 6to4 со встроенным IPv4-адресом, RFC 3056
+	"fec0::/10",       // устаревшие site-local адреса, RFC 3879
+)
+
+// mustParseCIDRs разбирает список подсетей, ошибка означает опечатку в коде
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// isInternalIP проверяет, относится ли адрес к локальным, частным или служебным сетям
+func isInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return true
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNumericHost проверяет, является ли последняя метка хоста числом, как в записи IPv4-адреса
+func isNumericHost(host string) bool {
+	label := host[strings.LastIndex(host, ".")+1:]
+	if label == "" {
+		return false
+	}
+	if hex, ok := strings.CutPrefix(label, "0x"); ok {
+		return strings.Trim(hex, "0123456789abcdef") == ""
+	}
+	return strings.Trim(label, "0123456789") == ""
+}
+
+// normalizeHost приводит имя хоста к нижнему регистру без завершающей точки
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// domainBlocklist список заблокированных доменов из файла, перечитывается при изменении файла
+// Домен в списке блокирует и все свои поддомены
+type domainBlocklist struct {
+	path          string
+	checkInterval time.Duration
+
+	mu        sync.RWMutex
+	domains   map[string]struct{}
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// newDomainBlocklist загружает список из файла, отсутствующий файл означает пустой список
+func newDomainBlocklist(path string) *domainBlocklist {
+	b := &domainBlocklist{
+		path:          path,
+		checkInterval: blocklistCheckInterval,
+		domains:       make(map[string]struct{}),
+	}
+	b.reload(time.Now())
+	return b
+}
+
+// contains проверяет, заблокирован ли хост или один из его родительских доменов
+func (b *domainBlocklist) contains(host string) bool {
+	b.refresh()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for {
+		if _, ok := b.domains[host]; ok {
+			return true
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			return false
+		}
+		host = host[dot+1:]
+	}
+}
+
+// refresh перечитывает файл, если с последней проверки прошёл интервал
+func (b *domainBlocklist) refresh() {
+	now := time.Now()
+	b.mu.RLock()
+	due := now.Sub(b.lastCheck) >= b.checkInterval
+	b.mu.RUnlock()
+
+	if due {
+		b.reload(now)
+	}
+}
+
+// reload загружает файл заново, если изменились его время модификации или размер
+// При ошибке чтения сохраняется прежний список
+func (b *domainBlocklist) reload(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastCheck = now
+	info, err := os.Stat(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if len(b.domains) > 0 || !b.modTime.IsZero() {
+			log.Printf("Файл заблокированных доменов %s удалён, список очищен", b.path)
+		}
+		b.domains = make(map[string]struct{})
+		b.modTime, b.size = time.Time{}, 0
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка проверки файла заблокированных доменов %s: %v", b.path, err)
+		return
+	}
+	if info.ModTime().Equal(b.modTime) && info.Size() == b.size {
+		return
+	}
+
+	domains, err := readDomainList(b.path)
+	if err != nil {
+		log.Printf("Ошибка чтения файла заблокированных доменов %s: %v", b.path, err)
+		return
+	}
+
+	b.domains = domains
+	b.modTime, b.size = info.ModTime(), info.Size()
+	log.Printf("Загружено заблокированных доменов: %d", len(domains))
+}
+
+// readDomainList читает домены по одному в строке, пустые строки и комментарии после # пропускаются
+func readDomainList(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if domain := normalizeHost(strings.TrimSpace(line)); domain != "" {
+			domains[domain] = struct{}{}
+		}
+	}
+	return domains, scanner.Err()
+}
@@ -0,0 +1,218 @@
+// Package urlnorm приводит ссылки к канонической форме, чтобы одинаковые адреса в разной записи совпадали
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidURL ошибка, которая возникает, когда ссылку не удаётся разобрать
+var ErrInvalidURL = errors.New("invalid URL")
+
+// defaultPorts порты, которые подразумеваются схемой и не записываются в каноническую форму
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Options параметры приведения
+type Options struct {
+	// SortQuery сортирует параметры запроса по имени, порядок одноимённых параметров сохраняется
+	SortQuery bool
+}
+
+// Canonicalize возвращает каноническую форму ссылки:
+// схема и хост в нижнем регистре, хост в punycode, без порта по умолчанию,
+// путь без сегментов . и .., экранирование только там, где оно нужно, в верхнем регистре
+func Canonicalize(rawURL string, opts Options) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	// Ссылки без иерархической части, например mailto:, сравниваются как есть
+	if u.Opaque != "" {
+		return u.String(), nil
+	}
+
+	if u.Host != "" {
+		host, err := canonicalHost(u.Hostname())
+		if err != nil {
+			return "", err
+		}
+		port := u.Port()
+		if port == defaultPorts[u.Scheme] {
+			port = ""
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if port != "" {
+			host += ":" + port
+		}
+		u.Host = host
+	}
+
+	path := removeDotSegments(normalizeEscapes(u.EscapedPath(), pathSafe))
+	if path == "" && u.Host != "" {
+		path = "/"
+	}
+	setEscapedPath(u, path)
+
+	query := normalizeEscapes(u.RawQuery, querySafe)
+	if opts.SortQuery {
+		query = sortQuery(query)
+	}
+	// Пустой запрос после ? не отличается от его отсутствия
+	u.RawQuery = query
+	u.ForceQuery = false
+
+	if u.Fragment != "" {
+		u.RawFragment = normalizeEscapes(u.EscapedFragment(), querySafe)
+	}
+
+	return u.String(), nil
+}
+
+// canonicalHost приводит имя хоста к нижнему регистру и переводит интернациональный домен в punycode
+func canonicalHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return strings.ToLower(host), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: host %q: %v", ErrInvalidURL, host, err)
+	}
+	return ascii, nil
+}
+
+// setEscapedPath записывает путь в экранированном виде, сохраняя экранирование, которое нельзя раскрыть
+func setEscapedPath(u *url.URL, escaped string) {
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return
+	}
+	u.Path = path
+	u.RawPath = escaped
+	// url.URL хранит RawPath, только если он отличается от стандартного экранирования Path
+	if u.EscapedPath() != escaped {
+		u.RawPath = ""
+	}
+}
+
+// Наборы символов, которые остаются неэкранированными, RFC 3986
+const (
+	unreserved = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~"
+	subDelims  = "!$&'()*+,;="
+	pathSafe   = unreserved + subDelims + ":@/"
+	querySafe  = pathSafe + "?"
+)
+
+// normalizeEscapes раскрывает экранированные незарезервированные символы, переводит остальное экранирование
+// в верхний регистр и экранирует символы, которые нельзя оставить как есть
+func normalizeEscapes(s, safe string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if strings.IndexByte(unreserved, decoded) >= 0 {
+				b.WriteByte(decoded)
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(hex[decoded>>4])
+				b.WriteByte(hex[decoded&0x0f])
+			}
+			i += 2
+		case c != '%' && strings.IndexByte(safe, c) >= 0:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0f])
+		}
+	}
+	return b.String()
+}
+
+// removeDotSegments убирает из пути сегменты . и .., RFC 3986, раздел 5.2.4
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, ".") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			// Корень пути не удаляется
+			if len(out) > 1 || (len(out) == 1 && out[0] != "") {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+
+	result := strings.Join(out, "/")
+	if strings.HasPrefix(path, "/") && !strings.HasPrefix(result, "/") {
+		result = "/" + result
+	}
+	return result
+}
+
+// sortQuery сортирует параметры запроса по имени без изменения их записи
+func sortQuery(query string) string {
+	if query == "" {
+		return query
+	}
+
+	params := strings.Split(query, "&")
+	sort.SliceStable(params, func(i, j int) bool {
+		return queryKey(params[i]) < queryKey(params[j])
+	})
+	return strings.Join(params, "&")
+}
+
+// queryKey возвращает имя параметра запроса
+func queryKey(param string) string {
+	key, _, _ := strings.Cut(param, "=")
+	return key
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,55 @@
+package urlnorm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		opts Options
+		want string
+	}{
+		{name: "scheme and host case", raw: "HTTPS://Example.COM/Path", want: "https://example.com/Path"},
+		{name: "default http port", raw: "http://example.com:80/a", want: "http://example.com/a"},
+		{name: "default https port", raw: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "non-default port", raw: "http://example.com:8080/a", want: "http://example.com:8080/a"},
+		{name: "empty path", raw: "https://example.com", want: "https://example.com/"},
+		{name: "dot segments", raw: "https://example.com/a/./b/../c/", want: "https://example.com/a/c/"},
+		{name: "dot segments above root", raw: "https://example.com/../../a", want: "https://example.com/a"},
+		{name: "unreserved escapes", raw: "https://example.com/%7euser/%41", want: "https://example.com/~user/A"},
+		{name: "escape case", raw: "https://example.com/a%2fb?q=%3d", want: "https://example.com/a%2Fb?q=%3D"},
+		{name: "unicode path", raw: "https://example.com/путь", want: "https://example.com/%D0%BF%D1%83%D1%82%D1%8C"},
+		{name: "international domain", raw: "https://Пример.РФ/", want: "https://xn--e1afmkfd.xn--p1ai/"},
+		{name: "ipv6 host", raw: "http://[2001:DB8::1]:80/", want: "http://[2001:db8::1]/"},
+		{name: "empty query", raw: "https://example.com/a?", want: "https://example.com/a"},
+		{name: "query order kept", raw: "https://example.com/?b=2&a=1", want: "https://example.com/?b=2&a=1"},
+		{name: "query sorted", raw: "https://example.com/?b=2&a=1&b=1", opts: Options{SortQuery: true}, want: "https://example.com/?a=1&b=2&b=1"},
+		{name: "fragment", raw: "https://example.com/#%7eTop", want: "https://example.com/#~Top"},
+		{name: "opaque", raw: "MAILTO:User@Example.com", want: "mailto:User@Example.com"},
+		{name: "surrounding spaces", raw: "  https://example.com/a  ", want: "https://example.com/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize(tt.raw, tt.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// Каноническая форма не меняется при повторном приведении
+			again, err := Canonicalize(got, tt.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, got, again)
+		})
+	}
+
+	t.Run("invalid URL", func(t *testing.T) {
+		for _, raw := range []string{"http://exa mple.com/", "http://[::1/", "https://xn--a.com/"} {
+			_, err := Canonicalize(raw, Options{})
+			assert.ErrorIs(t, err, ErrInvalidURL, raw)
+		}
+	})
+}
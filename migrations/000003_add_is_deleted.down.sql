@@ -0,0 +1,2 @@
+-- +migrate Down
+ALTER TABLE urls DROP COLUMN is_deleted;
@@ -0,0 +1,2 @@
+-- +migrate Up
+ALTER TABLE urls ADD COLUMN is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
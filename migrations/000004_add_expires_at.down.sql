@@ -0,0 +1,3 @@
+-- +migrate Down
+DROP INDEX IF EXISTS idx_urls_expires_at;
+ALTER TABLE urls DROP COLUMN expires_at;
@@ -0,0 +1,4 @@
+-- +migrate Up
+ALTER TABLE urls ADD COLUMN expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_urls_expires_at ON urls(expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- +migrate Down
+DROP INDEX IF EXISTS idx_clicks_short_url_clicked_at;
+DROP TABLE IF EXISTS clicks;
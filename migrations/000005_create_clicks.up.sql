@@ -0,0 +1,11 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_url VARCHAR(255) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT,
+    user_agent TEXT,
+    ip_hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX idx_clicks_short_url_clicked_at ON clicks(short_url, clicked_at);
@@ -0,0 +1,2 @@
+-- +migrate Down
+ALTER TABLE urls DROP COLUMN deleted_at;
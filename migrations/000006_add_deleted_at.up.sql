@@ -0,0 +1,2 @@
+-- +migrate Up
+ALTER TABLE urls ADD COLUMN deleted_at TIMESTAMPTZ;
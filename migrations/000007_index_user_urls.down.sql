@@ -0,0 +1,3 @@
+-- +migrate Down
+DROP INDEX IF EXISTS idx_urls_user_created;
+ALTER TABLE urls ALTER COLUMN created_at DROP NOT NULL;
@@ -0,0 +1,5 @@
+-- +migrate Up
+UPDATE urls SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+ALTER TABLE urls ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX idx_urls_user_created ON urls(user_id, created_at, short_url);
@@ -0,0 +1,2 @@
+-- +migrate Down
+DROP SEQUENCE IF EXISTS short_url_seq;
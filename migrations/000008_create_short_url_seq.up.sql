@@ -0,0 +1,2 @@
+-- +migrate Up
+CREATE SEQUENCE IF NOT EXISTS short_url_seq START WITH 1;
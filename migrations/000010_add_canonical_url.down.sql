@@ -0,0 +1,4 @@
+-- +migrate Down
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_original_url ON urls(original_url);
+DROP INDEX IF EXISTS idx_urls_canonical_url;
+ALTER TABLE urls DROP COLUMN canonical_url;
@@ -0,0 +1,8 @@
+-- +migrate Up
+-- Ссылки, сохранённые раньше, сравниваются в присланном виде
+ALTER TABLE urls ADD COLUMN canonical_url TEXT;
+UPDATE urls SET canonical_url = original_url WHERE canonical_url IS NULL;
+ALTER TABLE urls ALTER COLUMN canonical_url SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_urls_canonical_url ON urls(canonical_url);
+DROP INDEX IF EXISTS idx_urls_original_url;
@@ -0,0 +1,2 @@
+-- +migrate Down
+ALTER TABLE urls DROP COLUMN IF EXISTS redirect_code;
@@ -0,0 +1,4 @@
+-- +migrate Up
+-- 0 означает код перенаправления по умолчанию из конфигурации сервера
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_code SMALLINT NOT NULL DEFAULT 0
+    CHECK (redirect_code IN (0, 301, 302, 307, 308));
@@ -0,0 +1,3 @@
+-- +migrate Down
+ALTER TABLE urls DROP COLUMN IF EXISTS path_passthrough;
+ALTER TABLE urls DROP COLUMN IF EXISTS query_merge;
@@ -0,0 +1,5 @@
+-- +migrate Up
+-- Пустой query_merge означает, что параметры запроса перехода не передаются в оригинальный URL
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS query_merge TEXT NOT NULL DEFAULT ''
+    CHECK (query_merge IN ('', 'keep', 'override', 'append'));
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS path_passthrough BOOLEAN NOT NULL DEFAULT false;
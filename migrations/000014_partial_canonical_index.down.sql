@@ -0,0 +1,9 @@
+-- +migrate Down
+-- Полный уникальный индекс вмещает одну ссылку на оригинальный URL: удалённые повторы удаляются
+DELETE FROM urls u
+ WHERE u.is_deleted
+   AND EXISTS (SELECT 1 FROM urls o
+                WHERE o.canonical_url = u.canonical_url AND o.id <> u.id
+                  AND (NOT o.is_deleted OR o.id > u.id));
+DROP INDEX IF EXISTS idx_urls_canonical_url;
+CREATE UNIQUE INDEX idx_urls_canonical_url ON urls(canonical_url);
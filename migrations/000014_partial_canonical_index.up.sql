@@ -0,0 +1,4 @@
+-- +migrate Up
+-- Удалённые ссылки не занимают оригинальный URL, его можно сократить заново
+DROP INDEX IF EXISTS idx_urls_canonical_url;
+CREATE UNIQUE INDEX idx_urls_canonical_url ON urls(canonical_url) WHERE NOT is_deleted;
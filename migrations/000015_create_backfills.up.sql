@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Завершённые переносы данных, которые выполняет сервис, а не миграция
+CREATE TABLE IF NOT EXISTS backfills (
+    name VARCHAR(64) PRIMARY KEY,
+    completed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
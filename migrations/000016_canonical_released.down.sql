@@ -0,0 +1,8 @@
+-- +migrate Down
+-- Индекс без признака освобождения вмещает одну неудалённую ссылку: освободившие URL ссылки помечаются удалёнными
+UPDATE urls SET is_deleted = TRUE, deleted_at = COALESCE(deleted_at, NOW()) WHERE canonical_released AND NOT is_deleted;
+
+DROP INDEX IF EXISTS idx_urls_canonical_url;
+CREATE UNIQUE INDEX idx_urls_canonical_url ON urls(canonical_url) WHERE NOT is_deleted;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS canonical_released;
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Истёкшая ссылка освобождает оригинальный URL, но остаётся до очистки: владелец получает 410 и видит её в списке
+ALTER TABLE urls ADD COLUMN canonical_released BOOLEAN NOT NULL DEFAULT FALSE;
+
+DROP INDEX IF EXISTS idx_urls_canonical_url;
+CREATE UNIQUE INDEX idx_urls_canonical_url ON urls(canonical_url) WHERE NOT is_deleted AND NOT canonical_released;
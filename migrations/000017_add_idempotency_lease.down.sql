@@ -0,0 +1,2 @@
+-- +migrate Down
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS locked_until;
@@ -0,0 +1,3 @@
+-- +migrate Up
+-- Незавершённый запрос удерживает ключ до locked_until, а не весь срок хранения ответа
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TIMESTAMPTZ;